@@ -1 +1 @@
-go run main.go
\ No newline at end of file
+go run .
\ No newline at end of file
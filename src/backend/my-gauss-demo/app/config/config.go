@@ -0,0 +1,479 @@
+// config/config.go
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultPath 未设置 GAUSS_CONFIG 时读取的配置文件，不存在时只使用默认值和环境变量
+const DefaultPath = "config.yaml"
+
+// Config 服务配置。加载顺序：默认值 -> 配置文件 -> 环境变量，后者覆盖前者
+type Config struct {
+	// Listen HTTP 监听地址
+	Listen string `yaml:"listen"`
+	// Store 存储后端：gauss（默认）或 memory
+	Store string `yaml:"store"`
+	// Password / PasswordFile 所有分片的默认密码，DSN 中已有密码的分片不受影响
+	Password     string   `yaml:"password"`
+	PasswordFile string   `yaml:"password_file"`
+	Pool         Pool     `yaml:"pool"`
+	Shards       []Shard  `yaml:"shards"`
+	Timeouts     Timeouts `yaml:"timeouts"`
+	Health       Health   `yaml:"health"`
+	Retry        Retry    `yaml:"retry"`
+	// Datasets 除内置的 user、document、permission、content 之外的数据集
+	Datasets []Dataset `yaml:"datasets"`
+}
+
+// Shard 一个 openGauss 实例。分片顺序决定分片 ID（表后缀），已有数据时不要调整顺序
+type Shard struct {
+	Name string `yaml:"name"`
+	// DSN lib/pq 连接串（key=value 或 postgres:// URL），也可以用 DSNFile 从文件读取
+	DSN          string `yaml:"dsn"`
+	DSNFile      string `yaml:"dsn_file"`
+	Password     string `yaml:"password"`
+	PasswordFile string `yaml:"password_file"`
+	// Replicas 只读副本的 DSN，查询接口优先从副本读取；未写密码时使用与主库相同的密码
+	Replicas []string `yaml:"replicas"`
+	// Pool 覆盖全局连接池配置，未设置的字段沿用全局值
+	Pool *Pool `yaml:"pool"`
+}
+
+// Pool 连接池配置，0 表示使用 database/sql 的默认值
+type Pool struct {
+	MaxOpenConns    int      `yaml:"max_open_conns"`
+	MaxIdleConns    int      `yaml:"max_idle_conns"`
+	ConnMaxLifetime Duration `yaml:"conn_max_lifetime"`
+}
+
+// Timeouts 各类超时，0 表示不限制
+type Timeouts struct {
+	Read  Duration `yaml:"read"`
+	Write Duration `yaml:"write"`
+	Idle  Duration `yaml:"idle"`
+	// Scatter 一次全分片并发查询的总超时，0 表示只受 Request / Endpoints 的处理时限限制
+	Scatter Duration `yaml:"scatter"`
+	// Request 每个请求的处理时限，到期后取消该请求上尚未完成的数据库查询
+	Request Duration `yaml:"request"`
+	// Endpoints 按路径覆盖 Request，例如 /api/dataset/write_json: 60s
+	Endpoints map[string]Duration `yaml:"endpoints"`
+}
+
+// For 返回路径 path 的请求处理时限，0 表示不限制
+func (t Timeouts) For(path string) time.Duration {
+	if d, ok := t.Endpoints[path]; ok {
+		return d.Std()
+	}
+	return t.Request.Std()
+}
+
+// Health 分片健康检查和熔断
+type Health struct {
+	// Interval 后台健康检查的间隔
+	Interval Duration `yaml:"interval"`
+	// Timeout 单次健康检查的超时
+	Timeout Duration `yaml:"timeout"`
+	// FailureThreshold 连续多少次连接失败后熔断该分片；熔断期间请求立即失败，
+	// 直到健康检查再次成功
+	FailureThreshold int `yaml:"failure_threshold"`
+}
+
+// Retry 瞬时错误（连接中断、序列化失败、死锁）的重试，等待时间按指数退避并加随机抖动
+type Retry struct {
+	// MaxAttempts 最多执行的次数（含第一次），1 表示不重试
+	MaxAttempts int      `yaml:"max_attempts"`
+	BaseDelay   Duration `yaml:"base_delay"`
+	MaxDelay    Duration `yaml:"max_delay"`
+}
+
+// Dataset 一个数据集的声明：启动时自动建表，并可通过 /api/dataset 的通用接口读写
+type Dataset struct {
+	Name string `yaml:"name"`
+	// Aliases 接口中也可以使用的其他名称
+	Aliases    []string `yaml:"aliases"`
+	Columns    []Column `yaml:"columns"`
+	PrimaryKey []string `yaml:"primary_key"`
+	// ShardBy 分片键：省略时不分片，存放在第一个分片上；room_id 表示随房间存放（按房间目录定位，
+	// rebalance 时随房间迁移）；其他列按该列的一致性哈希分布。分片键必须是主键的一部分
+	ShardBy string `yaml:"shard_by"`
+}
+
+// Column 数据集的一列，Type 为 openGauss 列类型，如 VARCHAR(64)、INT、TIMESTAMP
+type Column struct {
+	Name string `yaml:"name"`
+	Type string `yaml:"type"`
+}
+
+// Duration 支持 "30s"、"5m" 写法的时长
+type Duration time.Duration
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	v, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return fmt.Errorf("invalid duration %q: %v", value.Value, err)
+	}
+	*d = Duration(v)
+	return nil
+}
+
+func (d Duration) Std() time.Duration {
+	return time.Duration(d)
+}
+
+// Default 默认配置：本地 docker-compose 中的 og1、og2，不含密码
+func Default() *Config {
+	return &Config{
+		Listen: ":8080",
+		Store:  "gauss",
+		Shards: []Shard{
+			{Name: "og1", DSN: "host=localhost port=5432 user=gaussdb dbname=postgres sslmode=disable"},
+			{Name: "og2", DSN: "host=localhost port=5433 user=gaussdb dbname=postgres sslmode=disable"},
+		},
+		Timeouts: Timeouts{
+			Read: Duration(15 * time.Second),
+			// 写超时默认不限制，否则 NDJSON 全表导出会被中途切断
+			Idle:    Duration(60 * time.Second),
+			Request: Duration(10 * time.Second),
+			Endpoints: map[string]Duration{
+				// 整表写入和 NDJSON 全表导出耗时与表大小有关
+				"/api/dataset/write_json": Duration(60 * time.Second),
+				"/api/dataset/read_json":  0,
+			},
+		},
+		Health: Health{
+			Interval:         Duration(5 * time.Second),
+			Timeout:          Duration(2 * time.Second),
+			FailureThreshold: 3,
+		},
+		Retry: Retry{
+			MaxAttempts: 3,
+			BaseDelay:   Duration(50 * time.Millisecond),
+			MaxDelay:    Duration(time.Second),
+		},
+	}
+}
+
+// Load 按 GAUSS_CONFIG（默认 config.yaml）加载配置，应用环境变量覆盖，读取密钥文件并校验
+func Load() (*Config, error) {
+	path, explicit := Path()
+	return LoadFile(path, explicit)
+}
+
+// Path 返回配置文件路径；explicit 表示由 GAUSS_CONFIG 指定，此时文件必须存在
+func Path() (path string, explicit bool) {
+	if p := os.Getenv("GAUSS_CONFIG"); p != "" {
+		return p, true
+	}
+	return DefaultPath, false
+}
+
+// LoadFile 从指定文件加载配置；required 为 false 时文件不存在视为空配置
+func LoadFile(path string, required bool) (*Config, error) {
+	cfg := Default()
+
+	data, err := os.ReadFile(path)
+	if err != nil && (required || !errors.Is(err, os.ErrNotExist)) {
+		return nil, fmt.Errorf("read config %s failed: %v", path, err)
+	}
+	if err == nil {
+		dec := yaml.NewDecoder(bytes.NewReader(data))
+		dec.KnownFields(true)
+		if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+			return nil, fmt.Errorf("parse config %s failed: %v", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	if err := cfg.resolveSecrets(); err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// applyEnv 环境变量覆盖配置文件。每个变量都可以改用 <NAME>_FILE 从文件读取（docker secrets）
+func (c *Config) applyEnv() error {
+	strs := []struct {
+		name string
+		dst  *string
+	}{
+		{"GAUSS_LISTEN", &c.Listen},
+		{"GAUSS_STORE", &c.Store},
+		{"GAUSS_PASSWORD", &c.Password},
+	}
+	for _, s := range strs {
+		v, err := getenv(s.name)
+		if err != nil {
+			return err
+		}
+		if v != "" {
+			*s.dst = v
+		}
+	}
+
+	ints := []struct {
+		name string
+		dst  *int
+	}{
+		{"GAUSS_MAX_OPEN_CONNS", &c.Pool.MaxOpenConns},
+		{"GAUSS_MAX_IDLE_CONNS", &c.Pool.MaxIdleConns},
+		{"GAUSS_RETRY_MAX_ATTEMPTS", &c.Retry.MaxAttempts},
+	}
+	for _, s := range ints {
+		v, err := getenv(s.name)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: invalid integer %q", s.name, v)
+		}
+		*s.dst = n
+	}
+
+	durations := []struct {
+		name string
+		dst  *Duration
+	}{
+		{"GAUSS_CONN_MAX_LIFETIME", &c.Pool.ConnMaxLifetime},
+		{"GAUSS_READ_TIMEOUT", &c.Timeouts.Read},
+		{"GAUSS_WRITE_TIMEOUT", &c.Timeouts.Write},
+		{"GAUSS_IDLE_TIMEOUT", &c.Timeouts.Idle},
+		{"GAUSS_SCATTER_TIMEOUT", &c.Timeouts.Scatter},
+		{"GAUSS_REQUEST_TIMEOUT", &c.Timeouts.Request},
+		{"GAUSS_RETRY_BASE_DELAY", &c.Retry.BaseDelay},
+		{"GAUSS_RETRY_MAX_DELAY", &c.Retry.MaxDelay},
+	}
+	for _, s := range durations {
+		v, err := getenv(s.name)
+		if err != nil {
+			return err
+		}
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("%s: invalid duration %q", s.name, v)
+		}
+		*s.dst = Duration(d)
+	}
+
+	// GAUSS_SHARD_DSNS 替换整个分片列表，多个 DSN 用 ';' 分隔，分片依次命名为 og1、og2……
+	env, err := getenv("GAUSS_SHARD_DSNS")
+	if err != nil {
+		return err
+	}
+	if env = strings.TrimSpace(env); env != "" {
+		var shards []Shard
+		for _, dsn := range strings.Split(env, ";") {
+			dsn = strings.TrimSpace(dsn)
+			if dsn == "" {
+				continue
+			}
+			shards = append(shards, Shard{Name: fmt.Sprintf("og%d", len(shards)+1), DSN: dsn})
+		}
+		c.Shards = shards
+	}
+	return nil
+}
+
+// resolveSecrets 读取 dsn_file / password_file，并把密码写入 DSN
+func (c *Config) resolveSecrets() error {
+	if c.PasswordFile != "" {
+		p, err := readSecret(c.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.Password = p
+	}
+
+	for i := range c.Shards {
+		s := &c.Shards[i]
+		if s.DSNFile != "" {
+			dsn, err := readSecret(s.DSNFile)
+			if err != nil {
+				return err
+			}
+			s.DSN = dsn
+		}
+		if s.PasswordFile != "" {
+			p, err := readSecret(s.PasswordFile)
+			if err != nil {
+				return err
+			}
+			s.Password = p
+		}
+
+		password := s.Password
+		if password == "" {
+			password = c.Password
+		}
+		if password != "" {
+			dsn, err := withPassword(s.DSN, password)
+			if err != nil {
+				return fmt.Errorf("shard %s: %v", s.Name, err)
+			}
+			s.DSN = dsn
+			for j, r := range s.Replicas {
+				if s.Replicas[j], err = withPassword(r, password); err != nil {
+					return fmt.Errorf("shard %s replica #%d: %v", s.Name, j+1, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Validate 检查配置是否完整、一致
+func (c *Config) Validate() error {
+	if c.Listen == "" {
+		return fmt.Errorf("listen address must not be empty")
+	}
+	if c.Store != "gauss" && c.Store != "memory" {
+		return fmt.Errorf("store must be gauss or memory, got %q", c.Store)
+	}
+	if len(c.Shards) == 0 {
+		return fmt.Errorf("at least one shard is required")
+	}
+	if c.Health.Interval <= 0 || c.Health.Timeout <= 0 || c.Health.FailureThreshold <= 0 {
+		return fmt.Errorf("health interval, timeout and failure_threshold must be positive")
+	}
+	if c.Retry.MaxAttempts < 1 {
+		return fmt.Errorf("retry max_attempts must be at least 1")
+	}
+	if c.Retry.BaseDelay <= 0 || c.Retry.MaxDelay < c.Retry.BaseDelay {
+		return fmt.Errorf("retry base_delay must be positive and not greater than max_delay")
+	}
+	if c.Timeouts.Request < 0 {
+		return fmt.Errorf("request timeout must not be negative")
+	}
+	for path, d := range c.Timeouts.Endpoints {
+		if !strings.HasPrefix(path, "/") {
+			return fmt.Errorf("timeouts.endpoints: path %q must start with /", path)
+		}
+		if d < 0 {
+			return fmt.Errorf("timeouts.endpoints: %s must not be negative", path)
+		}
+	}
+
+	seen := make(map[string]bool)
+	for i, s := range c.Shards {
+		if s.Name == "" {
+			return fmt.Errorf("shard #%d: name must not be empty", i+1)
+		}
+		if seen[s.Name] {
+			return fmt.Errorf("duplicate shard name: %s", s.Name)
+		}
+		seen[s.Name] = true
+		if c.Store == "gauss" && s.DSN == "" {
+			return fmt.Errorf("shard %s: dsn must not be empty", s.Name)
+		}
+		for j, r := range s.Replicas {
+			if r == "" {
+				return fmt.Errorf("shard %s: replica #%d dsn must not be empty", s.Name, j+1)
+			}
+		}
+		if err := c.PoolFor(s).validate(); err != nil {
+			return fmt.Errorf("shard %s: %v", s.Name, err)
+		}
+	}
+	return nil
+}
+
+// PoolFor 返回分片实际使用的连接池配置：分片上的设置覆盖全局设置
+func (c *Config) PoolFor(s Shard) Pool {
+	p := c.Pool
+	if s.Pool != nil {
+		if s.Pool.MaxOpenConns != 0 {
+			p.MaxOpenConns = s.Pool.MaxOpenConns
+		}
+		if s.Pool.MaxIdleConns != 0 {
+			p.MaxIdleConns = s.Pool.MaxIdleConns
+		}
+		if s.Pool.ConnMaxLifetime != 0 {
+			p.ConnMaxLifetime = s.Pool.ConnMaxLifetime
+		}
+	}
+	return p
+}
+
+// ShardNames 按配置顺序返回分片名
+func (c *Config) ShardNames() []string {
+	names := make([]string, len(c.Shards))
+	for i, s := range c.Shards {
+		names[i] = s.Name
+	}
+	return names
+}
+
+func (p Pool) validate() error {
+	if p.MaxOpenConns < 0 || p.MaxIdleConns < 0 || p.ConnMaxLifetime < 0 {
+		return fmt.Errorf("pool settings must not be negative")
+	}
+	if p.MaxOpenConns > 0 && p.MaxIdleConns > p.MaxOpenConns {
+		return fmt.Errorf("max_idle_conns (%d) exceeds max_open_conns (%d)", p.MaxIdleConns, p.MaxOpenConns)
+	}
+	return nil
+}
+
+// getenv 读取环境变量 name；未设置时读取 name_FILE 指向的文件
+func getenv(name string) (string, error) {
+	if v := os.Getenv(name); v != "" {
+		return v, nil
+	}
+	if path := os.Getenv(name + "_FILE"); path != "" {
+		return readSecret(path)
+	}
+	return "", nil
+}
+
+// readSecret 读取密钥文件，去掉首尾空白（文件末尾通常有换行）
+func readSecret(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("read secret %s failed: %v", path, err)
+	}
+	return strings.TrimSpace(string(b)), nil
+}
+
+// withPassword 在 DSN 中没有密码时加上密码，支持 key=value 和 URL 两种写法
+func withPassword(dsn string, password string) (string, error) {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			return "", fmt.Errorf("invalid dsn: %v", err)
+		}
+		if _, ok := u.User.Password(); ok {
+			return dsn, nil
+		}
+		u.User = url.UserPassword(u.User.Username(), password)
+		return u.String(), nil
+	}
+
+	for _, field := range strings.Fields(dsn) {
+		if strings.HasPrefix(field, "password=") {
+			return dsn, nil
+		}
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(password)
+	return dsn + " password='" + escaped + "'", nil
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile 在临时目录中写入 name，返回完整路径
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// clearEnv 清空测试中用到的环境变量，避免受运行环境影响
+func clearEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{
+		"GAUSS_CONFIG", "GAUSS_LISTEN", "GAUSS_STORE", "GAUSS_PASSWORD", "GAUSS_SHARD_DSNS",
+		"GAUSS_MAX_OPEN_CONNS", "GAUSS_MAX_IDLE_CONNS", "GAUSS_RETRY_MAX_ATTEMPTS", "GAUSS_REQUEST_TIMEOUT",
+	} {
+		t.Setenv(name, "")
+		t.Setenv(name+"_FILE", "")
+	}
+}
+
+func TestLoadFile(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", `
+listen: ":9090"
+store: memory
+shards:
+  - name: a
+  - name: b
+timeouts:
+  request: 3s
+datasets:
+  - name: note
+    columns:
+      - {name: id, type: VARCHAR(64)}
+    primary_key: [id]
+`)
+	cfg, err := LoadFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9090" || cfg.Store != "memory" {
+		t.Errorf("listen, store = %q, %q, want :9090, memory", cfg.Listen, cfg.Store)
+	}
+	if got := strings.Join(cfg.ShardNames(), ","); got != "a,b" {
+		t.Errorf("shards = %s, want a,b", got)
+	}
+	if cfg.Timeouts.Request.Std() != 3*time.Second {
+		t.Errorf("request timeout = %v, want 3s", cfg.Timeouts.Request.Std())
+	}
+	// 文件中没有写的字段保留默认值
+	if cfg.Timeouts.Idle.Std() != 60*time.Second || cfg.Retry.MaxAttempts != 3 {
+		t.Errorf("defaults not kept: idle = %v, retry = %d", cfg.Timeouts.Idle.Std(), cfg.Retry.MaxAttempts)
+	}
+	if len(cfg.Datasets) != 1 || cfg.Datasets[0].Columns[0].Type != "VARCHAR(64)" {
+		t.Errorf("datasets = %+v", cfg.Datasets)
+	}
+}
+
+func TestLoadFileErrors(t *testing.T) {
+	clearEnv(t)
+	missing := filepath.Join(t.TempDir(), "missing.yaml")
+
+	// 未通过 GAUSS_CONFIG 指定时，文件不存在只使用默认值
+	cfg, err := LoadFile(missing, false)
+	if err != nil {
+		t.Fatalf("optional missing file: %v", err)
+	}
+	if cfg.Listen != ":8080" || len(cfg.Shards) != 2 {
+		t.Errorf("optional missing file: got %q with %d shard(s), want defaults", cfg.Listen, len(cfg.Shards))
+	}
+
+	cases := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"required missing file", missing, "read config"},
+		{"unknown field", writeFile(t, "c.yaml", "listen: \":8080\"\nlisen: \":9090\"\n"), "lisen"},
+		{"invalid duration", writeFile(t, "c.yaml", "timeouts:\n  request: soon\n"), "invalid duration"},
+		{"invalid store", writeFile(t, "c.yaml", "store: mysql\n"), "store must be gauss or memory"},
+		{"duplicate shard", writeFile(t, "c.yaml", "shards:\n  - {name: a, dsn: x}\n  - {name: a, dsn: y}\n"), "duplicate shard name"},
+		{"empty dsn", writeFile(t, "c.yaml", "shards:\n  - {name: a}\n"), "dsn must not be empty"},
+		{"idle exceeds open", writeFile(t, "c.yaml", "pool:\n  max_open_conns: 2\n  max_idle_conns: 5\n"), "exceeds max_open_conns"},
+		{"endpoint path", writeFile(t, "c.yaml", "timeouts:\n  endpoints:\n    api/x: 1s\n"), "must start with /"},
+		{"missing secret", writeFile(t, "c.yaml", "password_file: /nonexistent/secret\n"), "read secret"},
+	}
+	for _, c := range cases {
+		_, err := LoadFile(c.path, true)
+		if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+			t.Errorf("%s: err = %v, want it to contain %q", c.name, err, c.wantErr)
+		}
+	}
+}
+
+func TestEnvOverrides(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "config.yaml", "listen: \":9090\"\npool:\n  max_open_conns: 10\n")
+	t.Setenv("GAUSS_LISTEN", ":7070")
+	t.Setenv("GAUSS_MAX_OPEN_CONNS", "30")
+	t.Setenv("GAUSS_RETRY_MAX_ATTEMPTS", "5")
+	t.Setenv("GAUSS_REQUEST_TIMEOUT", "250ms")
+	t.Setenv("GAUSS_SHARD_DSNS", " host=db1 dbname=postgres ; ;host=db2 dbname=postgres ")
+	t.Setenv("GAUSS_PASSWORD_FILE", writeFile(t, "password", "s3cr'et\n"))
+
+	cfg, err := LoadFile(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 环境变量覆盖配置文件
+	if cfg.Listen != ":7070" || cfg.Pool.MaxOpenConns != 30 || cfg.Retry.MaxAttempts != 5 {
+		t.Errorf("listen, max_open_conns, max_attempts = %q, %d, %d, want :7070, 30, 5",
+			cfg.Listen, cfg.Pool.MaxOpenConns, cfg.Retry.MaxAttempts)
+	}
+	if cfg.Timeouts.Request.Std() != 250*time.Millisecond {
+		t.Errorf("request timeout = %v, want 250ms", cfg.Timeouts.Request.Std())
+	}
+	// GAUSS_SHARD_DSNS 替换分片列表，跳过空项，密码从 GAUSS_PASSWORD_FILE 读取并写入每个 DSN
+	want := []Shard{
+		{Name: "og1", DSN: `host=db1 dbname=postgres password='s3cr\'et'`},
+		{Name: "og2", DSN: `host=db2 dbname=postgres password='s3cr\'et'`},
+	}
+	if len(cfg.Shards) != len(want) {
+		t.Fatalf("shards = %+v, want %+v", cfg.Shards, want)
+	}
+	for i, s := range cfg.Shards {
+		if s.Name != want[i].Name || s.DSN != want[i].DSN {
+			t.Errorf("shard #%d = %s %q, want %s %q", i+1, s.Name, s.DSN, want[i].Name, want[i].DSN)
+		}
+	}
+}
+
+func TestEnvOverrideErrors(t *testing.T) {
+	cases := []struct {
+		name, value, wantErr string
+	}{
+		{"GAUSS_MAX_IDLE_CONNS", "many", "invalid integer"},
+		{"GAUSS_REQUEST_TIMEOUT", "10", "invalid duration"},
+		{"GAUSS_RETRY_MAX_ATTEMPTS", "0", "max_attempts must be at least 1"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			clearEnv(t)
+			t.Setenv(c.name, c.value)
+			_, err := LoadFile(filepath.Join(t.TempDir(), "missing.yaml"), false)
+			if err == nil || !strings.Contains(err.Error(), c.wantErr) {
+				t.Errorf("%s=%s: err = %v, want it to contain %q", c.name, c.value, err, c.wantErr)
+			}
+		})
+	}
+}
+
+func TestLoadUsesGaussConfig(t *testing.T) {
+	clearEnv(t)
+	path := writeFile(t, "other.yaml", "listen: \":9191\"\n")
+	t.Setenv("GAUSS_CONFIG", path)
+
+	if p, explicit := Path(); p != path || !explicit {
+		t.Errorf("Path() = %s, %v, want %s, true", p, explicit, path)
+	}
+	cfg, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Listen != ":9191" {
+		t.Errorf("listen = %q, want :9191", cfg.Listen)
+	}
+
+	// GAUSS_CONFIG 指定的文件必须存在
+	t.Setenv("GAUSS_CONFIG", filepath.Join(t.TempDir(), "missing.yaml"))
+	if _, err := Load(); err == nil {
+		t.Error("Load with missing GAUSS_CONFIG file succeeded, want error")
+	}
+}
+
+func TestWithPassword(t *testing.T) {
+	cases := []struct {
+		dsn, want string
+	}{
+		{"host=db", "host=db password='pw'"},
+		{"host=db password=old", "host=db password=old"},
+		{"postgres://u@db/postgres", "postgres://u:pw@db/postgres"},
+		{"postgres://u:old@db/postgres", "postgres://u:old@db/postgres"},
+	}
+	for _, c := range cases {
+		got, err := withPassword(c.dsn, "pw")
+		if err != nil || got != c.want {
+			t.Errorf("withPassword(%q) = %q, %v, want %q", c.dsn, got, err, c.want)
+		}
+	}
+}
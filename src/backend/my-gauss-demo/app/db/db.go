@@ -2,32 +2,82 @@
 package db
 
 import (
-	"database/sql"
+	"context"
+	"fmt"
 	"log"
+	"sync"
 
 	_ "github.com/lib/pq"
-)
 
-var (
-	DBOg1 *sql.DB
-	DBOg2 *sql.DB
+	"my-gauss-app/config"
+	"my-gauss-app/store"
 )
 
-func InitDB() {
-	var err error
-	DBOg1, err = sql.Open("postgres", "host=localhost port=5432 user=gaussdb password=Sakura030523! dbname=postgres sslmode=disable")
-	if err != nil {
-		log.Fatalf("Failed to connect to og1: %v", err)
+// Store openGauss 存储后端，实现 store.Store
+type Store struct {
+	*store.ShardSet
+	shards []*Shard
+	// tables 由 model 声明的表，迁移之后按 Types 创建尚不存在的表
+	tables []store.TableDef
+
+	// stop 关闭时停止后台健康检查
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// Open 按配置连接所有分片并构建哈希环，启动后台健康检查。
+// Ping 不通的分片不会导致失败，而是以熔断状态启动，恢复后由健康检查重新启用。
+// tables 为 model 声明的表，MigrateAvailable 和分片恢复时据此建表
+func Open(cfg *config.Config, tables []store.TableDef) (*Store, error) {
+	setRetryPolicy(cfg.Retry)
+	var shards []*Shard
+	for i, sc := range cfg.Shards {
+		s, err := openShard(i, sc, cfg.PoolFor(sc), cfg.Health.FailureThreshold)
+		if err != nil {
+			closeShards(shards)
+			return nil, err
+		}
+		shards = append(shards, s)
+
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.Health.Timeout.Std())
+		err = s.ping(ctx)
+		cancel()
+		if err != nil {
+			log.Printf("Shard %s is unreachable, starting with it marked unavailable: %v", sc.Name, err)
+			s.health.trip(err)
+		}
 	}
-	DBOg2, err = sql.Open("postgres", "host=localhost port=5433 user=gaussdb password=Sakura030523! dbname=postgres sslmode=disable")
+
+	st, err := newStore(shards, tables)
 	if err != nil {
-		log.Fatalf("Failed to connect to og2: %v", err)
+		closeShards(shards)
+		return nil, err
 	}
+	st.startHealthCheck(cfg.Health)
+	return st, nil
+}
 
-	if err = DBOg1.Ping(); err != nil {
-		log.Fatalf("Ping og1 failed: %v", err)
+func newStore(shards []*Shard, tables []store.TableDef) (*Store, error) {
+	members := make([]store.Shard, len(shards))
+	for i, s := range shards {
+		members[i] = s
+	}
+	set, err := store.NewShardSet(members, 0)
+	if err != nil {
+		return nil, fmt.Errorf("build shard ring failed: %v", err)
 	}
-	if err = DBOg2.Ping(); err != nil {
-		log.Fatalf("Ping og2 failed: %v", err)
+	return &Store{ShardSet: set, shards: shards, tables: tables, stop: make(chan struct{})}, nil
+}
+
+// Close 停止健康检查并关闭所有分片连接
+func (s *Store) Close() error {
+	s.stopHealthCheck()
+	closeShards(s.shards)
+	return nil
+}
+
+func closeShards(shards []*Shard) {
+	for _, sh := range shards {
+		sh.close()
 	}
 }
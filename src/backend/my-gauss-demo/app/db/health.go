@@ -0,0 +1,209 @@
+// db/health.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+
+	"my-gauss-app/config"
+	"my-gauss-app/store"
+)
+
+// breaker 分片熔断器：连续 threshold 次连接失败后打开，打开期间该分片上的请求立即失败；
+// 只有后台健康检查成功后才会关闭
+type breaker struct {
+	mu        sync.Mutex
+	threshold int
+	failures  int
+	open      bool
+	lastErr   error
+	since     time.Time
+}
+
+func newBreaker(threshold int) *breaker {
+	return &breaker{threshold: threshold, since: time.Now()}
+}
+
+// allow 熔断打开时返回最近一次失败的原因
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return b.lastErr
+	}
+	return nil
+}
+
+func (b *breaker) success() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		b.failures = 0
+	}
+}
+
+// failure 记录一次连接失败，返回本次是否触发熔断
+func (b *breaker) failure(err error) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.failures++
+	b.lastErr = err
+	if b.open || b.failures < b.threshold {
+		return false
+	}
+	b.open = true
+	b.since = time.Now()
+	return true
+}
+
+// trip 立即熔断，用于启动时就连不上的分片
+func (b *breaker) trip(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open = true
+	b.lastErr = err
+	b.since = time.Now()
+}
+
+func (b *breaker) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.open = false
+	b.failures = 0
+	b.lastErr = nil
+	b.since = time.Now()
+}
+
+func (b *breaker) isOpen() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.open
+}
+
+func (b *breaker) setThreshold(n int) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.threshold = n
+}
+
+func (b *breaker) status() (healthy bool, lastErr error, since time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return !b.open, b.lastErr, b.since
+}
+
+// isConnError 判断错误是否说明分片本身不可用（连接失败、连接中断、实例正在关闭等），
+// 这类错误计入熔断；SQL 错误、约束冲突等不计入
+func isConnError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		// 08xxx 连接异常；57P01/57P02/57P03 管理员关闭、崩溃关闭、暂时无法连接
+		code := string(pqErr.Code)
+		return strings.HasPrefix(code, "08") || code == "57P01" || code == "57P02" || code == "57P03"
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
+
+// allow 分片被熔断时返回 store.ErrShardUnavailable
+func (s *Shard) allow() error {
+	if err := s.health.allow(); err != nil {
+		return fmt.Errorf("%w: %s: %v", store.ErrShardUnavailable, s.name, err)
+	}
+	return nil
+}
+
+// observe 根据请求结果更新熔断器；连接类错误包装为 store.ErrShardUnavailable。
+// ctx 已取消或超时导致的失败（客户端断开、请求超过时限）与分片健康无关，不计入熔断
+func (s *Shard) observe(ctx context.Context, err error) error {
+	if err == nil {
+		s.health.success()
+		return nil
+	}
+	if ctx.Err() != nil || !isConnError(err) {
+		return err
+	}
+	if s.health.failure(err) {
+		log.Printf("Shard %s marked unavailable after repeated connection failures: %v", s.name, err)
+	}
+	return fmt.Errorf("%w: %s: %w", store.ErrShardUnavailable, s.name, err)
+}
+
+// Health 返回每个分片的健康状态，实现 store.HealthReporter
+func (s *Store) Health() []store.ShardHealth {
+	var result []store.ShardHealth
+	for _, sh := range s.shards {
+		healthy, lastErr, since := sh.health.status()
+		h := store.ShardHealth{Shard: sh.name, Healthy: healthy, Since: since}
+		if !healthy && lastErr != nil {
+			h.Error = lastErr.Error()
+		}
+		result = append(result, h)
+	}
+	return result
+}
+
+// startHealthCheck 启动后台健康检查，Close/Retire 时停止
+func (s *Store) startHealthCheck(h config.Health) {
+	go func() {
+		ticker := time.NewTicker(h.Interval.Std())
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.stop:
+				return
+			case <-ticker.C:
+				s.checkShards(h.Timeout.Std())
+			}
+		}
+	}()
+}
+
+func (s *Store) stopHealthCheck() {
+	s.stopOnce.Do(func() { close(s.stop) })
+}
+
+// checkShards Ping 每个分片。熔断中的分片恢复后先迁移到最新表结构、关闭熔断，
+// 再处理它上面遗留的 prepared transaction
+func (s *Store) checkShards(timeout time.Duration) {
+	for _, sh := range s.shards {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		err := sh.DB.PingContext(ctx)
+		cancel()
+
+		if err != nil {
+			if sh.health.failure(err) {
+				log.Printf("Shard %s marked unavailable: health check failed: %v", sh.name, err)
+			}
+			continue
+		}
+		if !sh.health.isOpen() {
+			sh.health.success()
+			continue
+		}
+
+		if err := s.prepareShard(context.Background(), sh); err != nil {
+			log.Printf("Shard %s is reachable but migration failed, keeping it unavailable: %v", sh.name, err)
+			continue
+		}
+		sh.health.reset()
+		log.Printf("Shard %s is available again", sh.name)
+		// 只补完这个分片上已决定提交的老事务；未决的由服务进程定期的 RecoverPrepared 处理
+		store.CommitDecided(context.Background(), s, sh)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+
+	"github.com/lib/pq"
+
+	"my-gauss-app/store"
+)
+
+func TestBreakerOpensAfterThreshold(t *testing.T) {
+	b := newBreaker(3)
+	conn := errors.New("connection refused")
+
+	for i := 0; i < 2; i++ {
+		if b.failure(conn) {
+			t.Fatalf("breaker opened after %d failure(s), threshold 3", i+1)
+		}
+	}
+	if err := b.allow(); err != nil {
+		t.Fatalf("breaker rejects requests before opening: %v", err)
+	}
+	if !b.failure(conn) {
+		t.Fatal("breaker did not open at the threshold")
+	}
+	if err := b.allow(); err != conn {
+		t.Errorf("allow() = %v, want the last failure", err)
+	}
+	// 打开后再失败不会重复报告
+	if b.failure(conn) {
+		t.Error("breaker reported opening twice")
+	}
+
+	// 请求成功不会关闭熔断，只有健康检查 reset 才会
+	b.success()
+	if !b.isOpen() {
+		t.Error("a successful request closed the breaker")
+	}
+	b.reset()
+	if healthy, lastErr, _ := b.status(); !healthy || lastErr != nil {
+		t.Errorf("status after reset = %v, %v, want healthy", healthy, lastErr)
+	}
+}
+
+func TestBreakerSuccessResetsFailures(t *testing.T) {
+	b := newBreaker(2)
+	conn := errors.New("connection reset")
+	b.failure(conn)
+	b.success()
+	if b.failure(conn) {
+		t.Error("failures before a success counted towards the threshold")
+	}
+}
+
+func TestIsConnError(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{io.EOF, true},
+		{fmt.Errorf("read: %w", io.ErrUnexpectedEOF), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{&pq.Error{Code: "08006"}, true},
+		{&pq.Error{Code: "57P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{&pq.Error{Code: "42P01"}, false},
+		{errors.New("syntax error"), false},
+	}
+	for _, tt := range tests {
+		if got := isConnError(tt.err); got != tt.want {
+			t.Errorf("isConnError(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestShardObserveTripsBreaker(t *testing.T) {
+	s := &Shard{name: "og2", health: newBreaker(1)}
+	ctx := context.Background()
+
+	// SQL 错误不计入熔断
+	if err := s.observe(ctx, &pq.Error{Code: "23505"}); errors.Is(err, store.ErrShardUnavailable) {
+		t.Errorf("constraint violation reported as unavailable: %v", err)
+	}
+	if err := s.allow(); err != nil {
+		t.Fatalf("breaker opened on a SQL error: %v", err)
+	}
+
+	// 客户端取消导致的失败与分片健康无关
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	s.observe(canceled, io.EOF)
+	if err := s.allow(); err != nil {
+		t.Fatalf("breaker opened on a canceled request: %v", err)
+	}
+
+	if err := s.observe(ctx, io.EOF); !errors.Is(err, store.ErrShardUnavailable) {
+		t.Errorf("observe(EOF) = %v, want ErrShardUnavailable", err)
+	}
+	if err := s.allow(); !errors.Is(err, store.ErrShardUnavailable) {
+		t.Errorf("allow() after opening = %v, want ErrShardUnavailable", err)
+	}
+}
@@ -0,0 +1,300 @@
+// db/migrate.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 每个分片上的 schema_migrations 表记录该分片已执行的迁移版本
+const migrationsTableSQL = `
+    CREATE TABLE IF NOT EXISTS schema_migrations (
+        version INT PRIMARY KEY,
+        name VARCHAR(255) NOT NULL,
+        applied_at TIMESTAMP NOT NULL
+    );`
+
+// Migration 一个版本的表结构变更
+type Migration struct {
+	Version int
+	Name    string
+	// UserShardOnly 为 true 时 SQL 只在 user 所在分片上执行，其余分片只记录版本，
+	// 这样所有分片的版本号保持一致
+	UserShardOnly bool
+	Up            string
+	Down          string
+}
+
+// AppliedMigration schema_migrations 中的一条记录
+type AppliedMigration struct {
+	Version   int
+	Name      string
+	AppliedAt time.Time
+}
+
+// ShardStatus 一个分片的迁移状态
+type ShardStatus struct {
+	Shard string
+	// Version 已执行的最大版本，0 表示尚未执行任何迁移
+	Version int
+	Applied []AppliedMigration
+	// Pending 代码中有、该分片尚未执行的版本
+	Pending []int
+	// Unknown 该分片上已执行、但代码中不存在或名称不一致的版本
+	Unknown []int
+}
+
+// LatestVersion 代码中最新的迁移版本
+func LatestVersion() int {
+	if len(Migrations) == 0 {
+		return 0
+	}
+	return Migrations[len(Migrations)-1].Version
+}
+
+func findMigration(version int) (Migration, bool) {
+	for _, m := range Migrations {
+		if m.Version == version {
+			return m, true
+		}
+	}
+	return Migration{}, false
+}
+
+// Migrate 把每个分片迁移到 target 版本：低于 target 的按版本递增执行 Up，
+// 高于 target 的按版本递减执行 Down。每个迁移在所属分片的一个事务内执行并记录版本，
+// 失败时该迁移整体回滚，已完成的迁移保留，修复后重新执行即可继续。
+// 迁移到最新版本时同时创建 Open 时声明的表（与启动时的 MigrateAvailable 一致）
+func (s *Store) Migrate(ctx context.Context, target int) error {
+	if target < 0 || target > LatestVersion() {
+		return fmt.Errorf("target version must be between 0 and %d", LatestVersion())
+	}
+
+	for _, sh := range s.shards {
+		if target == LatestVersion() {
+			if err := s.prepareShard(ctx, sh); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.migrateShard(ctx, sh, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateAvailable 把未熔断的分片迁移到最新版本，并创建 Open 时声明的表。
+// 启动时连不上的分片先跳过，由健康检查在它恢复后迁移，之后才接收请求
+func (s *Store) MigrateAvailable(ctx context.Context) error {
+	for _, sh := range s.shards {
+		if sh.health.isOpen() {
+			log.Printf("Shard %s is unavailable, migration deferred until it recovers", sh.name)
+			continue
+		}
+		if err := s.prepareShard(ctx, sh); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrationStep 迁移计划中的一步：执行 Migration 的 Up（Up 为 true）或 Down
+type migrationStep struct {
+	Migration
+	Up bool
+}
+
+// planMigration 根据分片上已执行的迁移（按版本递增）计算迁移到 target 版本的步骤：
+// 先按版本递增执行缺少的 Up，再按版本递减执行高于 target 的 Down
+func planMigration(applied []AppliedMigration, target int) ([]migrationStep, error) {
+	done := make(map[int]bool)
+	for _, a := range applied {
+		done[a.Version] = true
+	}
+
+	var steps []migrationStep
+	for _, m := range Migrations {
+		if m.Version <= target && !done[m.Version] {
+			steps = append(steps, migrationStep{m, true})
+		}
+	}
+	for i := len(applied) - 1; i >= 0; i-- {
+		a := applied[i]
+		if a.Version <= target {
+			continue
+		}
+		m, ok := findMigration(a.Version)
+		if !ok {
+			return nil, fmt.Errorf("cannot roll back version %d (%s): migration not found in code", a.Version, a.Name)
+		}
+		steps = append(steps, migrationStep{m, false})
+	}
+	return steps, nil
+}
+
+// migrateShard 把一个分片迁移到 target 版本
+func (s *Store) migrateShard(ctx context.Context, sh *Shard, target int) error {
+	applied, err := s.appliedMigrations(ctx, sh)
+	if err != nil {
+		return err
+	}
+	steps, err := planMigration(applied, target)
+	if err != nil {
+		return fmt.Errorf("%s: %w", sh.name, err)
+	}
+	for _, step := range steps {
+		if err := s.applyMigration(ctx, sh, step.Migration, step.Up); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyMigration 在一个事务内执行迁移并更新 schema_migrations。
+// 事务内先锁住 schema_migrations 再检查版本，多个进程同时迁移时不会重复执行
+func (s *Store) applyMigration(ctx context.Context, sh *Shard, m Migration, up bool) error {
+	direction := "up"
+	stmt := m.Up
+	if !up {
+		direction = "down"
+		stmt = m.Down
+	}
+
+	tx, err := sh.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("begin on %s failed: %v", sh.name, err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE schema_migrations IN EXCLUSIVE MODE"); err != nil {
+		return fmt.Errorf("lock schema_migrations on %s failed: %v", sh.name, err)
+	}
+	var n int
+	if err := tx.QueryRowContext(ctx, "SELECT COUNT(*) FROM schema_migrations WHERE version = $1", m.Version).Scan(&n); err != nil {
+		return fmt.Errorf("read schema_migrations on %s failed: %v", sh.name, err)
+	}
+	if (n > 0) == up {
+		// 其他进程已经完成了这一步
+		return nil
+	}
+
+	if !m.UserShardOnly || sh == s.shards[0] {
+		stmt = strings.ReplaceAll(stmt, "{shard}", strconv.Itoa(sh.id))
+		if _, err := tx.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("migration %d %s (%s) on %s failed: %v", m.Version, m.Name, direction, sh.name, err)
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name, applied_at) VALUES ($1, $2, now())", m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = $1", m.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("record migration %d on %s failed: %v", m.Version, sh.name, err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit migration %d on %s failed: %v", m.Version, sh.name, err)
+	}
+	log.Printf("Migration %d %s (%s) applied on %s", m.Version, m.Name, direction, sh.name)
+	return nil
+}
+
+// appliedMigrations 返回分片上已执行的迁移（按版本递增），必要时先创建 schema_migrations
+func (s *Store) appliedMigrations(ctx context.Context, sh *Shard) ([]AppliedMigration, error) {
+	if _, err := sh.DB.ExecContext(ctx, migrationsTableSQL); err != nil {
+		return nil, fmt.Errorf("create schema_migrations on %s failed: %v", sh.name, err)
+	}
+	rows, err := sh.DB.QueryContext(ctx, "SELECT version, name, applied_at FROM schema_migrations ORDER BY version")
+	if err != nil {
+		return nil, fmt.Errorf("read schema_migrations on %s failed: %v", sh.name, err)
+	}
+	defer rows.Close()
+
+	var applied []AppliedMigration
+	for rows.Next() {
+		var a AppliedMigration
+		var at sql.NullTime
+		if err := rows.Scan(&a.Version, &a.Name, &at); err != nil {
+			return nil, fmt.Errorf("scan schema_migrations on %s failed: %v", sh.name, err)
+		}
+		a.AppliedAt = at.Time
+		applied = append(applied, a)
+	}
+	return applied, rows.Err()
+}
+
+// MigrationStatus 返回每个分片的迁移状态
+func (s *Store) MigrationStatus(ctx context.Context) ([]ShardStatus, error) {
+	var statuses []ShardStatus
+	for _, sh := range s.shards {
+		applied, err := s.appliedMigrations(ctx, sh)
+		if err != nil {
+			return nil, err
+		}
+
+		statuses = append(statuses, shardStatus(sh.name, applied))
+	}
+	return statuses, nil
+}
+
+// shardStatus 比较分片上已执行的迁移与代码中的 Migrations
+func shardStatus(shard string, applied []AppliedMigration) ShardStatus {
+	st := ShardStatus{Shard: shard, Applied: applied}
+	done := make(map[int]bool)
+	for _, a := range applied {
+		done[a.Version] = true
+		if a.Version > st.Version {
+			st.Version = a.Version
+		}
+		if m, ok := findMigration(a.Version); !ok || m.Name != a.Name {
+			st.Unknown = append(st.Unknown, a.Version)
+		}
+	}
+	for _, m := range Migrations {
+		if !done[m.Version] {
+			st.Pending = append(st.Pending, m.Version)
+		}
+	}
+	return st
+}
+
+// Drift 找出各分片之间、以及分片与代码之间不一致的地方，返回可读的描述；为空表示没有漂移
+func Drift(statuses []ShardStatus) []string {
+	var issues []string
+	versions := make(map[int][]string)
+	for _, st := range statuses {
+		versions[st.Version] = append(versions[st.Version], st.Shard)
+		if len(st.Unknown) > 0 {
+			issues = append(issues, fmt.Sprintf("%s has versions not matching code: %v", st.Shard, st.Unknown))
+		}
+		// 版本号不连续：中间的迁移被跳过或被手工删除了记录
+		for _, v := range st.Pending {
+			if v < st.Version {
+				issues = append(issues, fmt.Sprintf("%s is at version %d but version %d is not applied", st.Shard, st.Version, v))
+			}
+		}
+	}
+
+	if len(versions) > 1 {
+		var keys []int
+		for v := range versions {
+			keys = append(keys, v)
+		}
+		sort.Ints(keys)
+		parts := make([]string, len(keys))
+		for i, v := range keys {
+			parts[i] = fmt.Sprintf("version %d: %s", v, strings.Join(versions[v], ", "))
+		}
+		issues = append(issues, "shards are at different versions ("+strings.Join(parts, "; ")+")")
+	}
+	return issues
+}
@@ -0,0 +1,104 @@
+package db
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// applied 返回 versions 中各版本的已执行记录，名称与代码一致
+func applied(versions ...int) []AppliedMigration {
+	var as []AppliedMigration
+	for _, v := range versions {
+		m, _ := findMigration(v)
+		as = append(as, AppliedMigration{Version: v, Name: m.Name})
+	}
+	return as
+}
+
+func TestPlanMigration(t *testing.T) {
+	if LatestVersion() < 3 {
+		t.Skip("needs at least three migrations")
+	}
+	all := make([]int, LatestVersion())
+	var down []int
+	for i := range all {
+		all[i] = i + 1
+		if v := LatestVersion() - i; v > 1 {
+			down = append(down, -v)
+		}
+	}
+
+	cases := []struct {
+		name    string
+		applied []AppliedMigration
+		target  int
+		want    []int // 正数为 Up，负数为 Down
+	}{
+		{"up from empty", nil, 2, []int{1, 2}},
+		{"up to latest", applied(1), LatestVersion(), all[1:]},
+		{"fills a gap", applied(1, 3), 3, []int{2}},
+		{"down", applied(all...), 1, down},
+		{"nothing to do", applied(1, 2), 2, nil},
+	}
+
+	for _, c := range cases {
+		plan, err := planMigration(c.applied, c.target)
+		if err != nil {
+			t.Fatalf("%s: %v", c.name, err)
+		}
+		var got []int
+		for _, step := range plan {
+			if step.Up {
+				got = append(got, step.Version)
+			} else {
+				got = append(got, -step.Version)
+			}
+		}
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: plan = %v, want %v", c.name, got, c.want)
+		}
+	}
+
+	// 代码中没有的版本无法回滚
+	unknown := append(applied(1), AppliedMigration{Version: 999, Name: "from_the_future"})
+	if _, err := planMigration(unknown, 1); err == nil {
+		t.Error("planMigration rolled back a version missing from code")
+	}
+}
+
+func TestShardStatusAndDrift(t *testing.T) {
+	latest := make([]int, LatestVersion())
+	for i := range latest {
+		latest[i] = i + 1
+	}
+
+	clean := []ShardStatus{shardStatus("og1", applied(latest...)), shardStatus("og2", applied(latest...))}
+	if drift := Drift(clean); len(drift) != 0 {
+		t.Errorf("Drift of shards at the latest version = %v, want none", drift)
+	}
+
+	renamed := applied(latest...)
+	renamed[0].Name = "renamed"
+	st := shardStatus("og2", renamed)
+	if !reflect.DeepEqual(st.Unknown, []int{1}) {
+		t.Errorf("Unknown = %v, want [1]", st.Unknown)
+	}
+
+	behind := shardStatus("og2", applied(1))
+	if behind.Version != 1 || len(behind.Pending) != LatestVersion()-1 {
+		t.Errorf("status = %+v, want version 1 with the rest pending", behind)
+	}
+	gap := shardStatus("og3", applied(append([]int{1}, latest[2:]...)...))
+
+	drift := Drift([]ShardStatus{clean[0], behind, st, gap})
+	for _, want := range []string{"different versions", "not matching code", "version 2 is not applied"} {
+		found := false
+		for _, d := range drift {
+			found = found || strings.Contains(d, want)
+		}
+		if !found {
+			t.Errorf("Drift = %v, want an issue containing %q", drift, want)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// db/migrations.go
+package db
+
+// Migrations 按版本号递增排列的全部迁移。已发布的迁移不要修改，新的表结构变更追加新版本。
+// SQL 中的 {shard} 在执行时替换为分片 ID（og1 -> 0，og2 -> 1 ……），
+// 用于 document_{shard} 等分片表
+var Migrations = []Migration{
+	{
+		Version: 1,
+		Name:    "create_user_and_metadata_tables",
+		// 用户表不分片，统一放在第一个分片上；元数据表与它放在同一实例
+		UserShardOnly: true,
+		Up: `
+    CREATE TABLE IF NOT EXISTS "user" (
+        id VARCHAR(64) PRIMARY KEY,
+        user_name VARCHAR(64),
+        email VARCHAR(100),
+        password VARCHAR(256)
+    );
+
+    -- 重平衡迁移日志：记录每个房间的迁移状态
+    CREATE TABLE IF NOT EXISTS rebalance_journal (
+        room_id VARCHAR(64) PRIMARY KEY,
+        from_shard VARCHAR(64),
+        to_shard VARCHAR(64),
+        state VARCHAR(16),
+        updated_at TIMESTAMP
+    );
+
+    -- 两阶段提交决定日志：启动时据此处理遗留的 prepared transaction
+    CREATE TABLE IF NOT EXISTS twopc_log (
+        txn_id VARCHAR(128) PRIMARY KEY,
+        decision VARCHAR(16) NOT NULL,
+        participants TEXT,
+        created_at TIMESTAMP
+    );
+
+    -- 房间目录：room_id -> 分片名，目录中没有的房间按 hash 定位
+    CREATE TABLE IF NOT EXISTS room_directory (
+        room_id VARCHAR(64) PRIMARY KEY,
+        shard_name VARCHAR(64) NOT NULL,
+        pinned BOOLEAN NOT NULL DEFAULT false,
+        updated_at TIMESTAMP
+    );`,
+		Down: `
+    DROP TABLE IF EXISTS room_directory;
+    DROP TABLE IF EXISTS twopc_log;
+    DROP TABLE IF EXISTS rebalance_journal;
+    DROP TABLE IF EXISTS "user";`,
+	},
+	{
+		Version: 2,
+		Name:    "create_room_tables",
+		// room(document)、permission、content 表以 room_id 在一致性哈希环上分片
+		Up: `
+    CREATE TABLE IF NOT EXISTS document_{shard} (
+        room_id VARCHAR(64) PRIMARY KEY,
+        room_name VARCHAR(128),
+        create_time TIMESTAMP,
+        overall_permission INT,
+        owner_user_id VARCHAR(64)
+    );
+
+    CREATE TABLE IF NOT EXISTS permission_{shard} (
+        room_id VARCHAR(64),
+        user_id VARCHAR(64),
+        permission INT,
+        PRIMARY KEY(room_id, user_id)
+    );
+
+    CREATE TABLE IF NOT EXISTS content_{shard} (
+        room_id VARCHAR(64) PRIMARY KEY,
+        content TEXT
+    );`,
+		Down: `
+    DROP TABLE IF EXISTS content_{shard};
+    DROP TABLE IF EXISTS permission_{shard};
+    DROP TABLE IF EXISTS document_{shard};`,
+	},
+	{
+		Version: 3,
+		Name:    "create_user_rooms_index",
+		// user_id -> 可访问的房间及权限，与 permission_N 同步维护；
+		// 已有数据的部署升级后执行一次 reindex 子命令回填
+		UserShardOnly: true,
+		Up: `
+    CREATE TABLE IF NOT EXISTS user_rooms (
+        user_id VARCHAR(64),
+        room_id VARCHAR(64),
+        permission INT,
+        PRIMARY KEY(user_id, room_id)
+    );`,
+		Down: `
+    DROP TABLE IF EXISTS user_rooms;`,
+	},
+	{
+		Version: 4,
+		Name:    "add_room_directory_migrating",
+		// 迁移中的房间：move-room / rebalance 复制数据期间拒绝写入
+		UserShardOnly: true,
+		Up: `
+    ALTER TABLE room_directory ADD COLUMN migrating BOOLEAN NOT NULL DEFAULT false;`,
+		Down: `
+    ALTER TABLE room_directory DROP COLUMN migrating;`,
+	},
+}
@@ -0,0 +1,87 @@
+// db/reload.go
+package db
+
+import (
+	"context"
+	"fmt"
+	"log"
+
+	"my-gauss-app/config"
+)
+
+// Reload 按新配置构建新的 Store，用于不停机更新分片和连接池配置：
+//   - DSN、副本和连接池配置都没有变化的分片沿用原连接池
+//   - 变化的分片和新增的分片建立新连接池，Ping 失败则放弃本次加载
+//   - 新 Store 迁移到最新表结构并创建声明的数据集表（新增分片需要建表），熔断中的分片留给健康检查在恢复后迁移
+//
+// 已有分片不能删除、改名或调整顺序：分片 ID 是表后缀，改变后已有数据将无法访问。
+// 新增分片会改变哈希环，需要之后执行 rebalance 迁移数据。
+// 失败时新建的连接池全部关闭，s 不受影响；成功后由调用方切换到新 Store，
+// 并在旧请求结束后调用 s.Retire(next) 关闭不再使用的连接池
+func (s *Store) Reload(ctx context.Context, cfg *config.Config) (*Store, error) {
+	if len(cfg.Shards) < len(s.shards) {
+		return nil, fmt.Errorf("shards cannot be removed (have %d, new config has %d)", len(s.shards), len(cfg.Shards))
+	}
+	for i, old := range s.shards {
+		if cfg.Shards[i].Name != old.name {
+			return nil, fmt.Errorf("shard #%d is %s, new config has %s: shards cannot be renamed or reordered", i+1, old.name, cfg.Shards[i].Name)
+		}
+	}
+
+	var shards, opened []*Shard
+	for i, sc := range cfg.Shards {
+		pool := cfg.PoolFor(sc)
+		if i < len(s.shards) && s.shards[i].sameConfig(sc, pool) {
+			s.shards[i].health.setThreshold(cfg.Health.FailureThreshold)
+			shards = append(shards, s.shards[i])
+			continue
+		}
+
+		sh, err := openShard(i, sc, pool, cfg.Health.FailureThreshold)
+		if err != nil {
+			closeShards(opened)
+			return nil, err
+		}
+		opened = append(opened, sh)
+		if err := sh.ping(ctx); err != nil {
+			closeShards(opened)
+			return nil, fmt.Errorf("%s: %v", sc.Name, err)
+		}
+		shards = append(shards, sh)
+	}
+
+	next, err := newStore(shards, s.tables)
+	if err != nil {
+		closeShards(opened)
+		return nil, err
+	}
+	if err := next.MigrateAvailable(ctx); err != nil {
+		closeShards(opened)
+		return nil, err
+	}
+
+	for _, sh := range opened {
+		if sh.id < len(s.shards) {
+			log.Printf("Reload: rebuilt connection pool for %s", sh.name)
+		} else {
+			log.Printf("Reload: added shard %s, run rebalance to move rooms onto it", sh.name)
+		}
+	}
+	setRetryPolicy(cfg.Retry)
+	next.startHealthCheck(cfg.Health)
+	return next, nil
+}
+
+// Retire 停止 s 的健康检查，关闭 s 中没有被 next 沿用的连接池
+func (s *Store) Retire(next *Store) {
+	s.stopHealthCheck()
+	kept := make(map[*Shard]bool)
+	for _, sh := range next.shards {
+		kept[sh] = true
+	}
+	for _, sh := range s.shards {
+		if !kept[sh] {
+			sh.close()
+		}
+	}
+}
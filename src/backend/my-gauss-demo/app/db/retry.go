@@ -0,0 +1,132 @@
+// db/retry.go
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"expvar"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+
+	"my-gauss-app/config"
+	"my-gauss-app/store"
+)
+
+var (
+	// retryPolicy 当前的重试配置，Open 和 Reload 时更新
+	retryPolicy atomic.Pointer[config.Retry]
+
+	// 重试计数，按分片统计，通过 /debug/vars 查看
+	retryCount     = expvar.NewMap("gauss_db_retries")
+	retryExhausted = expvar.NewMap("gauss_db_retries_exhausted")
+)
+
+func setRetryPolicy(r config.Retry) {
+	retryPolicy.Store(&r)
+}
+
+func currentRetryPolicy() config.Retry {
+	if p := retryPolicy.Load(); p != nil {
+		return *p
+	}
+	return config.Default().Retry
+}
+
+// isTransient 判断错误是否是重试可能成功的瞬时错误：
+// 序列化失败（40001）、死锁（40P01）以及连接类错误（见 isConnError）
+func isTransient(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && (pqErr.Code == "40001" || pqErr.Code == "40P01") {
+		return true
+	}
+	return isConnError(err)
+}
+
+// 传给 do 的重试条件
+func always(error) bool { return true }
+func never(error) bool  { return false }
+
+// notExecuted 判断失败的语句是否确定没有生效，只有这样的写入才重试：
+// 建立连接失败（拨号失败；database/sql 只在请求发出之前返回 driver.ErrBadConn）、
+// 服务端拒绝连接（08001、08004、57P03），或语句所在的事务已被回滚（40001、40P01）。
+// 执行中途连接断开（EOF、08006、57P01 等）时语句可能已经提交
+func notExecuted(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) {
+		return true
+	}
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "40001", "40P01", "08001", "08004", "57P03":
+			return true
+		}
+		return false
+	}
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "dial"
+}
+
+// uncertain 写入因连接中断失败、语句可能已经生效时，把错误包装为 store.ErrResultUnknown
+func uncertain(err error) error {
+	if err != nil && isConnError(err) && !notExecuted(err) {
+		return fmt.Errorf("%w: %w", store.ErrResultUnknown, err)
+	}
+	return err
+}
+
+// do 在分片上执行 attempt：熔断时直接失败，结果计入熔断；
+// 遇到瞬时错误且 retry(err) 返回 true 时按 currentRetryPolicy 退避后重试，直到成功、
+// 错误不可重试、次数用完或 ctx 结束
+func (s *Shard) do(ctx context.Context, op string, retry func(error) bool, attempt func() error) error {
+	policy := currentRetryPolicy()
+	for n := 1; ; n++ {
+		if err := s.allow(); err != nil {
+			return err
+		}
+		err := s.observe(ctx, attempt())
+		if err == nil {
+			if n > 1 {
+				log.Printf("%s on %s succeeded after %d attempts", op, s.name, n)
+			}
+			return nil
+		}
+		if ctx.Err() != nil || !isTransient(err) || !retry(err) {
+			return err
+		}
+		if n >= policy.MaxAttempts {
+			retryExhausted.Add(s.name, 1)
+			log.Printf("%s on %s failed after %d attempts: %v", op, s.name, n, err)
+			return err
+		}
+
+		delay := backoff(policy, n)
+		retryCount.Add(s.name, 1)
+		log.Printf("%s on %s failed with transient error, retry %d/%d in %v: %v", op, s.name, n, policy.MaxAttempts-1, delay, err)
+		t := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return err
+		case <-t.C:
+		}
+	}
+}
+
+// backoff 第 n 次重试前的等待时间：在 (0, min(MaxDelay, BaseDelay*2^(n-1))] 中随机取值（full jitter），
+// 避免多个请求同时重试
+func backoff(p config.Retry, n int) time.Duration {
+	ceil := p.MaxDelay.Std()
+	if n-1 < 30 {
+		if d := p.BaseDelay.Std() << (n - 1); d > 0 && d < ceil {
+			ceil = d
+		}
+	}
+	return time.Duration(rand.Int64N(int64(ceil))) + 1
+}
@@ -0,0 +1,122 @@
+package db
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/lib/pq"
+
+	"my-gauss-app/config"
+	"my-gauss-app/store"
+)
+
+func TestNotExecuted(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"bad conn", fmt.Errorf("exec: %w", driver.ErrBadConn), true},
+		{"dial", &net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{"serialization failure", &pq.Error{Code: "40001"}, true},
+		{"deadlock", &pq.Error{Code: "40P01"}, true},
+		{"connection rejected", &pq.Error{Code: "08004"}, true},
+		{"server starting up", &pq.Error{Code: "57P03"}, true},
+		// 语句发出之后连接断开，可能已经提交
+		{"connection failure", &pq.Error{Code: "08006"}, false},
+		{"admin shutdown", &pq.Error{Code: "57P01"}, false},
+		{"eof", io.EOF, false},
+		{"read", &net.OpError{Op: "read", Err: errors.New("connection reset")}, false},
+		{"unique violation", &pq.Error{Code: "23505"}, false},
+	}
+	for _, c := range cases {
+		if got := notExecuted(c.err); got != c.want {
+			t.Errorf("notExecuted(%s) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestUncertain(t *testing.T) {
+	if err := uncertain(io.EOF); !errors.Is(err, store.ErrResultUnknown) || !errors.Is(err, io.EOF) {
+		t.Errorf("uncertain(EOF) = %v, want ErrResultUnknown wrapping EOF", err)
+	}
+	for _, err := range []error{nil, driver.ErrBadConn, &pq.Error{Code: "40001"}, &pq.Error{Code: "23505"}} {
+		if got := uncertain(err); got != err {
+			t.Errorf("uncertain(%v) = %v, want it unchanged", err, got)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	p := config.Retry{MaxAttempts: 5, BaseDelay: config.Duration(10 * time.Millisecond), MaxDelay: config.Duration(35 * time.Millisecond)}
+	ceil := []time.Duration{10, 20, 35, 35, 35}
+	for n := 1; n <= len(ceil); n++ {
+		for i := 0; i < 100; i++ {
+			if d := backoff(p, n); d <= 0 || d > ceil[n-1]*time.Millisecond {
+				t.Fatalf("backoff(%d) = %v, want in (0, %v]", n, d, ceil[n-1]*time.Millisecond)
+			}
+		}
+	}
+	// 次数很大时不溢出
+	if d := backoff(p, 100); d <= 0 || d > p.MaxDelay.Std() {
+		t.Errorf("backoff(100) = %v, want in (0, %v]", d, p.MaxDelay.Std())
+	}
+}
+
+// withRetryPolicy 测试期间使用很短的退避
+func withRetryPolicy(t *testing.T, attempts int) {
+	t.Helper()
+	old := currentRetryPolicy()
+	setRetryPolicy(config.Retry{MaxAttempts: attempts, BaseDelay: config.Duration(time.Millisecond), MaxDelay: config.Duration(time.Millisecond)})
+	t.Cleanup(func() { setRetryPolicy(old) })
+}
+
+func TestDoRetries(t *testing.T) {
+	withRetryPolicy(t, 3)
+	ctx := context.Background()
+	cases := []struct {
+		name  string
+		retry func(error) bool
+		err   error
+		calls int
+	}{
+		{"read retries transient errors", always, io.EOF, 3},
+		{"read does not retry permanent errors", always, &pq.Error{Code: "23505"}, 1},
+		{"write retries unsent statements", notExecuted, driver.ErrBadConn, 3},
+		{"write does not retry interrupted statements", notExecuted, io.EOF, 1},
+		{"never", never, &pq.Error{Code: "40001"}, 1},
+	}
+	for _, c := range cases {
+		s := &Shard{name: "og2", health: newBreaker(100)}
+		calls := 0
+		err := s.do(ctx, "test", c.retry, func() error {
+			calls++
+			return c.err
+		})
+		if calls != c.calls {
+			t.Errorf("%s: %d call(s), want %d", c.name, calls, c.calls)
+		}
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: err = %v, want %v", c.name, err, c.err)
+		}
+	}
+
+	// 重试后成功
+	s := &Shard{name: "og2", health: newBreaker(100)}
+	calls := 0
+	err := s.do(ctx, "test", notExecuted, func() error {
+		if calls++; calls < 2 {
+			return &pq.Error{Code: "40P01"}
+		}
+		return nil
+	})
+	if err != nil || calls != 2 {
+		t.Errorf("do = %v after %d call(s), want success after 2", err, calls)
+	}
+}
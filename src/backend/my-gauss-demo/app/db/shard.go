@@ -0,0 +1,301 @@
+// db/shard.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"slices"
+	"sync/atomic"
+	"time"
+
+	"github.com/lib/pq"
+
+	"my-gauss-app/config"
+	"my-gauss-app/store"
+)
+
+// Shard 一个 openGauss 实例。ID 同时是该实例上分片表的后缀（document_<ID> 等）
+type Shard struct {
+	sqlExecutor
+	id   int
+	name string
+	DB   *sql.DB
+
+	// replicas 只读副本，UseReplica 的查询轮流发往其中之一
+	replicas []*sql.DB
+	next     atomic.Uint32
+
+	// 建立连接池时使用的配置，热加载时据此判断连接池是否需要重建
+	dsn         string
+	replicaDSNs []string
+	pool        config.Pool
+
+	// health 熔断器，热加载沿用连接池时一并沿用
+	health *breaker
+}
+
+// openShard 按配置建立分片主库和只读副本的连接池，不检查连通性
+func openShard(id int, sc config.Shard, pool config.Pool, threshold int) (*Shard, error) {
+	conn, err := openPool(sc.DSN, pool)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", sc.Name, err)
+	}
+	s := &Shard{sqlExecutor: sqlExecutor{conn}, id: id, name: sc.Name, DB: conn, dsn: sc.DSN, pool: pool, health: newBreaker(threshold)}
+
+	for i, dsn := range sc.Replicas {
+		r, err := openPool(dsn, pool)
+		if err != nil {
+			s.close()
+			return nil, fmt.Errorf("%s replica #%d: %v", sc.Name, i+1, err)
+		}
+		s.replicas = append(s.replicas, r)
+		s.replicaDSNs = append(s.replicaDSNs, dsn)
+	}
+	return s, nil
+}
+
+func openPool(dsn string, pool config.Pool) (*sql.DB, error) {
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("connect failed: %v", err)
+	}
+	conn.SetMaxOpenConns(pool.MaxOpenConns)
+	if pool.MaxIdleConns > 0 {
+		// SetMaxIdleConns(0) 表示不保留空闲连接，未配置时保留 database/sql 的默认值
+		conn.SetMaxIdleConns(pool.MaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(pool.ConnMaxLifetime.Std())
+	return conn, nil
+}
+
+// ping 检查主库和所有副本的连通性
+func (s *Shard) ping(ctx context.Context) error {
+	if err := s.DB.PingContext(ctx); err != nil {
+		return fmt.Errorf("ping failed: %v", err)
+	}
+	for i, r := range s.replicas {
+		if err := r.PingContext(ctx); err != nil {
+			return fmt.Errorf("replica #%d: ping failed: %v", i+1, err)
+		}
+	}
+	return nil
+}
+
+// close 关闭主库和副本的连接池
+func (s *Shard) close() {
+	s.DB.Close()
+	for _, r := range s.replicas {
+		r.Close()
+	}
+}
+
+// sameConfig 连接配置是否与 sc/pool 完全一致，一致时热加载沿用原连接池
+func (s *Shard) sameConfig(sc config.Shard, pool config.Pool) bool {
+	return s.dsn == sc.DSN && s.pool == pool && slices.Equal(s.replicaDSNs, sc.Replicas)
+}
+
+// reader 返回执行只读查询的连接：ctx 允许读副本且配置了副本时轮流选择一个副本，否则为主库
+func (s *Shard) reader(ctx context.Context) (sqlExecutor, bool) {
+	if len(s.replicas) == 0 || !store.UseReplica(ctx) {
+		return s.sqlExecutor, false
+	}
+	i := s.next.Add(1) % uint32(len(s.replicas))
+	return sqlExecutor{s.replicas[i]}, true
+}
+
+// Scan 只读查询；允许读副本时发往副本，副本在返回任何行之前失败则改读主库。
+// 熔断只看主库：副本失败总能退回主库。瞬时错误在返回任何行之前可以重试
+func (s *Shard) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	delivered := false
+	track := func(row store.Row) error {
+		delivered = true
+		return fn(row)
+	}
+	return s.do(ctx, "scan "+q.Table, func(error) bool { return !delivered }, func() error {
+		r, replica := s.reader(ctx)
+		if !replica {
+			return s.sqlExecutor.Scan(ctx, q, track)
+		}
+		err := r.Scan(ctx, q, track)
+		if err != nil && !delivered && ctx.Err() == nil {
+			log.Printf("Replica read on %s failed, falling back to primary: %v", s.name, err)
+			return s.sqlExecutor.Scan(ctx, q, track)
+		}
+		return err
+	})
+}
+
+func (s *Shard) Count(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	var n int64
+	err := s.do(ctx, "count "+table, always, func() error {
+		var err error
+		r, replica := s.reader(ctx)
+		n, err = r.Count(ctx, table, where)
+		if err != nil && replica && ctx.Err() == nil {
+			log.Printf("Replica read on %s failed, falling back to primary: %v", s.name, err)
+			n, err = s.sqlExecutor.Count(ctx, table, where)
+		}
+		return err
+	})
+	return n, err
+}
+
+// Insert、Upsert、Update、Delete、Truncate 在熔断时直接失败，连接类错误计入熔断；
+// 只在语句确定没有执行时重试（见 notExecuted）。执行中途连接断开时不重试，
+// 返回的错误满足 errors.Is(err, store.ErrResultUnknown)，由调用方重新读取确认是否已经生效
+
+func (s *Shard) Insert(ctx context.Context, table string, row store.Row) error {
+	return uncertain(s.do(ctx, "insert "+table, notExecuted, func() error {
+		return s.sqlExecutor.Insert(ctx, table, row)
+	}))
+}
+
+func (s *Shard) Upsert(ctx context.Context, table string, row store.Row, update []string) (store.UpsertResult, error) {
+	var result store.UpsertResult
+	err := s.do(ctx, "upsert "+table, notExecuted, func() error {
+		var err error
+		result, err = s.sqlExecutor.Upsert(ctx, table, row, update)
+		return err
+	})
+	return result, uncertain(err)
+}
+
+func (s *Shard) Update(ctx context.Context, table string, set store.Row, where []store.Cond) (int64, error) {
+	var n int64
+	err := s.do(ctx, "update "+table, notExecuted, func() error {
+		var err error
+		n, err = s.sqlExecutor.Update(ctx, table, set, where)
+		return err
+	})
+	return n, uncertain(err)
+}
+
+func (s *Shard) Delete(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	var n int64
+	err := s.do(ctx, "delete "+table, notExecuted, func() error {
+		var err error
+		n, err = s.sqlExecutor.Delete(ctx, table, where)
+		return err
+	})
+	return n, uncertain(err)
+}
+
+func (s *Shard) Truncate(ctx context.Context, table string) error {
+	return uncertain(s.do(ctx, "truncate "+table, notExecuted, func() error {
+		return s.sqlExecutor.Truncate(ctx, table)
+	}))
+}
+
+func (s *Shard) ID() int      { return s.id }
+func (s *Shard) Name() string { return s.name }
+
+// Table 返回逻辑表（document/permission/content）在该分片上的物理表名
+func (s *Shard) Table(baseTable string) string {
+	return fmt.Sprintf("%s_%d", baseTable, s.id)
+}
+
+// Begin 在独占连接上开启事务。不使用 sql.Tx，因为 PREPARE TRANSACTION 之后
+// database/sql 无法正确结束 sql.Tx
+func (s *Shard) Begin(ctx context.Context) (store.Tx, error) {
+	// 事务开始前没有做任何事，获取连接或 BEGIN 失败总是可以重试
+	var conn *sql.Conn
+	err := s.do(ctx, "begin", always, func() error {
+		var err error
+		conn, err = s.DB.Conn(ctx)
+		if err != nil {
+			return err
+		}
+		if _, err := conn.ExecContext(ctx, "BEGIN"); err != nil {
+			conn.Close()
+			return err
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("begin on %s failed: %w", s.name, err)
+	}
+	return &Tx{sqlExecutor: sqlExecutor{conn}, shard: s, conn: conn}, nil
+}
+
+func (s *Shard) CommitPrepared(ctx context.Context, gid string) error {
+	return s.do(ctx, "commit prepared", never, func() error {
+		_, err := s.DB.ExecContext(ctx, "COMMIT PREPARED "+pq.QuoteLiteral(gid))
+		return err
+	})
+}
+
+func (s *Shard) RollbackPrepared(ctx context.Context, gid string) error {
+	return s.do(ctx, "rollback prepared", never, func() error {
+		_, err := s.DB.ExecContext(ctx, "ROLLBACK PREPARED "+pq.QuoteLiteral(gid))
+		return err
+	})
+}
+
+func (s *Shard) PreparedTransactions(ctx context.Context, prefix string) ([]store.PreparedTxn, error) {
+	var txns []store.PreparedTxn
+	err := s.do(ctx, "list prepared", always, func() error {
+		txns = nil
+		// 用数据库自己的时钟计算时长，不受应用与数据库之间时钟偏差的影响
+		rows, err := s.DB.QueryContext(ctx,
+			"SELECT gid, EXTRACT(EPOCH FROM now() - prepared) FROM pg_prepared_xacts WHERE gid LIKE $1 AND database = current_database()", prefix+"%")
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var gid string
+			var age float64
+			if err := rows.Scan(&gid, &age); err != nil {
+				return err
+			}
+			txns = append(txns, store.PreparedTxn{GID: gid, Age: time.Duration(age * float64(time.Second))})
+		}
+		return rows.Err()
+	})
+	return txns, err
+}
+
+// Tx 分片上的事务，独占一个连接直到结束
+type Tx struct {
+	sqlExecutor
+	shard *Shard
+	conn  *sql.Conn
+}
+
+func (t *Tx) Shard() store.Shard { return t.shard }
+
+func (t *Tx) Commit() error {
+	return t.finish("COMMIT")
+}
+
+// Rollback 回滚事务；事务已结束（包括 Prepare 失败）时什么也不做
+func (t *Tx) Rollback() error {
+	if t.conn == nil {
+		return nil
+	}
+	return t.finish("ROLLBACK")
+}
+
+// Prepare 执行 PREPARE TRANSACTION；之后事务脱离连接，由 CommitPrepared/RollbackPrepared 结束
+func (t *Tx) Prepare(ctx context.Context, gid string) error {
+	if t.conn == nil {
+		return fmt.Errorf("transaction already finished")
+	}
+	_, err := t.conn.ExecContext(ctx, "PREPARE TRANSACTION "+pq.QuoteLiteral(gid))
+	t.conn.Close()
+	t.conn = nil
+	return err
+}
+
+func (t *Tx) finish(stmt string) error {
+	if t.conn == nil {
+		return fmt.Errorf("transaction already finished")
+	}
+	_, err := t.conn.ExecContext(context.Background(), stmt)
+	t.conn.Close()
+	t.conn = nil
+	return err
+}
@@ -0,0 +1,343 @@
+// db/sql.go
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/lib/pq"
+
+	"my-gauss-app/store"
+)
+
+// querier *sql.DB 和 *sql.Conn 的公共部分
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// sqlExecutor 把 store.Executor 的操作翻译为 openGauss SQL，分片和事务共用
+type sqlExecutor struct {
+	q querier
+}
+
+// quoteIdent 转义表名/列名
+func quoteIdent(name string) string {
+	return pq.QuoteIdentifier(name)
+}
+
+// whereClause 生成 "a = $n AND b = $n+1"，参数编号从 len(args)+1 开始
+func whereClause(where []store.Cond, args []interface{}) (string, []interface{}) {
+	if len(where) == 0 {
+		return "", args
+	}
+	conds := make([]string, len(where))
+	for i, c := range where {
+		args = append(args, c.Value)
+		conds[i] = fmt.Sprintf("%s = $%d", quoteIdent(c.Column), len(args))
+	}
+	return " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// filterSQL 把过滤表达式编译为参数化 SQL，值追加到 args 中。
+// LIKE / ILIKE 先把列转成文本，数值和时间列也可以按模式匹配
+func filterSQL(e *store.Expr, args []interface{}) (string, []interface{}, error) {
+	col := quoteIdent(e.Column)
+	switch e.Op {
+	case store.OpAnd, store.OpOr:
+		if len(e.Args) == 0 {
+			if e.Op == store.OpAnd {
+				return "TRUE", args, nil
+			}
+			return "FALSE", args, nil
+		}
+		parts := make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			var err error
+			if parts[i], args, err = filterSQL(arg, args); err != nil {
+				return "", nil, err
+			}
+		}
+		sep := " AND "
+		if e.Op == store.OpOr {
+			sep = " OR "
+		}
+		return "(" + strings.Join(parts, sep) + ")", args, nil
+	case store.OpEq, store.OpNe, store.OpLt, store.OpGt:
+		args = append(args, e.Value)
+		return fmt.Sprintf("%s %s $%d", col, e.Op, len(args)), args, nil
+	case store.OpIn:
+		if len(e.Values) == 0 {
+			return "FALSE", args, nil
+		}
+		params := make([]string, len(e.Values))
+		for i, v := range e.Values {
+			args = append(args, v)
+			params[i] = fmt.Sprintf("$%d", len(args))
+		}
+		return fmt.Sprintf("%s IN (%s)", col, strings.Join(params, ", ")), args, nil
+	case store.OpLike, store.OpILike:
+		args = append(args, e.Value)
+		return fmt.Sprintf("CAST(%s AS TEXT) %s $%d", col, strings.ToUpper(string(e.Op)), len(args)), args, nil
+	case store.OpIsNull:
+		if e.Not {
+			return col + " IS NOT NULL", args, nil
+		}
+		return col + " IS NULL", args, nil
+	}
+	return "", nil, fmt.Errorf("unsupported filter operator %q", e.Op)
+}
+
+// wrapError 将主键冲突（SQLSTATE 23505）包装为 store.ErrDuplicateKey
+func wrapError(err error) error {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) && pqErr.Code == "23505" {
+		return fmt.Errorf("%w: %w", store.ErrDuplicateKey, err)
+	}
+	return err
+}
+
+// selectSQL 生成查询 q 的 SELECT 语句和参数
+func selectSQL(q store.Query) (string, []interface{}, error) {
+	cols := make([]string, len(q.Columns))
+	for i, c := range q.Columns {
+		cols[i] = quoteIdent(c)
+	}
+	selectList := "*"
+	if len(cols) > 0 {
+		selectList = strings.Join(cols, ", ")
+	}
+	if q.Distinct {
+		selectList = "DISTINCT " + selectList
+	}
+
+	where, args := whereClause(q.Where, nil)
+	if q.Filter != nil {
+		cond, filterArgs, err := filterSQL(q.Filter, args)
+		if err != nil {
+			return "", nil, err
+		}
+		args = filterArgs
+		if where == "" {
+			where = " WHERE " + cond
+		} else {
+			where += " AND " + cond
+		}
+	}
+
+	// keyset：(a, b) > (x, y) 展开为 a > x OR (a = x AND b > y)，降序的列比较方向相反
+	if len(q.After) > 0 {
+		var ors []string
+		for i := range q.OrderBy {
+			var ands []string
+			for j := 0; j < i; j++ {
+				ands = append(ands, fmt.Sprintf("%s = $%d", quoteIdent(q.OrderBy[j]), len(args)+j+1))
+			}
+			op := ">"
+			if slices.Contains(q.Desc, q.OrderBy[i]) {
+				op = "<"
+			}
+			ands = append(ands, fmt.Sprintf("%s %s $%d", orderExpr(q, q.OrderBy[i]), op, len(args)+i+1))
+			ors = append(ors, "("+strings.Join(ands, " AND ")+")")
+		}
+		args = append(args, q.After...)
+		keyset := "(" + strings.Join(ors, " OR ") + ")"
+		if where == "" {
+			where = " WHERE " + keyset
+		} else {
+			where += " AND " + keyset
+		}
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s%s", selectList, quoteIdent(q.Table), where)
+	if len(q.OrderBy) > 0 {
+		order := make([]string, len(q.OrderBy))
+		for i, c := range q.OrderBy {
+			order[i] = orderExpr(q, c)
+			if slices.Contains(q.Desc, c) {
+				order[i] += " DESC"
+			}
+		}
+		query += " ORDER BY " + strings.Join(order, ", ")
+	}
+	if q.Limit > 0 {
+		query += fmt.Sprintf(" LIMIT %d", q.Limit)
+	}
+	return query, args, nil
+}
+
+func (e sqlExecutor) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	query, args, err := selectSQL(q)
+	if err != nil {
+		return err
+	}
+
+	rows, err := e.q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	names, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		vals := make([]interface{}, len(names))
+		ptrs := make([]interface{}, len(names))
+		for i := range vals {
+			ptrs[i] = &vals[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return err
+		}
+
+		row := make(store.Row, len(names))
+		for i, name := range names {
+			// numeric 等类型以 []byte 返回，统一转成字符串
+			if b, ok := vals[i].([]byte); ok {
+				row[name] = string(b)
+			} else {
+				row[name] = vals[i]
+			}
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// orderExpr 排序和 keyset 比较中的列：文本列加 COLLATE "C" 按字节序比较，
+// 其他类型的列不支持排序规则，按自身类型比较
+func orderExpr(q store.Query, col string) string {
+	if slices.Contains(q.Bytewise, col) {
+		return quoteIdent(col) + ` COLLATE "C"`
+	}
+	return quoteIdent(col)
+}
+
+func (e sqlExecutor) Count(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	clause, args := whereClause(where, nil)
+	var n int64
+	err := e.q.QueryRowContext(ctx, fmt.Sprintf("SELECT COUNT(*) FROM %s%s", quoteIdent(table), clause), args...).Scan(&n)
+	return n, err
+}
+
+func (e sqlExecutor) Insert(ctx context.Context, table string, row store.Row) error {
+	query, args := insertSQL(table, row)
+	_, err := e.q.ExecContext(ctx, query, args...)
+	return wrapError(err)
+}
+
+// insertSQL 生成插入 row 的语句和参数
+func insertSQL(table string, row store.Row) (string, []interface{}) {
+	// 列按名称排序，保证同一张表生成的语句一致
+	names := make([]string, 0, len(row))
+	for name := range row {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	cols := make([]string, len(names))
+	placeholders := make([]string, len(names))
+	args := make([]interface{}, len(names))
+	for i, name := range names {
+		cols[i] = quoteIdent(name)
+		placeholders[i] = fmt.Sprintf("$%d", i+1)
+		args[i] = row[name]
+	}
+
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		quoteIdent(table),
+		strings.Join(cols, ", "),
+		strings.Join(placeholders, ", "))
+	return query, args
+}
+
+// Upsert 用一条 INSERT ... ON DUPLICATE KEY UPDATE 完成。新插入的行 xmax 为 0，
+// 按主键更新的行 xmax 是当前事务号；update 为空时（UPDATE NOTHING）按影响行数区分插入和保持不变。
+// 两者在 openGauss 上的行为由 TestUpsertOnGauss 核对
+func (e sqlExecutor) Upsert(ctx context.Context, table string, row store.Row, update []string) (store.UpsertResult, error) {
+	query, args := upsertSQL(table, row, update)
+	if len(update) == 0 {
+		res, err := e.q.ExecContext(ctx, query, args...)
+		if err != nil {
+			return 0, wrapError(err)
+		}
+		n, err := res.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		if n > 0 {
+			return store.UpsertInserted, nil
+		}
+		return store.UpsertUnchanged, nil
+	}
+
+	var inserted bool
+	if err := e.q.QueryRowContext(ctx, query, args...).Scan(&inserted); err != nil {
+		return 0, wrapError(err)
+	}
+	if inserted {
+		return store.UpsertInserted, nil
+	}
+	return store.UpsertUpdated, nil
+}
+
+// upsertSQL 生成 Upsert 的语句和参数
+func upsertSQL(table string, row store.Row, update []string) (string, []interface{}) {
+	query, args := insertSQL(table, row)
+	if len(update) == 0 {
+		return query + " ON DUPLICATE KEY UPDATE NOTHING", args
+	}
+	cols := slices.Clone(update)
+	sort.Strings(cols)
+	assigns := make([]string, len(cols))
+	for i, col := range cols {
+		assigns[i] = fmt.Sprintf("%s = EXCLUDED.%s", quoteIdent(col), quoteIdent(col))
+	}
+	return query + " ON DUPLICATE KEY UPDATE " + strings.Join(assigns, ", ") + " RETURNING (xmax::text = '0')", args
+}
+
+func (e sqlExecutor) Update(ctx context.Context, table string, set store.Row, where []store.Cond) (int64, error) {
+	names := make([]string, 0, len(set))
+	for name := range set {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	assigns := make([]string, len(names))
+	args := make([]interface{}, 0, len(names)+len(where))
+	for i, name := range names {
+		args = append(args, set[name])
+		assigns[i] = fmt.Sprintf("%s = $%d", quoteIdent(name), len(args))
+	}
+	clause, args := whereClause(where, args)
+
+	res, err := e.q.ExecContext(ctx, fmt.Sprintf("UPDATE %s SET %s%s", quoteIdent(table), strings.Join(assigns, ", "), clause), args...)
+	if err != nil {
+		return 0, wrapError(err)
+	}
+	return res.RowsAffected()
+}
+
+func (e sqlExecutor) Delete(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	clause, args := whereClause(where, nil)
+	res, err := e.q.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s%s", quoteIdent(table), clause), args...)
+	if err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
+
+func (e sqlExecutor) Truncate(ctx context.Context, table string) error {
+	_, err := e.q.ExecContext(ctx, fmt.Sprintf("TRUNCATE TABLE %s", quoteIdent(table)))
+	return err
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// TestUpsertOnGauss 在真实的 openGauss 上核对 Upsert 依赖的两个行为：RETURNING (xmax::text = '0')
+// 区分插入和更新，ON DUPLICATE KEY UPDATE NOTHING 在主键已存在时影响 0 行。
+// 设置 GAUSS_TEST_DSN 时执行，例如对 docker-compose 中的 og1：
+//
+//	GAUSS_TEST_DSN="host=localhost port=5432 user=gaussdb password=... dbname=postgres sslmode=disable" go test ./db -run OnGauss
+func TestUpsertOnGauss(t *testing.T) {
+	dsn := os.Getenv("GAUSS_TEST_DSN")
+	if dsn == "" {
+		t.Skip("GAUSS_TEST_DSN is not set")
+	}
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx := context.Background()
+	table := fmt.Sprintf("upsert_test_%d", time.Now().UnixNano())
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf("CREATE TABLE %s (id VARCHAR(64) PRIMARY KEY, n INT)", quoteIdent(table))); err != nil {
+		t.Fatal(err)
+	}
+	defer conn.ExecContext(ctx, fmt.Sprintf("DROP TABLE %s", quoteIdent(table)))
+
+	e := sqlExecutor{conn}
+	steps := []struct {
+		row    store.Row
+		update []string
+		want   store.UpsertResult
+	}{
+		{store.Row{"id": "a", "n": 1}, []string{"n"}, store.UpsertInserted},
+		{store.Row{"id": "a", "n": 2}, []string{"n"}, store.UpsertUpdated},
+		{store.Row{"id": "a", "n": 3}, nil, store.UpsertUnchanged},
+		{store.Row{"id": "b", "n": 4}, nil, store.UpsertInserted},
+	}
+	for i, s := range steps {
+		got, err := e.Upsert(ctx, table, s.row, s.update)
+		if err != nil {
+			t.Fatalf("step %d: %v", i+1, err)
+		}
+		if got != s.want {
+			t.Errorf("step %d: Upsert(%v, %v) = %v, want %v", i+1, s.row, s.update, got, s.want)
+		}
+	}
+
+	for id, want := range map[string]int64{"a": 2, "b": 4} {
+		n, err := e.Count(ctx, table, []store.Cond{{Column: "id", Value: id}, {Column: "n", Value: want}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != 1 {
+			t.Errorf("row %s after upserts, want n = %d", id, want)
+		}
+	}
+
+	// 事务中更新的行 xmax 同样不为 0
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if got, err := (sqlExecutor{tx}).Upsert(ctx, table, store.Row{"id": "b", "n": 5}, []string{"n"}); err != nil || got != store.UpsertUpdated {
+		t.Errorf("Upsert in a transaction = %v, %v, want updated", got, err)
+	}
+}
@@ -0,0 +1,155 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+
+	"my-gauss-app/store"
+)
+
+func TestFilterSQL(t *testing.T) {
+	tests := []struct {
+		name     string
+		expr     *store.Expr
+		prior    []interface{}
+		wantSQL  string
+		wantArgs []interface{}
+	}{
+		{
+			name:     "comparison numbered after prior args",
+			expr:     &store.Expr{Op: store.OpGt, Column: "overall_permission", Value: 2},
+			prior:    []interface{}{"u1"},
+			wantSQL:  `"overall_permission" > $2`,
+			wantArgs: []interface{}{"u1", 2},
+		},
+		{
+			name:     "in",
+			expr:     &store.Expr{Op: store.OpIn, Column: "room_id", Values: []interface{}{"r1", "r2"}},
+			wantSQL:  `"room_id" IN ($1, $2)`,
+			wantArgs: []interface{}{"r1", "r2"},
+		},
+		{
+			name:    "empty in",
+			expr:    &store.Expr{Op: store.OpIn, Column: "room_id"},
+			wantSQL: `FALSE`,
+		},
+		{
+			name:     "ilike casts to text",
+			expr:     &store.Expr{Op: store.OpILike, Column: "create_time", Value: "2026-%"},
+			wantSQL:  `CAST("create_time" AS TEXT) ILIKE $1`,
+			wantArgs: []interface{}{"2026-%"},
+		},
+		{
+			name:    "is not null",
+			expr:    &store.Expr{Op: store.OpIsNull, Column: "room_name", Not: true},
+			wantSQL: `"room_name" IS NOT NULL`,
+		},
+		{
+			name: "nested",
+			expr: &store.Expr{Op: store.OpAnd, Args: []*store.Expr{
+				{Op: store.OpEq, Column: "owner_user_id", Value: "u1"},
+				{Op: store.OpOr, Args: []*store.Expr{
+					{Op: store.OpLike, Column: "room_name", Value: "a%"},
+					{Op: store.OpIsNull, Column: "room_name"},
+				}},
+			}},
+			wantSQL:  `("owner_user_id" = $1 AND (CAST("room_name" AS TEXT) LIKE $2 OR "room_name" IS NULL))`,
+			wantArgs: []interface{}{"u1", "a%"},
+		},
+		{
+			name:    "empty and / or",
+			expr:    &store.Expr{Op: store.OpOr, Args: []*store.Expr{{Op: store.OpAnd}, {Op: store.OpOr}}},
+			wantSQL: `(TRUE OR FALSE)`,
+		},
+		{
+			name:     "quoted identifier",
+			expr:     &store.Expr{Op: store.OpEq, Column: `a"b`, Value: 1},
+			wantSQL:  `"a""b" = $1`,
+			wantArgs: []interface{}{1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, args, err := filterSQL(tt.expr, tt.prior)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if sql != tt.wantSQL {
+				t.Errorf("sql = %s, want %s", sql, tt.wantSQL)
+			}
+			if len(args) != 0 || len(tt.wantArgs) != 0 {
+				if !reflect.DeepEqual(args, tt.wantArgs) {
+					t.Errorf("args = %v, want %v", args, tt.wantArgs)
+				}
+			}
+		})
+	}
+}
+
+func TestFilterSQLRejectsUnknownOp(t *testing.T) {
+	if _, _, err := filterSQL(&store.Expr{Op: "~", Column: "a"}, nil); err == nil {
+		t.Fatal("filterSQL accepted an unknown operator")
+	}
+}
+
+func TestOrderExprCollatesOnlyTextColumns(t *testing.T) {
+	q := store.Query{OrderBy: []string{"room_id", "seq"}, Bytewise: []string{"room_id"}}
+	if got, want := orderExpr(q, "room_id"), `"room_id" COLLATE "C"`; got != want {
+		t.Errorf("orderExpr(room_id) = %s, want %s", got, want)
+	}
+	if got, want := orderExpr(q, "seq"), `"seq"`; got != want {
+		t.Errorf("orderExpr(seq) = %s, want %s", got, want)
+	}
+}
+
+func TestSelectSQLOrderAndLimit(t *testing.T) {
+	q := store.Query{
+		Table:    "comment_0",
+		Columns:  []string{"room_id", "seq"},
+		OrderBy:  []string{"seq", "room_id"},
+		Desc:     []string{"seq"},
+		Bytewise: []string{"room_id"},
+		Limit:    5,
+	}
+	got, _, err := selectSQL(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `SELECT "room_id", "seq" FROM "comment_0" ORDER BY "seq" DESC, "room_id" COLLATE "C" LIMIT 5`
+	if got != want {
+		t.Errorf("selectSQL = %s\nwant %s", got, want)
+	}
+
+	// 降序列的 keyset 条件取更小的值
+	q.After = []interface{}{int64(3), "r1"}
+	got, args, err := selectSQL(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `SELECT "room_id", "seq" FROM "comment_0" WHERE (("seq" < $1) OR ("seq" = $1 AND "room_id" COLLATE "C" > $2)) ORDER BY "seq" DESC, "room_id" COLLATE "C" LIMIT 5`
+	if got != want {
+		t.Errorf("selectSQL = %s\nwant %s", got, want)
+	}
+	if len(args) != 2 {
+		t.Errorf("args = %v, want the two keyset values", args)
+	}
+}
+
+func TestUpsertSQL(t *testing.T) {
+	row := store.Row{"id": 1, "n": 2, "note": "x"}
+	got, args := upsertSQL("counter", row, []string{"note", "n"})
+	want := `INSERT INTO "counter" ("id", "n", "note") VALUES ($1, $2, $3) ON DUPLICATE KEY UPDATE "n" = EXCLUDED."n", "note" = EXCLUDED."note" RETURNING (xmax::text = '0')`
+	if got != want {
+		t.Errorf("upsertSQL = %s\nwant %s", got, want)
+	}
+	if !reflect.DeepEqual(args, []interface{}{1, 2, "x"}) {
+		t.Errorf("args = %v", args)
+	}
+
+	// 没有要更新的列时冲突的行保持不变
+	got, _ = upsertSQL("counter", row, nil)
+	want = `INSERT INTO "counter" ("id", "n", "note") VALUES ($1, $2, $3) ON DUPLICATE KEY UPDATE NOTHING`
+	if got != want {
+		t.Errorf("upsertSQL = %s\nwant %s", got, want)
+	}
+}
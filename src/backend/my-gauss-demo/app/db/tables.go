@@ -0,0 +1,52 @@
+// db/tables.go
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"my-gauss-app/store"
+)
+
+// prepareShard 把分片迁移到最新版本，再创建声明了列类型但尚不存在的表
+func (s *Store) prepareShard(ctx context.Context, sh *Shard) error {
+	if err := s.migrateShard(ctx, sh, LatestVersion()); err != nil {
+		return err
+	}
+	return s.createTables(ctx, sh)
+}
+
+// createTables 在分片上执行 CREATE TABLE IF NOT EXISTS：分片表建在每个分片上，
+// 其余只建在 UserShard 上。已存在的表不做修改，增删列需要另写迁移
+func (s *Store) createTables(ctx context.Context, sh *Shard) error {
+	for _, def := range s.tables {
+		if len(def.Types) == 0 {
+			continue
+		}
+		name := def.Name
+		if def.Sharded {
+			name = sh.Table(def.Name)
+		} else if sh.id != 0 {
+			continue
+		}
+		if _, err := sh.DB.ExecContext(ctx, createTableSQL(name, def)); err != nil {
+			return fmt.Errorf("create table %s on %s failed: %v", name, sh.name, err)
+		}
+	}
+	return nil
+}
+
+// createTableSQL 生成建表语句。列类型来自 model 中校验过的数据集声明
+func createTableSQL(name string, def store.TableDef) string {
+	parts := make([]string, 0, len(def.Columns)+1)
+	for _, col := range def.Columns {
+		parts = append(parts, quoteIdent(col)+" "+def.Types[col])
+	}
+	pk := make([]string, len(def.PrimaryKey))
+	for i, col := range def.PrimaryKey {
+		pk[i] = quoteIdent(col)
+	}
+	parts = append(parts, "PRIMARY KEY ("+strings.Join(pk, ", ")+")")
+	return fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (%s)", quoteIdent(name), strings.Join(parts, ", "))
+}
@@ -1,20 +1,25 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
 
 	"my-gauss-app/model"
+	"my-gauss-app/store"
 )
 
 // HandleReadDataset 处理主键查询请求
 // GET /api/dataset/read?dataset_name=user&main_key=123&goal_key=*
 func HandleReadDataset(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -24,7 +29,7 @@ func HandleReadDataset(w http.ResponseWriter, r *http.Request) {
 	goalKey := query.Get("goal_key")
 
 	if datasetName == "" || mainKeyStr == "" {
-		http.Error(w, "Missing required parameters: dataset_name, main_key", http.StatusBadRequest)
+		httpError(w, "Missing required parameters: dataset_name, main_key", http.StatusBadRequest)
 		return
 	}
 
@@ -32,6 +37,16 @@ func HandleReadDataset(w http.ResponseWriter, r *http.Request) {
 		goalKey = "*"
 	}
 
+	ctx, partial, ok := readContext(w, r)
+	if !ok {
+		return
+	}
+
+	// 整表读取支持分页和流式输出
+	if mainKeyStr == "*" && goalKey == "*" && servePagedDataset(ctx, partial, w, r, datasetName) {
+		return
+	}
+
 	// 解析 main_key，可能是单个值或元组
 	var mainKey interface{}
 
@@ -44,28 +59,26 @@ func HandleReadDataset(w http.ResponseWriter, r *http.Request) {
 		mainKey = mainKeyStr
 	}
 
-	result, err := model.ReadDataset(datasetName, mainKey, goalKey)
+	// 没有匹配的行时返回 404（not_found）；行存在但 goal_key 列为 NULL 时 result 为 null
+	result, err := model.ReadDataset(ctx, datasetName, mainKey, goalKey)
 	if err != nil {
 		log.Printf("ReadDataset failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if result == nil {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]interface{}{"result": nil})
+		writeQueryError(ctx, w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	json.NewEncoder(w).Encode(withDegraded(w, partial, map[string]interface{}{"result": result}))
 }
 
 // HandleReadDatasetCondition 处理条件查询请求
 // GET /api/dataset/read_condition?dataset_name=user&key_name=email&key_value=test@example.com&goal_key=*
+//
+// 返回所有匹配的行（见 serveConditionRows）；filter 参数为 JSON 过滤表达式，可以代替 key_name/key_value。
+// single=true 时只返回第一条命中的行（goal_key 不是 "*" 时为该列的值），没有匹配时返回 404
 func HandleReadDatasetCondition(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -74,11 +87,10 @@ func HandleReadDatasetCondition(w http.ResponseWriter, r *http.Request) {
 	keyName := query.Get("key_name")
 	keyValueStr := query.Get("key_value")
 	goalKey := query.Get("goal_key")
-	fmt.Printf("Received request - dataset_name: %s, key_name: %s, key_value: %s, goal_key: %s\n",
-		datasetName, keyName, keyValueStr, goalKey)
+	filterStr := query.Get("filter")
 
-	if datasetName == "" || keyName == "" || keyValueStr == "" {
-		http.Error(w, "Missing required parameters: dataset_name, key_name, key_value", http.StatusBadRequest)
+	if datasetName == "" || (filterStr == "" && (keyName == "" || keyValueStr == "")) {
+		httpError(w, "Missing required parameters: dataset_name, and key_name, key_value or filter", http.StatusBadRequest)
 		return
 	}
 
@@ -86,42 +98,97 @@ func HandleReadDatasetCondition(w http.ResponseWriter, r *http.Request) {
 		goalKey = "*"
 	}
 
+	ctx, partial, ok := readContext(w, r)
+	if !ok {
+		return
+	}
+
+	if filterStr != "" {
+		filter, err := model.ParseFilter([]byte(filterStr))
+		if err != nil {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		serveConditionRows(ctx, partial, w, r, datasetName, filter)
+		return
+	}
+
 	// URL 解码
 	keyValue, err := url.QueryUnescape(keyValueStr)
 	if err != nil {
 		keyValue = keyValueStr
 	}
 
-	result, err := model.ReadDatasetCondition(datasetName, keyName, keyValue, goalKey)
+	single := false
+	if s := query.Get("single"); s != "" {
+		if single, err = strconv.ParseBool(s); err != nil {
+			httpError(w, "single must be true or false", http.StatusBadRequest)
+			return
+		}
+	}
+	if !single {
+		serveConditionRows(ctx, partial, w, r, datasetName, &store.Expr{Op: store.OpEq, Column: keyName, Value: keyValue})
+		return
+	}
+
+	// 没有匹配的行时返回 404（not_found）
+	result, err := model.ReadDatasetCondition(ctx, datasetName, keyName, keyValue, goalKey)
 	if err != nil {
 		log.Printf("ReadDatasetCondition failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeQueryError(ctx, w, err)
 		return
 	}
 
-	if result == nil {
-		w.WriteHeader(http.StatusOK)
-		json.NewEncoder(w).Encode(map[string]interface{}{
-			"result": nil,
-			"debug": map[string]string{
-				"dataset_name": datasetName,
-				"key_name":     keyName,
-				"key_value":    keyValueStr,
-				"goal_key":     goalKey,
-			},
-		})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(withDegraded(w, partial, map[string]interface{}{"result": result}))
+}
+
+// serveConditionRows 处理条件查询的多行参数：
+//   - goal_keys：逗号分隔的列名，省略时使用 goal_key，"*" 表示整行
+//   - limit：最多返回的行数
+//   - order_by：逗号分隔的排序列，列名前加 "-" 表示降序，省略时按主键排序
+//
+// 返回满足 filter 的行 {"result": [{列名: 值, ...}, ...]}，没有匹配时为空数组
+func serveConditionRows(ctx context.Context, partial *model.Partial, w http.ResponseWriter, r *http.Request, datasetName string, filter *store.Expr) {
+	query := r.URL.Query()
+	goalKeys := query.Get("goal_keys")
+	if goalKeys == "" {
+		goalKeys = query.Get("goal_key")
+	}
+	var goal []string
+	for _, col := range strings.Split(goalKeys, ",") {
+		if col = strings.TrimSpace(col); col != "" {
+			goal = append(goal, col)
+		}
+	}
+
+	limit := 0
+	if limitStr := query.Get("limit"); limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 {
+			httpError(w, "limit must be a positive integer", http.StatusBadRequest)
+			return
+		}
+		limit = n
+	}
+
+	rows, err := model.ReadRowsFilter(ctx, datasetName, filter, goal, model.ParseOrderBy(query.Get("order_by")), limit)
+	if err != nil {
+		log.Printf("ReadRowsFilter failed: %v", err)
+		writeQueryError(ctx, w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{"result": result})
+	json.NewEncoder(w).Encode(withDegraded(w, partial, map[string]interface{}{"result": rows}))
 }
 
-// HandleRemoveDatasetMainKey 删除某行数据集请求
-// GET /api/dataset/read_json?dataset_name=user_table
+// HandleRemoveDatasetMainKey 删除某行数据集请求，没有匹配的行时返回 404
+// POST /api/dataset/remove
+// Body: {"dataset_name": "permission", "main_key": ["room_id", "user_id"], "main_value": ["r1", "u1"]}
 func HandleRemoveDatasetMainKey(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -132,19 +199,19 @@ func HandleRemoveDatasetMainKey(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON body", http.StatusBadRequest)
+		httpError(w, "Invalid JSON body", http.StatusBadRequest)
 		return
 	}
 
 	if req.DatasetName == "" || req.MainKey == nil || req.MainValue == nil {
-		http.Error(w, "Missing required parameters: dataset_name, main_key, main_value", http.StatusBadRequest)
+		httpError(w, "Missing required parameters: dataset_name, main_key, main_value", http.StatusBadRequest)
 		return
 	}
 
-	err := model.RemoveDatasetMainKey(req.DatasetName, req.MainKey, req.MainValue)
+	err := model.RemoveDatasetMainKey(r.Context(), req.DatasetName, req.MainKey, req.MainValue)
 	if err != nil {
 		log.Printf("RemoveDatasetMainKey failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(r.Context(), w, err)
 		return
 	}
 
@@ -160,7 +227,7 @@ func HandleRemoveDatasetMainKey(w http.ResponseWriter, r *http.Request) {
 // Body: {"dataset_name": "user", "data": {"id": "123", "user_name": "test", "email": "test@example.com", "password": "pass"}}
 func HandleInsertDataIntoDataset(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -170,37 +237,79 @@ func HandleInsertDataIntoDataset(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
 		log.Printf("Invalid JSON: %v", err)
 		return
 	}
 
 	if req.DatasetName == "" {
-		http.Error(w, "Missing dataset_name", http.StatusBadRequest)
+		httpError(w, "Missing dataset_name", http.StatusBadRequest)
 		return
 	}
 
 	if req.Data == nil {
-		http.Error(w, "Missing data", http.StatusBadRequest)
+		httpError(w, "Missing data", http.StatusBadRequest)
 		return
 	}
 
-	if err := model.InsertDataIntoDataset(req.DatasetName, req.Data); err != nil {
+	if err := model.InsertDataIntoDataset(r.Context(), req.DatasetName, req.Data); err != nil {
 		log.Printf("InsertDataIntoDataset failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(r.Context(), w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Data inserted successfully"})
 }
 
+// HandleUpsertDataset 处理按主键插入或更新的请求
+// POST /api/dataset/upsert
+// Body: {"dataset_name": "permission", "data": {"room_id": "r1", "user_id": "u1", "permission": 2}, "update_columns": ["permission"]}
+//
+// update_columns 为主键已存在时覆盖的列，省略时覆盖 data 中除主键外的所有列，[] 表示保持原行不变。
+// 返回 {"result": "inserted"}、{"result": "updated"}，或 update_columns 为 [] 且主键已存在时的 {"result": "unchanged"}
+func HandleUpsertDataset(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req struct {
+		DatasetName   string                 `json:"dataset_name"`
+		Data          map[string]interface{} `json:"data"`
+		UpdateColumns []string               `json:"update_columns"`
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
+		log.Printf("Invalid JSON: %v", err)
+		return
+	}
+
+	if req.DatasetName == "" || req.Data == nil {
+		httpError(w, "Missing required parameters: dataset_name, data", http.StatusBadRequest)
+		return
+	}
+
+	result, err := model.UpsertDataset(r.Context(), req.DatasetName, req.Data, req.UpdateColumns)
+	if err != nil {
+		log.Printf("UpsertDataset failed: %v", err)
+		writeError(r.Context(), w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": result.String(), "inserted": result == store.UpsertInserted})
+}
+
 // HandleModifyDatasetCondition 处理修改数据请求
 // POST /api/dataset/modify
 // Body: {"dataset_name": "user", "key_name": "id", "key_value": "123", "goal_key": "email", "goal_value": "new@example.com"}
+//
+// goal_key 不能是主键或分片键的列（400）
 func HandleModifyDatasetCondition(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -213,38 +322,35 @@ func HandleModifyDatasetCondition(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
 		log.Printf("Invalid JSON: %v", err)
 		return
 	}
 
 	if req.DatasetName == "" || req.KeyName == "" || req.GoalKey == "" {
-		http.Error(w, "Missing required parameters", http.StatusBadRequest)
+		httpError(w, "Missing required parameters", http.StatusBadRequest)
 		return
 	}
 
-	modified, err := model.ModifyDatasetCondition(req.DatasetName, req.KeyName, req.KeyValue, req.GoalKey, req.GoalValue)
+	// 没有匹配的行时返回 404（not_found）
+	modified, err := model.ModifyDatasetCondition(r.Context(), req.DatasetName, req.KeyName, req.KeyValue, req.GoalKey, req.GoalValue)
 	if err != nil {
 		log.Printf("ModifyDatasetCondition failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
-		return
-	}
-
-	if !modified {
-		w.WriteHeader(http.StatusNotFound)
-		json.NewEncoder(w).Encode(map[string]interface{}{"modified": false, "message": "No rows matched"})
+		writeError(r.Context(), w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]interface{}{"modified": true, "message": "Data modified successfully"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"modified": modified, "message": "Data modified successfully"})
 }
 
 // HandleReadJSON 处理读取整个数据集请求
 // GET /api/dataset/read_json?dataset_name=user_table
+// GET /api/dataset/read_json?dataset_name=content&limit=100&after=<next>
+// GET /api/dataset/read_json?dataset_name=content&format=ndjson
 func HandleReadJSON(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -252,17 +358,29 @@ func HandleReadJSON(w http.ResponseWriter, r *http.Request) {
 	datasetName := query.Get("dataset_name")
 
 	if datasetName == "" {
-		http.Error(w, "Missing required parameter: dataset_name", http.StatusBadRequest)
+		httpError(w, "Missing required parameter: dataset_name", http.StatusBadRequest)
 		return
 	}
 
-	data, err := model.ReadJSON(datasetName)
+	ctx, partial, ok := readContext(w, r)
+	if !ok {
+		return
+	}
+
+	// 分页（limit/after）或流式（format=ndjson）读取
+	if servePagedDataset(ctx, partial, w, r, datasetName) {
+		return
+	}
+
+	data, err := model.ReadJSON(ctx, datasetName)
 	if err != nil {
 		log.Printf("ReadJSON failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeQueryError(ctx, w, err)
 		return
 	}
 
+	// 响应体是数组，降级信息只能放在响应头中
+	withDegraded(w, partial, nil)
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(data)
 }
@@ -272,7 +390,7 @@ func HandleReadJSON(w http.ResponseWriter, r *http.Request) {
 // Body: {"dataset_name": "user_table", "data": [{"id": "1", "user_name": "test", ...}, ...]}
 func HandleWriteJSON(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
@@ -282,13 +400,13 @@ func HandleWriteJSON(w http.ResponseWriter, r *http.Request) {
 	}
 
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
 		log.Printf("Invalid JSON: %v", err)
 		return
 	}
 
 	if req.DatasetName == "" {
-		http.Error(w, "Missing dataset_name", http.StatusBadRequest)
+		httpError(w, "Missing dataset_name", http.StatusBadRequest)
 		return
 	}
 
@@ -296,12 +414,132 @@ func HandleWriteJSON(w http.ResponseWriter, r *http.Request) {
 		req.Data = []map[string]interface{}{} // 允许空数组
 	}
 
-	if err := model.WriteJSON(req.DatasetName, req.Data); err != nil {
+	if err := model.WriteJSON(r.Context(), req.DatasetName, req.Data); err != nil {
 		log.Printf("WriteJSON failed: %v", err)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		writeError(r.Context(), w, err)
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
+	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(map[string]string{"message": "Data written successfully"})
 }
+
+// readContext 根据 consistency 参数决定查询读主库还是只读副本：
+//   - 默认（或 consistency=eventual）：允许读副本，可能读不到刚完成的写入
+//   - consistency=strong：强制读主库，用于写入之后立即读取
+//
+// 返回的 ctx 允许全分片读取在部分分片不可用时返回部分结果，缺失的分片记录在 Partial 中。
+// 参数无效时输出 400 并返回 false
+func readContext(w http.ResponseWriter, r *http.Request) (context.Context, *model.Partial, bool) {
+	var ctx context.Context
+	switch r.URL.Query().Get("consistency") {
+	case "", "eventual":
+		ctx = store.ReadFromReplica(r.Context())
+	case "strong":
+		ctx = store.ReadFromPrimary(r.Context())
+	default:
+		httpError(w, "consistency must be strong or eventual", http.StatusBadRequest)
+		return nil, nil, false
+	}
+	ctx, partial := model.AllowPartial(ctx)
+	return ctx, partial, true
+}
+
+// withDegraded 结果缺少部分分片时设置 X-Degraded、X-Missing-Shards 响应头，
+// 并在 body 中加上 "degraded": true 和 "missing_shards"。需要在写出状态码之前调用
+func withDegraded(w http.ResponseWriter, partial *model.Partial, body map[string]interface{}) map[string]interface{} {
+	if !partial.Degraded() {
+		return body
+	}
+	missing := partial.MissingShards()
+	w.Header().Set("X-Degraded", "true")
+	w.Header().Set("X-Missing-Shards", strings.Join(missing, ","))
+	if body != nil {
+		body["degraded"] = true
+		body["missing_shards"] = missing
+	}
+	return body
+}
+
+// servePagedDataset 处理整表读取的分页和流式参数：
+//   - format=ndjson：逐行扫描并输出 NDJSON，内存占用与表大小无关
+//   - limit（可选 after）：按主键分页，返回 {"result": [...], "next": "<游标>"}
+//
+// 请求中没有这些参数时返回 false，由调用方按原逻辑一次性返回整表
+func servePagedDataset(ctx context.Context, partial *model.Partial, w http.ResponseWriter, r *http.Request, datasetName string) bool {
+	query := r.URL.Query()
+	format := query.Get("format")
+	limitStr := query.Get("limit")
+	after := query.Get("after")
+
+	if format == "ndjson" {
+		streamNDJSON(ctx, partial, w, datasetName)
+		return true
+	}
+	if limitStr == "" && after == "" {
+		return false
+	}
+
+	limit := model.MaxPageSize
+	if limitStr != "" {
+		n, err := strconv.Atoi(limitStr)
+		if err != nil || n <= 0 || n > model.MaxPageSize {
+			httpError(w, fmt.Sprintf("limit must be an integer between 1 and %d", model.MaxPageSize), http.StatusBadRequest)
+			return true
+		}
+		limit = n
+	}
+
+	page, err := model.ReadPage(ctx, datasetName, after, limit)
+	if err != nil {
+		log.Printf("ReadPage failed: %v", err)
+		if errors.Is(err, model.ErrInvalidCursor) {
+			httpError(w, err.Error(), http.StatusBadRequest)
+			return true
+		}
+		writeQueryError(ctx, w, err)
+		return true
+	}
+
+	if partial.Degraded() {
+		page.Degraded = true
+		page.MissingShards = partial.MissingShards()
+		withDegraded(w, partial, nil)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+	return true
+}
+
+// streamNDJSON 以 NDJSON 流式输出整个数据集，每行一个 JSON 对象。
+// 已开始输出后出错时无法再修改状态码，最后一行输出 {"error": "..."}；
+// 跳过了不可用的分片时最后一行输出 {"degraded": true, "missing_shards": [...]}
+func streamNDJSON(ctx context.Context, partial *model.Partial, w http.ResponseWriter, datasetName string) {
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	enc := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+
+	n := 0
+	err := model.StreamDataset(ctx, datasetName, func(row map[string]interface{}) error {
+		if err := enc.Encode(row); err != nil {
+			return err
+		}
+		n++
+		if flusher != nil && n%500 == 0 {
+			flusher.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		log.Printf("StreamDataset failed after %d rows: %v", n, err)
+		if n == 0 {
+			writeQueryError(ctx, w, err)
+			return
+		}
+		enc.Encode(map[string]errorBody{"error": {Code: string(model.ErrorCode(err)), Message: err.Error()}})
+		return
+	}
+	if partial.Degraded() {
+		enc.Encode(map[string]interface{}{"degraded": true, "missing_shards": partial.MissingShards()})
+	}
+}
@@ -0,0 +1,23 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// StatusClientClosedRequest 客户端在收到响应之前断开（沿用 nginx 的 499）
+const StatusClientClosedRequest = 499
+
+// Deadline 按请求路径给 ctx 设置处理时限，到期或客户端断开时 model 层的查询随之取消。
+// limit 返回 0 表示不限制
+func Deadline(next http.Handler, limit func(path string) time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := limit(r.URL.Path); d > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
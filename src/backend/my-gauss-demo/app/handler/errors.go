@@ -0,0 +1,100 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"my-gauss-app/model"
+)
+
+// 只在 handler 层出现的错误类别，其余见 model.Code
+const (
+	codeMethodNotAllowed = "method_not_allowed"
+	codeCanceled         = "canceled"
+	codeTimeout          = "timeout"
+	codeShardFailed      = "shard_failed"
+)
+
+// errorBody 错误响应 {"error": {"code": "...", "message": "..."}}；
+// 多分片查询失败时 failed_shards 列出失败的分片
+type errorBody struct {
+	Code         string   `json:"code"`
+	Message      string   `json:"message"`
+	FailedShards []string `json:"failed_shards,omitempty"`
+}
+
+// writeJSONError 以统一的 JSON 格式输出错误
+func writeJSONError(w http.ResponseWriter, status int, body errorBody) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]errorBody{"error": body})
+}
+
+// httpError 替代 http.Error，用于请求本身的错误（缺少参数、JSON 无法解析、方法不对），
+// 错误类别由状态码决定
+func httpError(w http.ResponseWriter, message string, status int) {
+	code := string(model.CodeInternal)
+	switch status {
+	case http.StatusBadRequest:
+		code = string(model.CodeValidation)
+	case http.StatusNotFound:
+		code = string(model.CodeNotFound)
+	case http.StatusMethodNotAllowed:
+		code = codeMethodNotAllowed
+	}
+	writeJSONError(w, status, errorBody{Code: code, Message: message})
+}
+
+// errorStatus 返回错误对应的状态码：validation 为 400，not_found 为 404，conflict 为 409，
+// 客户端已断开为 499，超过处理时限为 504，shard_unavailable 为 503，其余为 500。
+// 查询被取消时驱动返回的是数据库的 query_canceled 错误，因此以请求 ctx 的状态为准
+func errorStatus(ctx context.Context, err error) int {
+	code := model.ErrorCode(err)
+	switch {
+	case code == model.CodeValidation:
+		return http.StatusBadRequest
+	case errors.Is(ctx.Err(), context.Canceled):
+		return StatusClientClosedRequest
+	case errors.Is(ctx.Err(), context.DeadlineExceeded), errors.Is(err, context.DeadlineExceeded):
+		return http.StatusGatewayTimeout
+	case code == model.CodeNotFound:
+		return http.StatusNotFound
+	case code == model.CodeConflict:
+		return http.StatusConflict
+	case code == model.CodeShardUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// writeError 按 errorStatus 输出错误
+func writeError(ctx context.Context, w http.ResponseWriter, err error) {
+	status := errorStatus(ctx, err)
+	body := errorBody{Code: string(model.ErrorCode(err)), Message: err.Error()}
+	switch status {
+	case StatusClientClosedRequest:
+		body.Code = codeCanceled
+	case http.StatusGatewayTimeout:
+		body.Code = codeTimeout
+	}
+	writeJSONError(w, status, body)
+}
+
+// writeQueryError 输出查询错误；多分片查询失败时在响应中列出失败的分片。
+// 失败的分片都处于熔断中时返回 503，否则多分片失败返回 502；
+// 请求被取消或超时、或有分片超过截止时间时按 errorStatus 返回 499/504
+func writeQueryError(ctx context.Context, w http.ResponseWriter, err error) {
+	var scatterErr *model.ScatterError
+	if errors.As(err, &scatterErr) && ctx.Err() == nil && !errors.Is(err, context.DeadlineExceeded) {
+		status, code := http.StatusServiceUnavailable, string(model.CodeShardUnavailable)
+		if model.ErrorCode(err) != model.CodeShardUnavailable {
+			status, code = http.StatusBadGateway, codeShardFailed
+		}
+		writeJSONError(w, status, errorBody{Code: code, Message: err.Error(), FailedShards: scatterErr.FailedShards()})
+		return
+	}
+	writeError(ctx, w, err)
+}
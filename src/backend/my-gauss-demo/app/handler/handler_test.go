@@ -0,0 +1,294 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"my-gauss-app/model"
+	"my-gauss-app/store/memory"
+)
+
+// useMemory 让 model 层使用一个空的内存后端
+func useMemory(t *testing.T) {
+	t.Helper()
+	s, err := memory.New([]string{"og1", "og2"}, 0, model.TableDefs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	model.Use(s)
+}
+
+// call 以 method 调用 h，body 非空时作为请求体，返回响应
+func call(h http.HandlerFunc, method, target, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h(w, req)
+	return w
+}
+
+// decode 解析 JSON 响应体
+func decode(t *testing.T, w *httptest.ResponseRecorder) map[string]interface{} {
+	t.Helper()
+	var body map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+		t.Fatalf("response %q is not a JSON object: %v", w.Body.String(), err)
+	}
+	return body
+}
+
+// wantError 检查响应的状态码和错误信封中的 code
+func wantError(t *testing.T, w *httptest.ResponseRecorder, status int, code string) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status = %d, want %d (body %s)", w.Code, status, w.Body.String())
+	}
+	errBody, ok := decode(t, w)["error"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("response %s has no error envelope", w.Body.String())
+	}
+	if errBody["code"] != code {
+		t.Errorf("error.code = %v, want %s", errBody["code"], code)
+	}
+	if msg, _ := errBody["message"].(string); msg == "" {
+		t.Error("error.message is empty")
+	}
+}
+
+func readURL(params url.Values) string {
+	return "/api/dataset/read?" + params.Encode()
+}
+
+func TestUsersAndRooms(t *testing.T) {
+	useMemory(t)
+
+	w := call(HandleUsers, http.MethodPost, "/api/users", `[{"id": "u1", "user_name": "alice"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/users: %d %s", w.Code, w.Body.String())
+	}
+	w = call(HandleUsers, http.MethodPost, "/api/users", `[{"id": "u1"}]`)
+	wantError(t, w, http.StatusConflict, string(model.CodeConflict))
+
+	room := `{"room_id": "r1", "room_name": "demo", "owner_user_id": "u1", "permission": 3, "content": "hello"}`
+	w = call(HandleRooms, http.MethodPost, "/api/rooms", room)
+	if w.Code != http.StatusCreated {
+		t.Fatalf("POST /api/rooms: %d %s", w.Code, w.Body.String())
+	}
+	w = call(HandleRooms, http.MethodPost, "/api/rooms", `{"room_id": "r2", "owner_user_id": "nobody"}`)
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+
+	w = call(HandleUserRooms, http.MethodGet, "/api/users/rooms?user_id=u1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("GET /api/users/rooms: %d %s", w.Code, w.Body.String())
+	}
+	rooms, _ := decode(t, w)["result"].([]interface{})
+	if len(rooms) != 1 {
+		t.Errorf("user rooms = %v, want r1", rooms)
+	}
+
+	w = call(HandleReadDataset, http.MethodGet, readURL(url.Values{"dataset_name": {"content"}, "main_key": {"r1"}, "goal_key": {"content"}}), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("read content: %d %s", w.Code, w.Body.String())
+	}
+	if got := decode(t, w)["result"]; got != "hello" {
+		t.Errorf("content = %v, want hello", got)
+	}
+
+	w = call(HandleRooms, http.MethodDelete, "/api/rooms?room_id=r1", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("DELETE /api/rooms: %d %s", w.Code, w.Body.String())
+	}
+	w = call(HandleRooms, http.MethodDelete, "/api/rooms?room_id=r1", "")
+	wantError(t, w, http.StatusNotFound, string(model.CodeNotFound))
+}
+
+func TestReadDatasetErrors(t *testing.T) {
+	useMemory(t)
+
+	w := call(HandleReadDataset, http.MethodGet, readURL(url.Values{"dataset_name": {"user"}, "main_key": {"nobody"}}), "")
+	wantError(t, w, http.StatusNotFound, string(model.CodeNotFound))
+
+	w = call(HandleReadDataset, http.MethodGet, readURL(url.Values{"dataset_name": {"no_such_dataset"}, "main_key": {"x"}}), "")
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+
+	w = call(HandleReadDataset, http.MethodGet, readURL(url.Values{"dataset_name": {"user"}}), "")
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+
+	w = call(HandleReadDataset, http.MethodGet, readURL(url.Values{"dataset_name": {"user"}, "main_key": {"*"}, "after": {"!!!"}}), "")
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+
+	w = call(HandleReadDatasetCondition, http.MethodGet, "/api/dataset/read_condition?"+url.Values{"dataset_name": {"user"}, "filter": {`{"column": "id", "op": "~"}`}}.Encode(), "")
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+}
+
+func TestReadConditionReturnsAllMatches(t *testing.T) {
+	useMemory(t)
+	w := call(HandleUsers, http.MethodPost, "/api/users", `[{"id": "u1", "user_name": "alice"}, {"id": "u2", "user_name": "alice"}]`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("POST /api/users: %d %s", w.Code, w.Body.String())
+	}
+
+	// 没有其他参数时同样返回所有匹配的行
+	params := url.Values{"dataset_name": {"user"}, "key_name": {"user_name"}, "key_value": {"alice"}}
+	w = call(HandleReadDatasetCondition, http.MethodGet, "/api/dataset/read_condition?"+params.Encode(), "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("read_condition: %d %s", w.Code, w.Body.String())
+	}
+	rows, _ := decode(t, w)["result"].([]interface{})
+	if len(rows) != 2 {
+		t.Errorf("read_condition result = %s, want both users", w.Body.String())
+	}
+
+	params.Set("goal_key", "id")
+	params.Set("single", "true")
+	w = call(HandleReadDatasetCondition, http.MethodGet, "/api/dataset/read_condition?"+params.Encode(), "")
+	if got := decode(t, w)["result"]; got != "u1" {
+		t.Errorf("single read_condition result = %v, want u1", got)
+	}
+
+	params.Set("key_value", "nobody")
+	w = call(HandleReadDatasetCondition, http.MethodGet, "/api/dataset/read_condition?"+params.Encode(), "")
+	wantError(t, w, http.StatusNotFound, string(model.CodeNotFound))
+}
+
+func TestMethodNotAllowed(t *testing.T) {
+	useMemory(t)
+
+	handlers := map[string]http.HandlerFunc{
+		"users":      HandleUsers,
+		"rooms":      HandleRooms,
+		"read":       HandleReadDataset,
+		"insert":     HandleInsertDataIntoDataset,
+		"upsert":     HandleUpsertDataset,
+		"modify":     HandleModifyDatasetCondition,
+		"remove":     HandleRemoveDatasetMainKey,
+		"write_json": HandleWriteJSON,
+		"health":     HandleHealth,
+	}
+	for name, h := range handlers {
+		t.Run(name, func(t *testing.T) {
+			wantError(t, call(h, http.MethodPut, "/", ""), http.StatusMethodNotAllowed, codeMethodNotAllowed)
+		})
+	}
+}
+
+func TestDatasetWrites(t *testing.T) {
+	useMemory(t)
+
+	w := call(HandleInsertDataIntoDataset, http.MethodPost, "/api/dataset/insert", `{"dataset_name": "user", "data": {"id": "u1"}}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("insert: %d %s", w.Code, w.Body.String())
+	}
+	w = call(HandleInsertDataIntoDataset, http.MethodPost, "/api/dataset/insert", `{"dataset_name": "user", "data": {"id": "u2", "nickname": "x"}}`)
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+	w = call(HandleInsertDataIntoDataset, http.MethodPost, "/api/dataset/insert", `{`)
+	wantError(t, w, http.StatusBadRequest, string(model.CodeValidation))
+
+	for _, want := range []string{"inserted", "updated"} {
+		w = call(HandleUpsertDataset, http.MethodPost, "/api/dataset/upsert", `{"dataset_name": "user", "data": {"id": "u3", "email": "c@example.com"}}`)
+		if w.Code != http.StatusOK {
+			t.Fatalf("upsert: %d %s", w.Code, w.Body.String())
+		}
+		if got := decode(t, w)["result"]; got != want {
+			t.Errorf("upsert result = %v, want %s", got, want)
+		}
+	}
+	w = call(HandleUpsertDataset, http.MethodPost, "/api/dataset/upsert", `{"dataset_name": "user", "data": {"id": "u3", "email": "d@example.com"}, "update_columns": []}`)
+	if got := decode(t, w)["result"]; w.Code != http.StatusOK || got != "unchanged" {
+		t.Errorf("upsert without update columns = %d %v, want unchanged", w.Code, got)
+	}
+
+	w = call(HandleModifyDatasetCondition, http.MethodPost, "/api/dataset/modify", `{"dataset_name": "user", "key_name": "id", "key_value": "nobody", "goal_key": "email", "goal_value": "x"}`)
+	wantError(t, w, http.StatusNotFound, string(model.CodeNotFound))
+
+	w = call(HandleRemoveDatasetMainKey, http.MethodPost, "/api/dataset/remove", `{"dataset_name": "user", "main_key": "id", "main_value": "u1"}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("remove: %d %s", w.Code, w.Body.String())
+	}
+	w = call(HandleRemoveDatasetMainKey, http.MethodPost, "/api/dataset/remove", `{"dataset_name": "user", "main_key": "id", "main_value": "u1"}`)
+	wantError(t, w, http.StatusNotFound, string(model.CodeNotFound))
+
+	w = call(HandleReadJSON, http.MethodGet, "/api/dataset/read_json?dataset_name=user", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("read_json: %d %s", w.Code, w.Body.String())
+	}
+	var users []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &users); err != nil {
+		t.Fatal(err)
+	}
+	if len(users) != 1 || users[0]["id"] != "u3" {
+		t.Errorf("users = %v, want only u3", users)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	useMemory(t)
+
+	w := call(HandleHealth, http.MethodGet, "/api/health", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("health: %d %s", w.Code, w.Body.String())
+	}
+	body := decode(t, w)
+	if body["healthy"] != true {
+		t.Errorf("healthy = %v, want true", body["healthy"])
+	}
+	if shards, _ := body["shards"].([]interface{}); len(shards) != 2 {
+		t.Errorf("shards = %v, want og1 and og2", body["shards"])
+	}
+}
+
+func TestWriteResponsesAreJSON(t *testing.T) {
+	useMemory(t)
+
+	writes := []struct {
+		name string
+		h    http.HandlerFunc
+		body string
+	}{
+		{"users", HandleUsers, `[{"id": "u1"}]`},
+		{"insert", HandleInsertDataIntoDataset, `{"dataset_name": "user", "data": {"id": "u2"}}`},
+		{"modify", HandleModifyDatasetCondition, `{"dataset_name": "user", "key_name": "id", "key_value": "u2", "goal_key": "email", "goal_value": "b@example.com"}`},
+		{"write_json", HandleWriteJSON, `{"dataset_name": "user", "data": [{"id": "u3"}]}`},
+	}
+	for _, c := range writes {
+		w := call(c.h, http.MethodPost, "/", c.body)
+		if w.Code != http.StatusOK {
+			t.Fatalf("%s: %d %s", c.name, w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+			t.Errorf("%s: Content-Type = %q, want application/json", c.name, ct)
+		}
+		if msg, _ := decode(t, w)["message"].(string); msg == "" {
+			t.Errorf("%s: response %s has no message", c.name, w.Body.String())
+		}
+	}
+}
+
+func TestWriteErrorStatus(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   model.Code
+	}{
+		{model.ShardUnavailable("og2 is down"), http.StatusServiceUnavailable, model.CodeShardUnavailable},
+		{model.Internal("broken"), http.StatusInternalServerError, model.CodeInternal},
+		{model.NotFound("gone"), http.StatusNotFound, model.CodeNotFound},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		writeError(httptest.NewRequest(http.MethodGet, "/", nil).Context(), w, c.err)
+		wantError(t, w, c.status, string(c.code))
+	}
+}
+
+func TestScatterTimeoutIsGatewayTimeout(t *testing.T) {
+	// 分片没有熔断、只是超过了截止时间：不是 shard_failed 的 502，也不是降级的部分结果
+	err := &model.ScatterError{Errors: []*model.ShardError{{Shard: "og2", Table: "document_1", Err: context.DeadlineExceeded}}}
+	w := httptest.NewRecorder()
+	writeQueryError(httptest.NewRequest(http.MethodGet, "/", nil).Context(), w, err)
+	wantError(t, w, http.StatusGatewayTimeout, codeTimeout)
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"my-gauss-app/model"
+)
+
+// HandleHealth 返回每个分片的健康状态，全部健康时为 200，否则为 503
+// GET /api/health
+func HandleHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	shards := model.Health(r.Context())
+	status := http.StatusOK
+	for _, s := range shards {
+		if !s.Healthy {
+			status = http.StatusServiceUnavailable
+			break
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"healthy": status == http.StatusOK,
+		"shards":  shards,
+	})
+}
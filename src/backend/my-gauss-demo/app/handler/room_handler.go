@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"my-gauss-app/model"
+)
+
+// HandleRooms 房间接口
+// POST /api/rooms
+// Body: {"room_id": "123456", "room_name": "test", "create_time": "2024-01-01 12:00:00", "overall_permission": 0, "owner_user_id": "654321", "permission": 3, "content": ""}
+// DELETE /api/rooms?room_id=123456
+func HandleRooms(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPost:
+		handleCreateRoom(w, r)
+	case http.MethodDelete:
+		handleDeleteRoom(w, r)
+	default:
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleCreateRoom 原子地创建房间（document + owner permission + content）
+func handleCreateRoom(w http.ResponseWriter, r *http.Request) {
+	var req model.Room
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
+		log.Printf("Invalid JSON: %v", err)
+		return
+	}
+
+	if req.RoomID == "" || req.OwnerUserID == "" {
+		httpError(w, "Missing required parameters: room_id, owner_user_id", http.StatusBadRequest)
+		return
+	}
+
+	room, err := model.CreateRoom(r.Context(), req)
+	if err != nil {
+		log.Printf("CreateRoom failed: %v", err)
+		writeError(r.Context(), w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": room, "message": "Room created successfully"})
+}
+
+// handleDeleteRoom 在一个事务内删除房间在所有房间表中的行
+func handleDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	roomID := r.URL.Query().Get("room_id")
+	if roomID == "" {
+		httpError(w, "Missing required parameter: room_id", http.StatusBadRequest)
+		return
+	}
+
+	counts, err := model.DeleteRoom(r.Context(), roomID)
+	if err != nil {
+		log.Printf("DeleteRoom failed: %v", err)
+		writeError(r.Context(), w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"success": true,
+		"msg":     "删除成功",
+		"deleted": counts,
+	})
+}
@@ -0,0 +1,16 @@
+package handler
+
+import (
+	"net/http"
+
+	"my-gauss-app/model"
+)
+
+// Track 登记进行中的请求并固定它使用的后端：热加载换下的连接池在这些请求全部结束后才关闭
+func Track(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx, leave := model.Enter(r.Context())
+		defer leave()
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
@@ -11,41 +11,71 @@ import (
 // HandleUsers POST: 插入用户
 func HandleUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
 	var users []model.User
 	if err := json.NewDecoder(r.Body).Decode(&users); err != nil {
-		http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		httpError(w, "Invalid JSON", http.StatusBadRequest)
 		log.Printf("Invalid JSON")
 		return
 	}
 
 	for _, u := range users {
-		if err := model.InsertUser(u); err != nil {
+		if err := model.InsertUser(r.Context(), u); err != nil {
 			log.Printf("Insert user %v failed: %v", u, err)
-			http.Error(w, "Insert failed", http.StatusInternalServerError)
+			writeError(r.Context(), w, err)
 			return
 		}
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Write([]byte("Users inserted successfully"))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"inserted": len(users), "message": "Users inserted successfully"})
 }
 
 // HandleQueryUsers GET: 查询所有用户
 func HandleQueryUsers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	users, err := model.QueryAllUsers()
+	users, err := model.QueryAllUsers(r.Context())
 	if err != nil {
-		http.Error(w, "Query failed", http.StatusInternalServerError)
+		writeError(r.Context(), w, err)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(users)
 }
+
+// HandleUserRooms GET: 查询用户可访问的房间（只查 user_rooms 索引）
+// GET /api/users/rooms?user_id=654321
+func HandleUserRooms(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		httpError(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.URL.Query().Get("user_id")
+	if userID == "" {
+		httpError(w, "Missing required parameter: user_id", http.StatusBadRequest)
+		return
+	}
+
+	ctx, _, ok := readContext(w, r)
+	if !ok {
+		return
+	}
+
+	rooms, err := model.UserRooms(ctx, userID)
+	if err != nil {
+		log.Printf("UserRooms failed: %v", err)
+		writeError(ctx, w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"result": rooms})
+}
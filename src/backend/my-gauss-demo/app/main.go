@@ -1,34 +1,297 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	_ "expvar"
+	"flag"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strings"
 
+	"my-gauss-app/config"
 	"my-gauss-app/db"
 	"my-gauss-app/handler"
+	"my-gauss-app/model"
+	"my-gauss-app/store"
+	"my-gauss-app/store/memory"
 )
 
 func main() {
-	db.InitDB()
-	defer db.DBOg1.Close()
-	defer db.DBOg2.Close()
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Load config failed: %v", err)
+	}
 
-	db.InitTables()
+	// 迁移子命令只操作 openGauss 表结构，不启动服务
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		runMigrate(cfg, os.Args[2:])
+		return
+	}
+
+	// 配置中声明的数据集须在建表和注册路由之前登记
+	if err := model.RegisterDatasets(cfg.Datasets); err != nil {
+		log.Fatalf("Invalid datasets config: %v", err)
+	}
+
+	s := openStore(cfg)
+
+	model.Use(s)
+	model.ScatterTimeout = cfg.Timeouts.Scatter.Std()
+
+	// 子命令：go run . rebalance [-dry-run] / move-room -room <id> -to <shard> / reindex
+	//        go run . check [--repair] [--report <file>]
+	//        go run . migrate status / up [-to N] / down -to N
+	if len(os.Args) > 1 {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
+	// 只由服务进程处理遗留的 prepared transaction，子命令运行时服务可能正在提交。
+	// 启动时还太新的事务留给之后定期的处理
+	store.RecoverPrepared(context.Background(), s)
+	go model.ResolvePrepared(context.Background(), store.ResolveInterval)
+
+	// 引入房间目录之前写入的房间按数据所在分片登记，之后才能按目录找到它们
+	n, err := model.BackfillDirectory(context.Background())
+	if err != nil {
+		log.Printf("Backfill room_directory incomplete, restart once all shards are available: %v", err)
+	}
+	if n > 0 {
+		log.Printf("Backfill room_directory: registered %d room(s)", n)
+	}
 
 	// 原有的用户 API
 	http.HandleFunc("/users", handler.HandleUsers)
 	http.HandleFunc("/users/query", handler.HandleQueryUsers)
+	http.HandleFunc("/api/users/rooms", handler.HandleUserRooms)
 
 	// 对应 Python 的函数）
 	http.HandleFunc("/api/dataset/read", handler.HandleReadDataset)
 	http.HandleFunc("/api/dataset/read_condition", handler.HandleReadDatasetCondition)
 	http.HandleFunc("/api/dataset/insert", handler.HandleInsertDataIntoDataset)
+	http.HandleFunc("/api/dataset/upsert", handler.HandleUpsertDataset)
 	http.HandleFunc("/api/dataset/modify", handler.HandleModifyDatasetCondition)
 	http.HandleFunc("/api/dataset/read_json", handler.HandleReadJSON)
 	http.HandleFunc("/api/dataset/write_json", handler.HandleWriteJSON)
 	http.HandleFunc("/api/dataset/remove", handler.HandleRemoveDatasetMainKey)
 
-	fmt.Println("Server started at :8080")
-	log.Fatal(http.ListenAndServe(":8080", nil))
+	// 房间接口：一次事务写入/删除 document、permission、content
+	http.HandleFunc("/api/rooms", handler.HandleRooms)
+
+	// 分片健康状态：连不上的分片被熔断，恢复后自动重新启用
+	http.HandleFunc("/api/health", handler.HandleHealth)
+	// 重试次数等计数器由 expvar 发布在 /debug/vars
+
+	// openGauss 后端支持热加载分片和连接池配置（SIGHUP 或修改配置文件）
+	if gs, ok := s.(*db.Store); ok {
+		r := &reloader{cfg: cfg, store: gs}
+		go r.watch()
+	}
+
+	server := &http.Server{
+		Addr:         cfg.Listen,
+		Handler:      handler.Track(handler.Deadline(http.DefaultServeMux, cfg.Timeouts.For)),
+		ReadTimeout:  cfg.Timeouts.Read.Std(),
+		WriteTimeout: cfg.Timeouts.Write.Std(),
+		IdleTimeout:  cfg.Timeouts.Idle.Std(),
+	}
+	fmt.Printf("Server started at %s\n", cfg.Listen)
+	log.Fatal(server.ListenAndServe())
+}
+
+// openStore 按配置中的 store 选择存储后端：memory 为进程内存储（数据不落盘），
+// 默认连接 openGauss 分片并把表结构迁移到最新版本
+func openStore(cfg *config.Config) store.Store {
+	if cfg.Store == "memory" {
+		s, err := memory.New(cfg.ShardNames(), 0, model.TableDefs())
+		if err != nil {
+			log.Fatalf("Init memory store failed: %v", err)
+		}
+		log.Println("Using in-memory store")
+		return s
+	}
+
+	s, err := db.Open(cfg, model.TableDefs())
+	if err != nil {
+		log.Fatalf("Open database failed: %v", err)
+	}
+	if err := s.MigrateAvailable(context.Background()); err != nil {
+		log.Fatalf("Migrate failed: %v", err)
+	}
+	return s
+}
+
+// runCommand 执行运维子命令
+func runCommand(name string, args []string) {
+	switch name {
+	case "rebalance":
+		fs := flag.NewFlagSet("rebalance", flag.ExitOnError)
+		dryRun := fs.Bool("dry-run", false, "only print the rooms that would move")
+		fs.Parse(args)
+
+		report, err := model.Rebalance(context.Background(), *dryRun)
+		if err != nil {
+			log.Fatalf("Rebalance failed: %v", err)
+		}
+		fmt.Printf("Rebalance finished: planned=%d moved=%d failed=%d rows=%v\n",
+			report.Planned, report.Moved, report.Failed, report.Rows)
+		if len(report.Repair) > 0 {
+			fmt.Printf("Rooms with rows on more than one shard, repair manually: %s\n", strings.Join(report.Repair, ", "))
+		}
+		if report.Failed > 0 {
+			os.Exit(1)
+		}
+
+	case "reindex":
+		n, err := model.RebuildUserRooms(context.Background())
+		if err != nil {
+			log.Fatalf("Rebuild user_rooms failed: %v", err)
+		}
+		fmt.Printf("Rebuilt user_rooms from %d permission row(s)\n", n)
+
+	case "check":
+		fs := flag.NewFlagSet("check", flag.ExitOnError)
+		repair := fs.Bool("repair", false, "fix what is safely fixable")
+		reportPath := fs.String("report", "", "where to write the JSON report (default check-report-<time>.json)")
+		fs.Parse(args)
+		runCheck(*repair, *reportPath)
+
+	case "move-room":
+		fs := flag.NewFlagSet("move-room", flag.ExitOnError)
+		roomID := fs.String("room", "", "room_id to move")
+		to := fs.String("to", "", "target shard name, e.g. og2")
+		fs.Parse(args)
+		if *roomID == "" || *to == "" {
+			log.Fatalf("move-room requires -room and -to")
+		}
+
+		counts, err := model.MoveRoom(context.Background(), *roomID, *to)
+		if err != nil {
+			log.Fatalf("Move room %s failed: %v", *roomID, err)
+		}
+		fmt.Printf("Room %s pinned to %s, rows moved: %v\n", *roomID, *to, counts)
+
+	default:
+		log.Fatalf("Unknown command: %s", name)
+	}
+}
+
+// runCheck 执行 check 子命令：输出问题汇总，把完整报告（含修复时改动的行）写入 JSON 文件。
+// 有未修复的问题时以状态码 1 退出
+func runCheck(repair bool, reportPath string) {
+	report, err := model.Check(context.Background(), repair)
+	if report == nil {
+		log.Fatalf("Check failed: %v", err)
+	}
+	if err != nil {
+		log.Printf("Check stopped early: %v", err)
+	}
+
+	if reportPath == "" {
+		reportPath = fmt.Sprintf("check-report-%s.json", report.StartedAt.Format("20060102-150405"))
+	}
+	data, jerr := json.MarshalIndent(report, "", "  ")
+	if jerr != nil {
+		log.Fatalf("Encode report failed: %v", jerr)
+	}
+	if werr := os.WriteFile(reportPath, data, 0o644); werr != nil {
+		log.Fatalf("Write report failed: %v", werr)
+	}
+
+	counts := make(map[string][2]int)
+	var kinds []string
+	for _, is := range report.Issues {
+		c, ok := counts[is.Kind]
+		if !ok {
+			kinds = append(kinds, is.Kind)
+		}
+		c[0]++
+		if is.Repaired {
+			c[1]++
+		}
+		counts[is.Kind] = c
+	}
+	fmt.Printf("Check finished: %d issue(s), %d change(s), report written to %s\n", len(report.Issues), len(report.Changes), reportPath)
+	for _, kind := range kinds {
+		fmt.Printf("  %-20s %d found, %d repaired\n", kind, counts[kind][0], counts[kind][1])
+	}
+	if err != nil || report.Unrepaired() > 0 {
+		os.Exit(1)
+	}
+}
+
+// runMigrate 执行 migrate 子命令：
+//
+//	migrate status       显示每个分片的版本，分片之间不一致时以状态码 1 退出
+//	migrate up [-to N]   迁移到版本 N，默认最新
+//	migrate down -to N   回滚到版本 N（0 表示回滚全部）
+func runMigrate(cfg *config.Config, args []string) {
+	if len(args) == 0 {
+		log.Fatalf("migrate requires a subcommand: status, up or down")
+	}
+	if cfg.Store != "gauss" {
+		log.Fatalf("migrate only applies to the gauss store")
+	}
+	// 与服务启动时相同：migrate up 同时创建配置中声明的数据集表
+	if err := model.RegisterDatasets(cfg.Datasets); err != nil {
+		log.Fatalf("Invalid datasets config: %v", err)
+	}
+
+	s, err := db.Open(cfg, model.TableDefs())
+	if err != nil {
+		log.Fatalf("Open database failed: %v", err)
+	}
+	defer s.Close()
+	ctx := context.Background()
+
+	switch args[0] {
+	case "status":
+		statuses, err := s.MigrationStatus(ctx)
+		if err != nil {
+			log.Fatalf("Read migration status failed: %v", err)
+		}
+		fmt.Printf("latest version: %d\n", db.LatestVersion())
+		for _, st := range statuses {
+			fmt.Printf("%s: version=%d pending=%v unknown=%v\n", st.Shard, st.Version, st.Pending, st.Unknown)
+		}
+		if drift := db.Drift(statuses); len(drift) > 0 {
+			for _, d := range drift {
+				fmt.Printf("DRIFT: %s\n", d)
+			}
+			os.Exit(1)
+		}
+
+	case "up", "down":
+		fs := flag.NewFlagSet("migrate "+args[0], flag.ExitOnError)
+		to := fs.Int("to", -1, "target version")
+		fs.Parse(args[1:])
+		if *to < 0 {
+			if args[0] == "down" {
+				log.Fatalf("migrate down requires -to")
+			}
+			*to = db.LatestVersion()
+		}
+		if args[0] == "up" {
+			statuses, err := s.MigrationStatus(ctx)
+			if err != nil {
+				log.Fatalf("Read migration status failed: %v", err)
+			}
+			for _, st := range statuses {
+				if st.Version > *to {
+					log.Fatalf("%s is already at version %d, use migrate down to roll back", st.Shard, st.Version)
+				}
+			}
+		}
+
+		if err := s.Migrate(ctx, *to); err != nil {
+			log.Fatalf("Migrate failed: %v", err)
+		}
+		fmt.Printf("Migrated all shards to version %d\n", *to)
+
+	default:
+		log.Fatalf("Unknown migrate subcommand: %s", args[0])
+	}
 }
@@ -0,0 +1,449 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// 一致性检查发现的问题类型
+const (
+	IssueWrongShard       = "wrong_shard"        // 房间数据不在目录（没有记录时按哈希环）指定的分片上
+	IssueDuplicateRoom    = "duplicate_room"     // 同一个 room_id 的 document 出现在多个分片上
+	IssueOrphanPermission = "orphan_permission"  // permission 行所在分片上没有该房间的 document
+	IssueOrphanContent    = "orphan_content"     // content 行所在分片上没有该房间的 document
+	IssueOrphanRows       = "orphan_rows"        // 配置中按 room_id 分片的数据集的行所在分片上没有该房间的 document
+	IssueMissingContent   = "missing_content"    // document 没有对应的 content 行
+	IssueMissingOwner     = "missing_owner"      // owner_user_id 不在 "user" 表中
+	IssueUserRoomsMissing = "user_rooms_missing" // permission 行在 user_rooms 中没有记录或权限不同
+	IssueUserRoomsStale   = "user_rooms_stale"   // user_rooms 中的记录没有对应的 permission 行
+)
+
+// CheckIssue 一致性检查发现的一个问题
+type CheckIssue struct {
+	Kind     string `json:"kind"`
+	RoomID   string `json:"room_id,omitempty"`
+	Shard    string `json:"shard,omitempty"`
+	Detail   string `json:"detail"`
+	Repaired bool   `json:"repaired"`
+}
+
+// CheckChange 修复时做出的一项修改；删除的行原样记录在 Rows 中，需要时可据此恢复
+type CheckChange struct {
+	Kind   string      `json:"kind"`
+	RoomID string      `json:"room_id,omitempty"`
+	Shard  string      `json:"shard,omitempty"`
+	Action string      `json:"action"`
+	Rows   []store.Row `json:"rows,omitempty"`
+}
+
+// CheckReport 一次一致性检查的结果
+type CheckReport struct {
+	StartedAt  time.Time      `json:"started_at"`
+	FinishedAt time.Time      `json:"finished_at"`
+	Repair     bool           `json:"repair"`
+	Issues     []*CheckIssue  `json:"issues"`
+	Changes    []*CheckChange `json:"changes"`
+}
+
+// Unrepaired 返回未修复的问题数
+func (r *CheckReport) Unrepaired() int {
+	n := 0
+	for _, is := range r.Issues {
+		if !is.Repaired {
+			n++
+		}
+	}
+	return n
+}
+
+func (r *CheckReport) add(kind, roomID, shard, detail string) *CheckIssue {
+	is := &CheckIssue{Kind: kind, RoomID: roomID, Shard: shard, Detail: detail}
+	r.Issues = append(r.Issues, is)
+	return is
+}
+
+func (r *CheckReport) changed(kind, roomID, shard, action string, rows []store.Row) {
+	r.Changes = append(r.Changes, &CheckChange{Kind: kind, RoomID: roomID, Shard: shard, Action: action, Rows: rows})
+}
+
+// shardData 一个分片上房间相关的数据，只包含检查用到的列（见 checkColumns）
+type shardData struct {
+	shard store.Shard
+	docs  map[string]store.Row              // room_id -> document 行
+	rows  map[string]map[string][]store.Row // 其他房间表 -> room_id -> 行
+}
+
+// Check 扫描所有分片，检查房间数据和 user_rooms 索引的一致性。
+// repair 为 true 时修复可以安全修复的问题：
+//   - wrong_shard：按重平衡的方式把房间迁移到目录指定的分片（room_id 在多个分片上重复或正在迁移时不处理）
+//   - missing_content：补一条空的 content 行
+//   - orphan_permission / orphan_content / orphan_rows：房间在任何分片上都没有 document 时删除这些行
+//   - user_rooms_missing / user_rooms_stale：逐条在事务中重新读取 permission 行并更新或删除对应的 user_rooms 记录
+//
+// duplicate_room 和 missing_owner 需要人工判断，只报告不修复
+func Check(ctx context.Context, repair bool) (*CheckReport, error) {
+	report := &CheckReport{StartedAt: time.Now(), Repair: repair}
+
+	users := make(map[string]bool)
+	err := userShard(ctx).Scan(ctx, store.Query{Table: userTable.base, Columns: []string{"id"}}, func(row store.Row) error {
+		users[fmt.Sprint(row["id"])] = true
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("scan user failed: %w", err)
+	}
+
+	var data []*shardData
+	docShards := make(map[string][]string) // room_id -> 有 document 的分片
+	for _, s := range backend(ctx).Shards() {
+		d, err := loadShardData(ctx, s)
+		if err != nil {
+			return nil, err
+		}
+		data = append(data, d)
+		for roomID := range d.docs {
+			docShards[roomID] = append(docShards[roomID], s.Name())
+		}
+	}
+
+	for _, roomID := range sortedKeys(docShards) {
+		if shards := docShards[roomID]; len(shards) > 1 {
+			report.add(IssueDuplicateRoom, roomID, "", "document exists on "+strings.Join(shards, ", "))
+		}
+	}
+
+	for _, d := range data {
+		if err := checkShardData(ctx, report, d, users, docShards, repair); err != nil {
+			return report, err
+		}
+	}
+
+	for _, d := range data {
+		if err := checkPlacement(ctx, report, d, docShards, repair); err != nil {
+			return report, err
+		}
+	}
+
+	if err := checkUserRooms(ctx, report, data, repair); err != nil {
+		return report, err
+	}
+
+	report.FinishedAt = time.Now()
+	return report, nil
+}
+
+// checkColumns 检查时读取的列：document 的 owner_user_id、permission 的权限值，其余表只读 room_id 和主键
+func checkColumns(t tableSpec) []string {
+	switch t.base {
+	case "document":
+		return []string{"room_id", "owner_user_id"}
+	case "permission":
+		return []string{"room_id", "user_id", "permission"}
+	}
+	cols := []string{"room_id"}
+	for _, col := range t.pk {
+		if col != "room_id" {
+			cols = append(cols, col)
+		}
+	}
+	return cols
+}
+
+// loadShardData 读取分片上的所有房间表（roomTables）中检查用到的列
+func loadShardData(ctx context.Context, s store.Shard) (*shardData, error) {
+	d := &shardData{shard: s, docs: make(map[string]store.Row), rows: make(map[string]map[string][]store.Row)}
+	for _, t := range roomTables {
+		byRoom := make(map[string][]store.Row)
+		if t.base != "document" {
+			d.rows[t.base] = byRoom
+		}
+		q := store.Query{Table: s.Table(t.base), Columns: checkColumns(t)}
+		err := s.Scan(ctx, q, func(row store.Row) error {
+			roomID := fmt.Sprint(row["room_id"])
+			if t.base == "document" {
+				d.docs[roomID] = row
+			} else {
+				byRoom[roomID] = append(byRoom[roomID], row)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("scan %s on %s failed: %w", s.Table(t.base), s.Name(), err)
+		}
+	}
+	return d, nil
+}
+
+// checkShardData 检查一个分片上的缺失 content、缺失 owner 和没有 document 的房间表行
+func checkShardData(ctx context.Context, report *CheckReport, d *shardData, users map[string]bool, docShards map[string][]string, repair bool) error {
+	s := d.shard
+	for _, roomID := range sortedKeys(d.docs) {
+		doc := d.docs[roomID]
+		if owner := fmt.Sprint(doc["owner_user_id"]); doc["owner_user_id"] == nil || !users[owner] {
+			report.add(IssueMissingOwner, roomID, s.Name(), fmt.Sprintf("owner_user_id %q not found in user", owner))
+		}
+
+		if len(d.rows["content"][roomID]) > 0 {
+			continue
+		}
+		is := report.add(IssueMissingContent, roomID, s.Name(), "document has no content row")
+		if !repair {
+			continue
+		}
+		row := store.Row{"room_id": roomID, "content": ""}
+		if err := s.Insert(ctx, s.Table("content"), row); err != nil {
+			return fmt.Errorf("repair content of room %s on %s failed: %w", roomID, s.Name(), err)
+		}
+		is.Repaired = true
+		d.rows["content"][roomID] = []store.Row{row}
+		report.changed(IssueMissingContent, roomID, s.Name(), "inserted empty content row", []store.Row{row})
+	}
+
+	orphans := make(map[string]bool)
+	for _, byRoom := range d.rows {
+		for roomID := range byRoom {
+			if d.docs[roomID] == nil {
+				orphans[roomID] = true
+			}
+		}
+	}
+
+	for _, roomID := range sortedKeys(orphans) {
+		var issues []*CheckIssue
+		detail := "no document row on this shard"
+		if elsewhere := docShards[roomID]; len(elsewhere) > 0 {
+			detail = "document is on " + strings.Join(elsewhere, ", ")
+		}
+		for _, t := range roomTables {
+			rows := d.rows[t.base][roomID]
+			if len(rows) == 0 {
+				continue
+			}
+			kind := orphanIssue(t.base)
+			msg := fmt.Sprintf("%d row(s), %s", len(rows), detail)
+			if kind == IssueOrphanRows {
+				msg = t.base + ": " + msg
+			}
+			issues = append(issues, report.add(kind, roomID, s.Name(), msg))
+		}
+
+		// 房间的 document 在别的分片上时，这些行可能是迁移中断留下的，交给人工处理
+		if !repair || len(docShards[roomID]) > 0 {
+			continue
+		}
+		if err := deleteOrphans(ctx, report, d, roomID); err != nil {
+			return err
+		}
+		for _, is := range issues {
+			is.Repaired = true
+		}
+	}
+	return nil
+}
+
+// orphanIssue 房间表 base 中没有 document 的行对应的问题类型
+func orphanIssue(base string) string {
+	switch base {
+	case "permission":
+		return IssueOrphanPermission
+	case "content":
+		return IssueOrphanContent
+	}
+	return IssueOrphanRows
+}
+
+// deleteOrphans 在一个事务中删除分片上没有 document 的房间在其他房间表中的行及其 user_rooms 记录，
+// 删除的行在事务内读出并记入 report
+func deleteOrphans(ctx context.Context, report *CheckReport, d *shardData, roomID string) error {
+	s := d.shard
+	byRoom := []store.Cond{{Column: "room_id", Value: roomID}}
+	deleted := make(map[string][]store.Row)
+	err := withUserRooms(ctx, s, func(tx, index store.Tx) error {
+		for _, t := range roomTables {
+			if t.base == "document" {
+				continue
+			}
+			rows, err := selectRoomRows(ctx, tx, s.Table(t.base), t.columns, roomID)
+			if err != nil {
+				return err
+			}
+			if _, err := tx.Delete(ctx, s.Table(t.base), byRoom); err != nil {
+				return err
+			}
+			deleted[t.base] = rows
+		}
+		return unindexPermissions(ctx, index, byRoom)
+	})
+	if err != nil {
+		return fmt.Errorf("delete orphan rows of room %s on %s failed: %w", roomID, s.Name(), err)
+	}
+
+	for _, t := range roomTables {
+		if rows := deleted[t.base]; len(rows) > 0 {
+			report.changed(orphanIssue(t.base), roomID, s.Name(), "deleted orphan "+t.base+" rows", rows)
+		}
+		delete(d.rows[t.base], roomID)
+	}
+	return nil
+}
+
+// checkPlacement 检查分片上的房间是否在目录指定的分片上，修复时迁移过去。
+// 与重平衡不同，这里不比较哈希环：目录中的位置就是读写实际使用的位置
+func checkPlacement(ctx context.Context, report *CheckReport, d *shardData, docShards map[string][]string, repair bool) error {
+	s := d.shard
+	for _, roomID := range sortedKeys(d.docs) {
+		// 不使用缓存，目录刚被修改时也按最新的位置检查
+		directory(ctx).Invalidate(roomID)
+		p, err := directory(ctx).Lookup(ctx, roomID)
+		if err != nil {
+			return err
+		}
+		// 迁移中的房间数据可能同时在两个分片上，由重平衡负责完成
+		if p != nil && p.Migrating {
+			continue
+		}
+		target, how, pinned := backend(ctx).Locate(roomID), "hash", false
+		if p != nil {
+			var ok bool
+			if target, ok = backend(ctx).ShardByName(p.ShardName); !ok {
+				report.add(IssueWrongShard, roomID, s.Name(), "directory points to unknown shard "+p.ShardName)
+				continue
+			}
+			how, pinned = "directory", p.Pinned
+		}
+		if target.Name() == s.Name() {
+			continue
+		}
+
+		is := report.add(IssueWrongShard, roomID, s.Name(), fmt.Sprintf("stored on %s, %s target is %s", s.Name(), how, target.Name()))
+		if !repair || len(docShards[roomID]) > 1 {
+			continue
+		}
+
+		counts, err := moveRoom(ctx, RoomMove{RoomID: roomID, From: s, To: target, Pinned: pinned})
+		if err != nil {
+			log.Printf("Check: move room %s from %s to %s failed: %v", roomID, s.Name(), target.Name(), err)
+			continue
+		}
+		is.Repaired = true
+		report.changed(IssueWrongShard, roomID, s.Name(), fmt.Sprintf("moved to %s (%v)", target.Name(), counts), nil)
+	}
+	return nil
+}
+
+// indexKey user_rooms 中的一条记录：user_id、room_id 以及 permission 行所在的分片
+type indexKey struct {
+	userID, roomID string
+	shard          store.Shard
+}
+
+// checkUserRooms 比较 user_rooms 与所有分片上的 permission 行，修复时逐条更新有问题的记录（repairIndex）
+func checkUserRooms(ctx context.Context, report *CheckReport, data []*shardData, repair bool) error {
+	indexed := make(map[[2]string]string)
+	q := store.Query{Table: userRoomsTable.Name, Columns: userRoomsTable.Columns}
+	err := userShard(ctx).Scan(ctx, q, func(row store.Row) error {
+		indexed[[2]string{fmt.Sprint(row["user_id"]), fmt.Sprint(row["room_id"])}] = fmt.Sprint(row["permission"])
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("scan user_rooms failed: %w", err)
+	}
+
+	type finding struct {
+		issue *CheckIssue
+		key   indexKey
+	}
+	var found []finding
+	expected := make(map[[2]string]bool)
+	for _, d := range data {
+		permissions := d.rows["permission"]
+		for _, roomID := range sortedKeys(permissions) {
+			for _, row := range permissions[roomID] {
+				key := [2]string{fmt.Sprint(row["user_id"]), roomID}
+				expected[key] = true
+				var is *CheckIssue
+				perm, ok := indexed[key]
+				switch {
+				case !ok:
+					is = report.add(IssueUserRoomsMissing, roomID, d.shard.Name(), fmt.Sprintf("user %s not indexed", key[0]))
+				case perm != fmt.Sprint(row["permission"]):
+					is = report.add(IssueUserRoomsMissing, roomID, d.shard.Name(),
+						fmt.Sprintf("user %s indexed with permission %s, actual %v", key[0], perm, row["permission"]))
+				default:
+					continue
+				}
+				found = append(found, finding{is, indexKey{key[0], roomID, d.shard}})
+			}
+		}
+	}
+	keys := make([][2]string, 0, len(indexed))
+	for key := range indexed {
+		if !expected[key] {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i][0] < keys[j][0] || keys[i][0] == keys[j][0] && keys[i][1] < keys[j][1]
+	})
+	for _, key := range keys {
+		is := report.add(IssueUserRoomsStale, key[1], "", fmt.Sprintf("user %s has no permission row", key[0]))
+		// 房间当前所在的分片上重新确认
+		s, err := roomShard(ctx, key[1])
+		if err != nil {
+			return err
+		}
+		found = append(found, finding{is, indexKey{key[0], key[1], s}})
+	}
+
+	if !repair {
+		return nil
+	}
+	for _, f := range found {
+		action, err := repairIndex(ctx, f.key)
+		if err != nil {
+			return err
+		}
+		f.issue.Repaired = true
+		report.changed(f.issue.Kind, f.key.roomID, userShard(ctx).Name(), action, nil)
+	}
+	return nil
+}
+
+// repairIndex 在 permission 行所在分片的事务中重新读取该行，按它更新或删除 user_rooms 中的记录。
+// 只修改这一条记录，检查之后并发写入的其他 permission 行和索引不受影响
+func repairIndex(ctx context.Context, key indexKey) (string, error) {
+	t, _ := lookupTable("permission")
+	var action string
+	where := []store.Cond{{Column: "user_id", Value: key.userID}, {Column: "room_id", Value: key.roomID}}
+	err := withUserRooms(ctx, key.shard, func(tx, index store.Tx) error {
+		row, err := store.First(ctx, tx, store.Query{Table: key.shard.Table(t.base), Columns: t.columns, Where: where})
+		if err != nil {
+			return err
+		}
+		if row == nil {
+			action = fmt.Sprintf("removed user_rooms entry of user %s", key.userID)
+			return unindexPermissions(ctx, index, where)
+		}
+		action = fmt.Sprintf("indexed user %s with permission %v", key.userID, row["permission"])
+		return indexPermission(ctx, index, row)
+	})
+	if err != nil {
+		return "", fmt.Errorf("repair user_rooms of user %s room %s failed: %w", key.userID, key.roomID, err)
+	}
+	return action, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
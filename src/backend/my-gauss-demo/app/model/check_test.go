@@ -0,0 +1,164 @@
+package model
+
+import (
+	"context"
+	"testing"
+
+	"my-gauss-app/store"
+)
+
+// issues 按类型统计报告中的问题
+func issues(r *CheckReport) map[string]int {
+	kinds := make(map[string]int)
+	for _, is := range r.Issues {
+		kinds[is.Kind]++
+	}
+	return kinds
+}
+
+func TestCheckOrphanRows(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	// 没有 document 的房间在 permission 和配置数据集 comment 中留下的行
+	s := backend(ctx).Locate("gone")
+	if err := s.Insert(ctx, s.Table("permission"), store.Row{"room_id": "gone", "user_id": "u1", "permission": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Insert(ctx, s.Table("comment"), store.Row{"room_id": "gone", "seq": int64(1), "body": "left over"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kinds := issues(report); kinds[IssueOrphanPermission] != 1 || kinds[IssueOrphanRows] != 1 {
+		t.Fatalf("issues = %v, want orphan permission and orphan comment rows", kinds)
+	}
+
+	report, err = Check(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := report.Unrepaired(); n != 0 {
+		t.Errorf("%d issue(s) left unrepaired: %+v", n, report.Issues)
+	}
+	var deleted int
+	for _, c := range report.Changes {
+		if c.Kind == IssueOrphanRows {
+			deleted += len(c.Rows)
+			if len(c.Rows) == 1 && c.Rows[0]["body"] != "left over" {
+				t.Errorf("recorded row %v, want the full deleted row", c.Rows[0])
+			}
+		}
+	}
+	if deleted != 1 {
+		t.Errorf("recorded %d deleted comment row(s), want 1", deleted)
+	}
+	if n, _ := s.Count(ctx, s.Table("comment"), []store.Cond{{Column: "room_id", Value: "gone"}}); n != 0 {
+		t.Errorf("%d orphan comment row(s) left", n)
+	}
+	if report, err := Check(ctx, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("Check after repair = %+v, %v, want no issues", report, err)
+	}
+}
+
+func TestCheckReadsOnlyKeyColumns(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	rec := &recorder{}
+	Use(recordingStore{s, rec})
+	if _, err := Check(ctx, false); err != nil {
+		t.Fatal(err)
+	}
+	for _, q := range rec.queries {
+		if len(q.Columns) == 0 {
+			t.Errorf("query on %s selects all columns", q.Table)
+		}
+		for _, col := range q.Columns {
+			if col == "content" || col == "room_name" || col == "body" {
+				t.Errorf("query on %s selects %s", q.Table, col)
+			}
+		}
+	}
+}
+
+func TestCheckWrongShardFollowsDirectory(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	// 目录把房间放在哈希环以外的分片上（未固定）不是问题，那是重平衡的工作
+	ring := backend(ctx).Locate("r1")
+	other := otherShard(ring)
+	if _, err := MoveRoom(ctx, "r1", other.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if err := directory(ctx).Assign(ctx, "r1", other.Name(), false); err != nil {
+		t.Fatal(err)
+	}
+	if report, err := Check(ctx, false); err != nil || len(report.Issues) != 0 {
+		t.Fatalf("Check = %+v, %v, want no issues", report, err)
+	}
+
+	// 目录指回哈希环的分片，数据却留在原处
+	if err := directory(ctx).Assign(ctx, "r1", ring.Name(), false); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Check(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kinds := issues(report); kinds[IssueWrongShard] != 1 || report.Unrepaired() != 0 {
+		t.Fatalf("issues = %v, want one repaired wrong_shard", report.Issues[0])
+	}
+	if n := roomRows(t, ring, "r1"); n != 3 {
+		t.Errorf("%d row(s) of r1 on %s after repair, want 3", n, ring.Name())
+	}
+	if n := roomRows(t, other, "r1"); n != 0 {
+		t.Errorf("%d row(s) of r1 left on %s", n, other.Name())
+	}
+}
+
+func TestCheckRepairsUserRoomsInPlace(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addUser(t, "u2")
+	addRoom(t, "r1", "u1")
+	addRoom(t, "r2", "u2")
+
+	index := userShard(ctx)
+	u1r1 := []store.Cond{{Column: "user_id", Value: "u1"}, {Column: "room_id", Value: "r1"}}
+	if _, err := index.Delete(ctx, userRoomsTable.Name, u1r1); err != nil {
+		t.Fatal(err)
+	}
+	if err := index.Insert(ctx, userRoomsTable.Name, store.Row{"user_id": "u2", "room_id": "r1", "permission": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Check(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kinds := issues(report); kinds[IssueUserRoomsMissing] != 1 || kinds[IssueUserRoomsStale] != 1 || report.Unrepaired() != 0 {
+		t.Fatalf("issues = %+v, want one missing and one stale entry repaired", report.Issues)
+	}
+	if len(report.Changes) != 2 {
+		t.Errorf("changes = %+v, want one per entry", report.Changes)
+	}
+	for user, want := range map[string]int{"u1": 1, "u2": 1} {
+		if rooms, err := UserRooms(ctx, user); err != nil || len(rooms) != want {
+			t.Errorf("UserRooms(%s) = %v, %v, want %d room(s)", user, rooms, err, want)
+		}
+	}
+	if report, err := Check(ctx, false); err != nil || len(report.Issues) != 0 {
+		t.Errorf("Check after repair = %+v, %v, want no issues", report, err)
+	}
+}
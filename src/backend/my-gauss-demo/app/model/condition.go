@@ -0,0 +1,213 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// OrderKey 排序列；Desc 为 true 时降序
+type OrderKey struct {
+	Column string
+	Desc   bool
+}
+
+// ParseOrderBy 解析 order_by 参数："create_time,-room_id"，列名前加 "-" 表示降序
+func ParseOrderBy(s string) []OrderKey {
+	var keys []OrderKey
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		if strings.HasPrefix(part, "-") {
+			keys = append(keys, OrderKey{Column: strings.TrimPrefix(part, "-"), Desc: true})
+		} else {
+			keys = append(keys, OrderKey{Column: part})
+		}
+	}
+	return keys
+}
+
+// ReadRowsFilter 返回满足 filter 的所有行，每行只包含 goalKeys 中的列（"*" 表示整行）。
+// filter 限定了分片键（room_id = x、room_id in [...]）时只查询这些房间所在的分片，否则并发查询所有分片后合并。
+// orderBy 为空时按主键排序；否则按 orderBy 排序，主键作为最后的排序依据，结果稳定。
+// 排序和 limit 下推到各分片（ORDER BY … LIMIT n），再归并各分片的有序结果：
+// 数值和时间按大小比较，字符串按字节序，NULL 排在最后（降序时在最前），与 openGauss 一致。
+// limit 大于 0 时只返回前 limit 行，每个分片最多读取 limit 行
+func ReadRowsFilter(ctx context.Context, datasetName string, filter *store.Expr, goalKeys []string, orderBy []OrderKey, limit int) ([]map[string]interface{}, error) {
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return nil, err
+	}
+
+	goal, err := goalColumns(t, goalKeys)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkFilterColumns(t, filter); err != nil {
+		return nil, err
+	}
+	if err := t.checkFilterValues(filter); err != nil {
+		return nil, err
+	}
+	for _, k := range orderBy {
+		if err := t.checkColumn("order_by", k.Column); err != nil {
+			return nil, err
+		}
+	}
+
+	// 查询时额外取出排序列和主键，用于归并
+	order := append([]OrderKey{}, orderBy...)
+	for _, k := range pkOrder(t) {
+		if !slices.ContainsFunc(orderBy, func(o OrderKey) bool { return o.Column == k.Column }) {
+			order = append(order, k)
+		}
+	}
+	q := store.Query{Columns: goal, Filter: filter}
+	for _, k := range order {
+		if !containsString(q.Columns, k.Column) {
+			q.Columns = append(q.Columns, k.Column)
+		}
+	}
+
+	tables := physicalTables(ctx, t)
+	if t.sharded() {
+		if rooms, ok := pinnedRooms(filter, t.shardKey); ok {
+			if tables, err = pinnedTables(ctx, t, rooms); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	rows, err := scatterSorted(ctx, t, tables, q, order, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		out := make(map[string]interface{}, len(goal))
+		for _, col := range goal {
+			out[col] = row[col]
+		}
+		result[i] = out
+	}
+	return result, nil
+}
+
+// pinnedTables 返回分片键取值 keys 所在的分片表，每个分片只出现一次
+func pinnedTables(ctx context.Context, t tableSpec, keys []string) ([]shardTable, error) {
+	var tables []shardTable
+	seen := make(map[string]bool)
+	for _, key := range keys {
+		s, table, err := t.route(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[s.Name()] {
+			seen[s.Name()] = true
+			tables = append(tables, shardTable{s, table})
+		}
+	}
+	return tables, nil
+}
+
+// goalColumns 展开 goalKeys：为空或包含 "*" 时返回整行的列，其余列必须属于 t
+func goalColumns(t tableSpec, goalKeys []string) ([]string, error) {
+	if len(goalKeys) == 0 || containsString(goalKeys, "*") {
+		return t.columns, nil
+	}
+	var cols []string
+	for _, col := range goalKeys {
+		if err := t.checkColumn("goal_keys", col); err != nil {
+			return nil, err
+		}
+		if !containsString(cols, col) {
+			cols = append(cols, col)
+		}
+	}
+	return cols, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+func pkOrder(t tableSpec) []OrderKey {
+	keys := make([]OrderKey, len(t.pk))
+	for i, col := range t.pk {
+		keys[i] = OrderKey{Column: col}
+	}
+	return keys
+}
+
+// compareOrder 按 order 逐列比较两行
+func compareOrder(a, b map[string]interface{}, order []OrderKey) int {
+	for _, k := range order {
+		c := compareValues(a[k.Column], b[k.Column])
+		if k.Desc {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// compareValues 比较两个列值。NULL 大于任何值（openGauss 升序时 NULL 排在最后）；
+// 类型不同或无法比较时按字符串形式比较
+func compareValues(a, b interface{}) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return 1
+	case b == nil:
+		return -1
+	}
+
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+	return strings.Compare(fmt.Sprint(a), fmt.Sprint(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case float32:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
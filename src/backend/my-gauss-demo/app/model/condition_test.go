@@ -0,0 +1,202 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"my-gauss-app/store"
+)
+
+// recordingShard 记录在它上面执行的查询和返回的行数
+type recordingShard struct {
+	store.Shard
+	rec *recorder
+}
+
+type recorder struct {
+	mu      sync.Mutex
+	queries []store.Query
+	rows    int
+	// numericText 为 true 时 float64 列值以字符串返回，与 openGauss 驱动返回 NUMERIC 列的方式相同
+	numericText bool
+}
+
+func (s recordingShard) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	s.rec.mu.Lock()
+	s.rec.queries = append(s.rec.queries, q)
+	s.rec.mu.Unlock()
+	return s.Shard.Scan(ctx, q, func(row store.Row) error {
+		s.rec.mu.Lock()
+		s.rec.rows++
+		s.rec.mu.Unlock()
+		if s.rec.numericText {
+			for col, v := range row {
+				if f, ok := v.(float64); ok {
+					row[col] = strconv.FormatFloat(f, 'f', 2, 64)
+				}
+			}
+		}
+		return fn(row)
+	})
+}
+
+// recordingStore 所有分片都经过 recordingShard 的后端
+type recordingStore struct {
+	store.Store
+	rec *recorder
+}
+
+func (s recordingStore) Shards() []store.Shard {
+	var shards []store.Shard
+	for _, sh := range s.Store.Shards() {
+		shards = append(shards, recordingShard{sh, s.rec})
+	}
+	return shards
+}
+
+func (s recordingStore) ShardByName(name string) (store.Shard, bool) {
+	sh, ok := s.Store.ShardByName(name)
+	if !ok {
+		return nil, false
+	}
+	return recordingShard{sh, s.rec}, true
+}
+
+func (s recordingStore) Locate(key string) store.Shard {
+	return recordingShard{s.Store.Locate(key), s.rec}
+}
+func (s recordingStore) UserShard() store.Shard { return recordingShard{s.Store.UserShard(), s.rec} }
+
+func TestReadRowsFilterOrderedAndLimited(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+
+	// 两个房间分别在两个分片上，各有 10 条 comment；body 为空的行按 NULL 排序
+	rooms := []string{"r1", otherRoom(t, "r1")}
+	for _, roomID := range rooms {
+		for seq := 1; seq <= 10; seq++ {
+			row := map[string]interface{}{"room_id": roomID, "seq": float64(seq)}
+			if seq%5 != 0 {
+				row["body"] = fmt.Sprintf("%s-%02d", roomID, seq)
+			}
+			if err := InsertDataIntoDataset(ctx, "comment", row); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	rec := &recorder{}
+	Use(recordingStore{s, rec})
+	rows, err := ReadRowsFilter(ctx, "comment", nil, []string{"room_id", "seq"}, ParseOrderBy("-seq"), 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []map[string]interface{}{
+		{"room_id": rooms[0], "seq": int64(10)},
+		{"room_id": rooms[1], "seq": int64(10)},
+		{"room_id": rooms[0], "seq": int64(9)},
+	}
+	// 主键相同的 seq 按 room_id 字节序排列
+	if rooms[1] < rooms[0] {
+		want[0], want[1] = want[1], want[0]
+		want[2]["room_id"] = rooms[1]
+	}
+	if !reflect.DeepEqual(rows, want) {
+		t.Errorf("rows = %v, want %v", rows, want)
+	}
+	// 每个分片最多读取 limit 行
+	if rec.rows > 2*3 {
+		t.Errorf("read %d row(s) from the shards, want at most %d", rec.rows, 2*3)
+	}
+
+	// 升序时 NULL 排在最后，降序时在最前
+	rows, err = ReadRowsFilter(ctx, "comment", nil, []string{"body"}, ParseOrderBy("body"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 20 || rows[15]["body"] == nil || rows[16]["body"] != nil || rows[19]["body"] != nil {
+		t.Errorf("ascending body = %v, want NULLs last", rows)
+	}
+	rows, err = ReadRowsFilter(ctx, "comment", nil, []string{"body"}, ParseOrderBy("-body"), 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 2 || rows[0]["body"] != nil || rows[1]["body"] != nil {
+		t.Errorf("descending body = %v, want NULLs first", rows)
+	}
+}
+
+func TestReadRowsFilterOrdersNumericAcrossShards(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+
+	// 两个分片上都有商品，价格的字符串形式与数值顺序不同（"10.00" < "9.50"）
+	prices := map[string]float64{}
+	for i, price := range []float64{9.5, 10, 100, 2.25, 11, 9} {
+		sku := fmt.Sprintf("sku%d", i)
+		prices[sku] = price
+		if err := InsertDataIntoDataset(ctx, "item", map[string]interface{}{"sku": sku, "price": price}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shards := map[string]bool{}
+	for sku := range prices {
+		shards[s.Locate(sku).Name()] = true
+	}
+	if len(shards) < 2 {
+		t.Fatal("all items are on one shard")
+	}
+
+	Use(recordingStore{s, &recorder{numericText: true}})
+	rows, err := ReadRowsFilter(ctx, "item", nil, []string{"price"}, ParseOrderBy("price"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []interface{}
+	for _, row := range rows {
+		got = append(got, row["price"])
+	}
+	want := []interface{}{2.25, 9.0, 9.5, 10.0, 11.0, 100.0}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("prices = %v, want %v", got, want)
+	}
+}
+
+// otherRoom 返回一个与 roomID 不在同一分片上的房间号
+func otherRoom(t *testing.T, roomID string) string {
+	t.Helper()
+	ctx := context.Background()
+	for i := 0; i < 1000; i++ {
+		other := fmt.Sprintf("room%d", i)
+		if backend(ctx).Locate(other).Name() != backend(ctx).Locate(roomID).Name() {
+			return other
+		}
+	}
+	t.Fatal("no room on another shard")
+	return ""
+}
+
+func TestMergeSorted(t *testing.T) {
+	order := []OrderKey{{Column: "n", Desc: true}, {Column: "id"}}
+	lists := [][]map[string]interface{}{
+		{{"id": "a", "n": nil}, {"id": "a", "n": int64(5)}, {"id": "c", "n": int64(1)}},
+		{{"id": "b", "n": nil}, {"id": "b", "n": int64(5)}},
+		nil,
+		{{"id": "d", "n": int64(3)}},
+	}
+	var got []string
+	for _, row := range mergeSorted(lists, order, 0) {
+		got = append(got, fmt.Sprintf("%v%v", row["id"], row["n"]))
+	}
+	want := []string{"a<nil>", "b<nil>", "a5", "b5", "d3", "c1"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeSorted = %v, want %v", got, want)
+	}
+	if got := mergeSorted(lists, order, 2); len(got) != 2 || got[1]["id"] != "b" {
+		t.Errorf("mergeSorted with limit 2 = %v", got)
+	}
+}
@@ -1,542 +1,387 @@
 package model
 
 import (
-	"database/sql"
+	"context"
+	"errors"
 	"fmt"
 	"log"
-	"my-gauss-app/db"
 	"strings"
+	"time"
+
+	"my-gauss-app/store"
 )
 
-// hashRoomID 计算 room_id 的简单 hash，用于决定落在哪个分片（0 或 1）
-func hashRoomID(roomID string) int {
-	if roomID == "" {
-		return 0
-	}
-	h := 0
-	for i := 0; i < len(roomID); i++ {
-		h = (h + int(roomID[i])) % 2
+// targetTables 返回 where 可能命中的物理表：不分片的表只有一张；where 中有分片键的条件时
+// 只有它所在分片上的一张，否则是每个分片上的表
+func targetTables(ctx context.Context, t tableSpec, where []store.Cond) ([]shardTable, error) {
+	if t.sharded() {
+		for _, c := range where {
+			if c.Column != t.shardKey {
+				continue
+			}
+			key, err := t.shardKeyOf(c.Value)
+			if err != nil {
+				return nil, err
+			}
+			s, table, err := t.route(ctx, key)
+			if err != nil {
+				return nil, err
+			}
+			return []shardTable{{s, table}}, nil
+		}
 	}
-	return h
+	return physicalTables(ctx, t), nil
 }
 
-// getRoomShard 根据 room_id 和逻辑表名（document/permission/content）返回对应的 DB 和物理表名
-func getRoomShard(baseTable string, roomID string) (*sql.DB, string, error) {
-	shard := hashRoomID(roomID)
-	switch shard {
-	case 0:
-		return db.DBOg1, fmt.Sprintf("%s_0", baseTable), nil
-	case 1:
-		return db.DBOg2, fmt.Sprintf("%s_1", baseTable), nil
-	default:
-		return nil, "", fmt.Errorf("invalid shard for room_id %s", roomID)
+// checkRoomsWritable 写入 tables 之前检查其中匹配 where 的行所属的房间都不在迁移中，
+// 有房间正在迁移时返回 store.ErrRoomMigrating。where 中有 room_id 时 route 已经检查过；
+// 其他条件（如按 user_id 修改 permission）的写入不经过 LookupForWrite，
+// 迁移中的房间在源分片上被修改后，这次修改会在切换分片时丢失
+func checkRoomsWritable(ctx context.Context, t tableSpec, tables []shardTable, where []store.Cond) error {
+	if t.shardKey != roomShardKey {
+		return nil
 	}
+	for _, c := range where {
+		if c.Column == roomShardKey {
+			return nil
+		}
+	}
+	for _, st := range tables {
+		rows, err := store.Select(store.ReadFromPrimary(ctx), st.shard, store.Query{Table: st.table, Columns: []string{roomShardKey}, Where: where, Distinct: true})
+		if err != nil {
+			return fmt.Errorf("query %s failed: %w", st.table, err)
+		}
+		for _, row := range rows {
+			roomID, _ := row[roomShardKey].(string)
+			if _, err := directory(ctx).LookupForWrite(ctx, roomID); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
 }
 
-// ReadDataset 主键查询，根据主键查询整行数据或特定字段
-// dataset_name: 表名 (user, document, permission, content)
-// main_key: 主键值，可以是单个值或元组 (room_id, user_id)
-// goal_key: 目标字段名，如果是 "*" 则返回整行数据
-func queryAllRows(db *sql.DB, query string, datasetName string, goalKey string) (interface{}, error) {
-	rows, err := db.Query(query)
+// firstRow 返回满足 where 的第一行，没有时返回 nil。
+// 需要查询多个分片时并发查询，按分片顺序取第一条命中；有分片不可用被跳过且其余分片都没有命中时，
+// 返回列出这些分片的 *ScatterError（shard_unavailable），而不是没有这一行
+func firstRow(ctx context.Context, t tableSpec, columns []string, where []store.Cond) (store.Row, error) {
+	tables, err := targetTables(ctx, t, where)
 	if err != nil {
 		return nil, err
 	}
-	defer rows.Close()
-
-	var all []map[string]interface{}
-	for rows.Next() {
-		row, err := scanRowToMap(rows, datasetName)
-		if err != nil {
+	if len(tables) == 1 {
+		row, err := store.First(ctx, tables[0].shard, store.Query{Table: tables[0].table, Columns: columns, Where: where})
+		if err != nil || row == nil {
 			return nil, err
 		}
-		all = append(all, row)
+		return row, t.decodeRow(row)
 	}
-	return all, nil
-}
-
-func ReadDataset(datasetName string, mainKey interface{}, goalKey string) (interface{}, error) {
-	var targetDB *sql.DB
-	var table string
-	var query string
-	var args []interface{}
-
-	if mainKey == "*" {
-		switch datasetName {
-
-		case "user":
-			// 用户表不分片
-			targetDB = db.DBOg1
-			table = "\"user\""
-
-			if goalKey == "*" {
-				query = fmt.Sprintf("SELECT id, user_name, email, password FROM %s", table)
-			} else {
-				query = fmt.Sprintf("SELECT %s FROM %s", goalKey, table)
-			}
-
-			return queryAllRows(targetDB, query, datasetName, goalKey)
-
-		case "permission":
-			// 遍历所有分片
-			shards := []struct {
-				db    *sql.DB
-				table string
-			}{
-				{db.DBOg1, "permission_0"},
-				{db.DBOg2, "permission_1"},
-			}
-
-			var all []map[string]interface{}
-			for _, s := range shards {
-				var q string
-				if goalKey == "*" {
-					q = fmt.Sprintf("SELECT room_id, user_id, permission FROM %s", s.table)
-				} else {
-					q = fmt.Sprintf("SELECT %s FROM %s", goalKey, s.table)
-				}
-
-				rows, err := s.db.Query(q)
-				if err != nil {
-					return nil, err
-				}
-				defer rows.Close()
-
-				for rows.Next() {
-					row, err := scanRowToMap(rows, datasetName)
-					if err != nil {
-						return nil, err
-					}
-					all = append(all, row)
-				}
-			}
-			return all, nil
-
-		case "document":
-			fallthrough
-		case "content":
-			// document/content 两份分片
-			shards := []struct {
-				db    *sql.DB
-				table string
-			}{
-				{db.DBOg1, datasetName + "_0"},
-				{db.DBOg2, datasetName + "_1"},
-			}
-
-			var all []map[string]interface{}
-			for _, s := range shards {
-				var q string
-				if goalKey == "*" {
-					if datasetName == "document" {
-						q = fmt.Sprintf("SELECT room_id, room_name, create_time, overall_permission, owner_user_id FROM %s", s.table)
-					} else {
-						q = fmt.Sprintf("SELECT room_id, content FROM %s", s.table)
-					}
-				} else {
-					q = fmt.Sprintf("SELECT %s FROM %s", goalKey, s.table)
-				}
-
-				rows, err := s.db.Query(q)
-				if err != nil {
-					return nil, err
-				}
-				defer rows.Close()
-
-				for rows.Next() {
-					row, err := scanRowToMap(rows, datasetName)
-					if err != nil {
-						return nil, err
-					}
-					all = append(all, row)
-				}
-			}
-			return all, nil
-
-		default:
-			return nil, fmt.Errorf("unknown dataset: %s", datasetName)
+	found, err := scatter(ctx, tables, func(ctx context.Context, st shardTable) ([]store.Row, error) {
+		row, err := store.First(ctx, st.shard, store.Query{Table: st.table, Columns: columns, Where: where})
+		if err != nil || row == nil {
+			return nil, err
 		}
+		return []store.Row{row}, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-
-	// -----------------------------
-	// 下面是你原来的逻辑 (mainKey != "*")
-	// -----------------------------
-
-	if strings.HasPrefix(datasetName, "user") {
-		// ...（此处完全保留你的原代码）
-		keyStr, ok := mainKey.(string)
-		if !ok {
-			return nil, fmt.Errorf("user table requires string key")
+	if len(found) == 0 {
+		// 可用的分片上都没有，而跳过的分片上可能有：不能回答没有这一行
+		if p := partialFrom(ctx); p != nil && p.Degraded() {
+			return nil, missingError(tables, p.MissingShards())
 		}
-		targetDB = db.DBOg1
-		table = "\"user\""
+		return nil, nil
+	}
+	return found[0], t.decodeRow(found[0])
+}
 
-		if goalKey == "*" {
-			query = fmt.Sprintf("SELECT id, user_name, email, password FROM %s WHERE id = $1", table)
-		} else {
-			query = fmt.Sprintf("SELECT %s FROM %s WHERE id = $1", goalKey, table)
-		}
-		args = []interface{}{keyStr}
+// keyConds 把主键值转为条件：单个值对应主键第一列，数组依次对应主键的前几列，
+// 例如 permission 的 [room_id, user_id] 或只给 room_id
+func keyConds(t tableSpec, mainKey interface{}) ([]store.Cond, error) {
+	values, ok := mainKey.([]interface{})
+	if !ok {
+		values = []interface{}{mainKey}
+	}
+	if len(values) == 0 || len(values) > len(t.pk) {
+		return nil, Validation("%s key must have 1 to %d values (%v)", t.base, len(t.pk), t.pk)
+	}
+	where := make([]store.Cond, len(values))
+	for i, v := range values {
+		where[i] = store.Cond{Column: t.pk[i], Value: v}
+	}
+	return where, nil
+}
 
-	} else if datasetName == "permission" {
-		// ...（完全保留你的原代码）
-		// 略，为节省篇幅
+// isPermissionDataset 数据集是否是 permission 表（写入时需要同步 user_rooms 索引）
+func isPermissionDataset(datasetName string) bool {
+	t, ok := lookupTable(datasetName)
+	return ok && t.base == "permission"
+}
 
-	} else {
-		// document, content 处理
-		// ...（完全保留你的原代码）
+// pickColumn goalKey 为 "*" 时返回整行，否则返回该字段的值；row 为 nil 表示没有查到
+func pickColumn(row store.Row, goalKey string) interface{} {
+	if row == nil {
+		return nil
 	}
+	if goalKey == "*" {
+		return row
+	}
+	return row[goalKey]
+}
 
-	// 执行查询
-	rows, err := targetDB.Query(query, args...)
+// checkGoalKey goalKey 为 "*" 或 t 的列
+func checkGoalKey(t tableSpec, goalKey string) error {
+	if goalKey == "*" {
+		return nil
+	}
+	return t.checkColumn("goal_key", goalKey)
+}
+
+// ReadDataset 主键查询，根据主键查询整行数据或特定字段
+// dataset_name: 数据集名或别名
+// main_key: 主键值，可以是单个值或元组 (room_id, user_id)；"*" 表示读取全表
+// goal_key: 目标字段名，如果是 "*" 则返回整行数据
+// ctx 经 store.ReadFromReplica 标记时从只读副本读取
+func ReadDataset(ctx context.Context, datasetName string, mainKey interface{}, goalKey string) (interface{}, error) {
+	t, err := datasetTable(datasetName)
 	if err != nil {
-		return nil, fmt.Errorf("query failed: %v", err)
+		return nil, err
+	}
+	if err := checkGoalKey(t, goalKey); err != nil {
+		return nil, err
 	}
-	defer rows.Close()
 
-	if !rows.Next() {
-		return nil, nil
+	if mainKey == "*" {
+		// 分片表在所有分片上并发查询后合并
+		return readRows(ctx, t, physicalTables(ctx, t), store.Query{Columns: selectColumns(t, goalKey)})
 	}
 
-	if goalKey == "*" {
-		return scanRowToMap(rows, datasetName)
-	} else {
-		var result interface{}
-		if err := rows.Scan(&result); err != nil {
-			return nil, fmt.Errorf("scan failed: %v", err)
-		}
-		return result, nil
+	where, err := keyConds(t, mainKey)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.checkConds("main_key", where); err != nil {
+		return nil, err
+	}
+	row, err := firstRow(ctx, t, selectColumns(t, goalKey), where)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
+	}
+	if row == nil {
+		return nil, NotFound("no %s row with %s", t.base, condString(where))
 	}
+	return pickColumn(row, goalKey), nil
 }
 
 // ReadDatasetCondition 条件查询，根据某个字段的值查询
-func ReadDatasetCondition(datasetName string, keyName string, keyValue interface{}, goalKey string) (interface{}, error) {
-	// 用户表：不分片，直接在 user 上查询
-	if strings.HasPrefix(datasetName, "user") {
-		table := "\"user\""
-		var query string
-		if goalKey == "*" {
-			query = fmt.Sprintf("SELECT id, user_name, email, password FROM %s WHERE %s = $1", table, keyName)
-		} else {
-			query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1", goalKey, table, keyName)
-		}
-
-		rows, err := db.DBOg1.Query(query, keyValue)
-		if err != nil {
-			return nil, fmt.Errorf("query user failed: %v", err)
-		}
-		defer rows.Close()
-
-		if !rows.Next() {
-			return nil, nil
-		}
-
-		if goalKey == "*" {
-			return scanRowToMap(rows, "user")
-		}
-
-		var result interface{}
-		if err := rows.Scan(&result); err != nil {
-			return nil, fmt.Errorf("scan failed: %v", err)
-		}
-		return result, nil
+func ReadDatasetCondition(ctx context.Context, datasetName string, keyName string, keyValue interface{}, goalKey string) (interface{}, error) {
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return nil, err
 	}
-
-	// 其他表（document(room)、permission、content）：按 room_id hash 分片
-	if datasetName != "document" && datasetName != "permission" && datasetName != "content" {
-		return nil, fmt.Errorf("unknown dataset: %s", datasetName)
+	if err := t.checkColumn("key_name", keyName); err != nil {
+		return nil, err
 	}
-
-	// 如果按 room_id 作为条件，可以根据 room_id 精确定位分片
-	if keyName == "room_id" {
-		roomID, ok := keyValue.(string)
-		if !ok {
-			return nil, fmt.Errorf("room_id must be string")
-		}
-		targetDB, table, err := getRoomShard(datasetName, roomID)
-		if err != nil {
-			return nil, err
-		}
-
-		var query string
-		if goalKey == "*" {
-			if datasetName == "document" {
-				query = fmt.Sprintf("SELECT room_id, room_name, create_time, overall_permission, owner_user_id FROM %s WHERE %s = $1", table, keyName)
-			} else if datasetName == "permission" {
-				query = fmt.Sprintf("SELECT room_id, user_id, permission FROM %s WHERE %s = $1", table, keyName)
-			} else if datasetName == "content" {
-				query = fmt.Sprintf("SELECT room_id, content FROM %s WHERE %s = $1", table, keyName)
-			}
-		} else {
-			query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1", goalKey, table, keyName)
-		}
-
-		rows, err := targetDB.Query(query, keyValue)
-		if err != nil {
-			return nil, fmt.Errorf("query failed: %v", err)
-		}
-		defer rows.Close()
-
-		if !rows.Next() {
-			return nil, nil
-		}
-
-		if goalKey == "*" {
-			return scanRowToMap(rows, datasetName)
-		}
-
-		var result interface{}
-		if err := rows.Scan(&result); err != nil {
-			return nil, fmt.Errorf("scan failed: %v", err)
-		}
-		return result, nil
+	if err := checkGoalKey(t, goalKey); err != nil {
+		return nil, err
 	}
-
-	// 其他条件（如 permission.user_id 等），需要扫描所有分片
-	shards := []struct {
-		db    *sql.DB
-		table string
-	}{
-		{db.DBOg1, fmt.Sprintf("%s_0", datasetName)},
-		{db.DBOg2, fmt.Sprintf("%s_1", datasetName)},
+	// 条件是分片键时只查它所在的分片，其他条件（如 permission.user_id）查询所有分片
+	where := []store.Cond{{Column: keyName, Value: keyValue}}
+	if err := t.checkConds("key_value", where); err != nil {
+		return nil, err
 	}
-
-	for _, s := range shards {
-		var query string
-		if goalKey == "*" {
-			if datasetName == "document" {
-				query = fmt.Sprintf("SELECT room_id, room_name, create_time, overall_permission, owner_user_id FROM %s WHERE %s = $1", s.table, keyName)
-			} else if datasetName == "permission" {
-				query = fmt.Sprintf("SELECT room_id, user_id, permission FROM %s WHERE %s = $1", s.table, keyName)
-			} else if datasetName == "content" {
-				query = fmt.Sprintf("SELECT room_id, content FROM %s WHERE %s = $1", s.table, keyName)
-			}
-		} else {
-			query = fmt.Sprintf("SELECT %s FROM %s WHERE %s = $1", goalKey, s.table, keyName)
-		}
-
-		rows, err := s.db.Query(query, keyValue)
-		if err != nil {
-			return nil, fmt.Errorf("query %s failed: %v", s.table, err)
-		}
-		defer rows.Close()
-
-		if rows.Next() {
-			if goalKey == "*" {
-				return scanRowToMap(rows, datasetName)
-			}
-			var result interface{}
-			if err := rows.Scan(&result); err != nil {
-				return nil, fmt.Errorf("scan failed: %v", err)
-			}
-			return result, nil
-		}
+	row, err := firstRow(ctx, t, selectColumns(t, goalKey), where)
+	if err != nil {
+		return nil, fmt.Errorf("query failed: %w", err)
 	}
-
-	return nil, nil
+	if row == nil {
+		return nil, NotFound("no %s row with %s", t.base, condString(where))
+	}
+	return pickColumn(row, goalKey), nil
 }
 
 // InsertDataIntoDataset 插入整行数据
-func InsertDataIntoDataset(datasetName string, data map[string]interface{}) error {
-	var targetDB *sql.DB
-	var table string
-	var columns []string
-	var placeholders []string
-	var values []interface{}
-
-	// 确定目标数据库和表名
-	if strings.HasPrefix(datasetName, "user") {
-		// 用户表不分片
-		targetDB = db.DBOg1
-		table = "\"user\""
-
-		columns = []string{"id", "user_name", "email", "password"}
-		for i, col := range columns {
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-			val, ok := data[col]
-			if !ok {
-				val = ""
-			}
-			values = append(values, val)
-		}
+func InsertDataIntoDataset(ctx context.Context, datasetName string, data map[string]interface{}) error {
+	ctx = forWrite(ctx)
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return err
+	}
+	target, table, row, err := prepareRow(ctx, t, data)
+	if err != nil {
+		return err
+	}
 
-	} else {
-		// room(document)、permission、content：按 room_id hash 分片
-		roomIDVal, ok := data["room_id"]
-		if !ok {
-			return fmt.Errorf("%s requires 'room_id' field", datasetName)
+	// 内置的房间表经类型化仓库写入，permission 与 user_rooms 索引一起写入
+	switch t.base {
+	case "document":
+		var d Document
+		if d, err = scanDocument(row); err == nil {
+			err = Rooms.insertOn(ctx, target, table, d)
 		}
-		roomID, ok := roomIDVal.(string)
-		if !ok {
-			return fmt.Errorf("room_id must be string")
+	case "permission":
+		var p Permission
+		if p, err = scanPermission(row); err == nil {
+			err = Permissions.insertOn(ctx, target, table, p)
 		}
-
-		var err error
-		targetDB, table, err = getRoomShard(datasetName, roomID)
-		if err != nil {
-			return err
-		}
-
-		if datasetName == "document" {
-			columns = []string{"room_id", "room_name", "create_time", "overall_permission", "owner_user_id"}
-		} else if datasetName == "permission" {
-			columns = []string{"room_id", "user_id", "permission"}
-		} else if datasetName == "content" {
-			columns = []string{"room_id", "content"}
-		} else {
-			return fmt.Errorf("unknown dataset: %s", datasetName)
-		}
-
-		for i, col := range columns {
-			placeholders = append(placeholders, fmt.Sprintf("$%d", i+1))
-			val, ok := data[col]
-			if !ok {
-				val = nil
-			}
-			values = append(values, val)
+	case "content":
+		var c Content
+		if c, err = scanContent(row); err == nil {
+			err = Contents.insertOn(ctx, target, table, c)
 		}
+	default:
+		err = target.Insert(ctx, table, row)
 	}
-
-	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-		table,
-		strings.Join(columns, ", "),
-		strings.Join(placeholders, ", "))
-
-	_, err := targetDB.Exec(query, values...)
 	if err != nil {
 		log.Printf("Insert into %s failed: %v", table, err)
-		return fmt.Errorf("insert failed: %v", err)
+		return fmt.Errorf("insert failed: %w", err)
 	}
 
 	return nil
 }
 
-// ModifyDatasetCondition 根据条件修改某个字段的值
-func ModifyDatasetCondition(datasetName string, keyName string, keyValue interface{}, goalKey string, goalValue interface{}) (bool, error) {
-	// 用户表：单表 user
-	if strings.HasPrefix(datasetName, "user") {
-		table := "\"user\""
-		query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", table, goalKey, keyName)
-		result, err := db.DBOg1.Exec(query, goalValue, keyValue)
-		if err != nil {
-			return false, fmt.Errorf("update failed: %v", err)
+// prepareRow 校验要写入的一行并定位它所在的分片和物理表，返回完整的行：
+// 缺少的列写入 NULL，用户表沿用原来的约定写入空串。按 room_id 分片的表在校验通过后先把房间登记到目录
+func prepareRow(ctx context.Context, t tableSpec, data map[string]interface{}) (store.Shard, string, store.Row, error) {
+	for col := range data {
+		if err := t.checkColumn("data", col); err != nil {
+			return nil, "", nil, err
 		}
-
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
-			return false, fmt.Errorf("get rows affected failed: %v", err)
-		}
-
-		return rowsAffected > 0, nil
-	}
-
-	// 其他表（document, permission, content）：按 room_id hash 分片
-	if datasetName != "document" && datasetName != "permission" && datasetName != "content" {
-		return false, fmt.Errorf("unknown dataset: %s", datasetName)
 	}
 
-	// 如果按 room_id 更新，则可以定位到单个分片
-	if keyName == "room_id" {
-		roomID, ok := keyValue.(string)
+	// 分片表必须给出分片键，按它的文本形式定位
+	var key string
+	if t.sharded() {
+		v, ok := data[t.shardKey]
 		if !ok {
-			return false, fmt.Errorf("room_id must be string")
+			return nil, "", nil, Validation("%s requires '%s' field", t.base, t.shardKey)
 		}
-		targetDB, table, err := getRoomShard(datasetName, roomID)
-		if err != nil {
-			return false, err
+		var err error
+		if key, err = t.shardKeyOf(v); err != nil {
+			return nil, "", nil, err
 		}
+	}
 
-		query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", table, goalKey, keyName)
-		result, err := targetDB.Exec(query, goalValue, keyValue)
-		if err != nil {
-			return false, fmt.Errorf("update failed: %v", err)
+	row := make(store.Row, len(t.columns))
+	for _, col := range t.columns {
+		val, ok := data[col]
+		if !ok && t.base == userTable.base {
+			val = ""
 		}
+		row[col] = val
+	}
+	if err := t.checkValues("data", row); err != nil {
+		return nil, "", nil, err
+	}
 
-		rowsAffected, err := result.RowsAffected()
+	if t.shardKey == roomShardKey {
+		target, err := placeRoom(ctx, key)
 		if err != nil {
-			return false, fmt.Errorf("get rows affected failed: %v", err)
+			return nil, "", nil, err
 		}
-
-		return rowsAffected > 0, nil
+		return target, target.Table(t.base), row, nil
+	}
+	target, table, err := t.route(ctx, key)
+	if err != nil {
+		return nil, "", nil, err
 	}
+	return target, table, row, nil
+}
 
-	// 其他条件需要更新所有分片
-	shards := []struct {
-		db    *sql.DB
-		table string
-	}{
-		{db.DBOg1, fmt.Sprintf("%s_0", datasetName)},
-		{db.DBOg2, fmt.Sprintf("%s_1", datasetName)},
+// ModifyDatasetCondition 根据条件修改某个字段的值，没有匹配的行时返回 NotFound。
+// 更新期间连接中断（store.ErrResultUnknown）时在主库上查询是否已有修改后的行，有则按成功处理
+func ModifyDatasetCondition(ctx context.Context, datasetName string, keyName string, keyValue interface{}, goalKey string, goalValue interface{}) (bool, error) {
+	ctx = forWrite(ctx)
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return false, err
+	}
+	if err := t.checkColumn("key_name", keyName); err != nil {
+		return false, err
+	}
+	if err := t.checkColumn("goal_key", goalKey); err != nil {
+		return false, err
+	}
+	// 原地修改分片键或主键会让行留在按旧值定位的分片上，目录和哈希环都找不到它
+	if goalKey == t.shardKey || containsString(t.pk, goalKey) {
+		return false, &FieldError{Field: "goal_key", Value: goalKey, Err: errors.New("shard key and primary key columns cannot be modified")}
+	}
+	set := store.Row{goalKey: goalValue}
+	where := []store.Cond{{Column: keyName, Value: keyValue}}
+	if err := t.checkValues("goal_value", set); err != nil {
+		return false, err
+	}
+	if err := t.checkConds("key_value", where); err != nil {
+		return false, err
 	}
 
+	// 按分片键更新时只涉及一个分片，其他条件需要更新所有分片
+	tables, err := targetTables(ctx, t, where)
+	if err != nil {
+		return false, err
+	}
+	if err := checkRoomsWritable(ctx, t, tables, where); err != nil {
+		return false, err
+	}
 	totalRows := int64(0)
-	for _, s := range shards {
-		query := fmt.Sprintf("UPDATE %s SET %s = $1 WHERE %s = $2", s.table, goalKey, keyName)
-		result, err := s.db.Exec(query, goalValue, keyValue)
-		if err != nil {
-			return false, fmt.Errorf("update %s failed: %v", s.table, err)
+	for _, st := range tables {
+		var n int64
+		if isPermissionDataset(datasetName) {
+			n, err = updatePermissions(ctx, st, set, where)
+		} else {
+			n, err = st.shard.Update(ctx, st.table, set, where)
+			if cause := err; errors.Is(cause, store.ErrResultUnknown) {
+				// 没有修改后的行时无法区分"更新没有生效"和"没有匹配的行"，返回原来的错误
+				if n, err = recheck(ctx, st, updatedConds(where, set), cause); err == nil && n == 0 {
+					err = cause
+				}
+			}
 		}
-
-		rowsAffected, err := result.RowsAffected()
 		if err != nil {
-			return false, fmt.Errorf("get rows affected failed: %v", err)
+			return false, fmt.Errorf("update %s failed: %w", st.table, err)
 		}
-		totalRows += rowsAffected
+		totalRows += n
 	}
 
-	return totalRows > 0, nil
+	if totalRows == 0 {
+		return false, NotFound("no %s row with %s", t.base, condString(where))
+	}
+	return true, nil
 }
 
-// scanRowToMap 将查询结果扫描到 map
-func scanRowToMap(rows *sql.Rows, datasetName string) (map[string]interface{}, error) {
-	result := make(map[string]interface{})
-
-	if datasetName == "user" || strings.HasPrefix(datasetName, "user") {
-		var id, userName, email, password string
-		if err := rows.Scan(&id, &userName, &email, &password); err != nil {
-			return nil, err
-		}
-		result["id"] = id
-		result["user_name"] = userName
-		result["email"] = email
-		result["password"] = password
-	} else if datasetName == "document" {
-		var roomID, roomName, owner_user_id string
-		var createTime sql.NullTime
-		var overallPermission sql.NullInt64
-		if err := rows.Scan(&roomID, &roomName, &createTime, &overallPermission, &owner_user_id); err != nil {
-			return nil, err
-		}
-		result["owner_user_id"] = owner_user_id
-		result["room_id"] = roomID
-		result["room_name"] = roomName
-		if createTime.Valid {
-			result["create_time"] = createTime.Time
-		}
-		if overallPermission.Valid {
-			result["overall_permission"] = overallPermission.Int64
-		}
-	} else if datasetName == "permission" {
-		var roomID, userID string
-		var permission sql.NullInt64
-		if err := rows.Scan(&roomID, &userID, &permission); err != nil {
-			return nil, err
+// updatePermissions 更新一个分片上的 permission 行，并在同一事务中同步 user_rooms：
+// 先取出将被更新的行，更新后按新值替换它们的索引记录
+func updatePermissions(ctx context.Context, st shardTable, set store.Row, where []store.Cond) (int64, error) {
+	t, _ := lookupTable("permission")
+	var n int64
+	err := withUserRooms(ctx, st.shard, func(tx, index store.Tx) error {
+		old, err := store.Select(ctx, tx, store.Query{Table: st.table, Columns: t.columns, Where: where})
+		if err != nil {
+			return err
 		}
-		result["room_id"] = roomID
-		result["user_id"] = userID
-		if permission.Valid {
-			result["permission"] = permission.Int64
+		if n, err = tx.Update(ctx, st.table, set, where); err != nil {
+			return err
 		}
-	} else if datasetName == "content" {
-		var roomID, content string
-		if err := rows.Scan(&roomID, &content); err != nil {
-			return nil, err
+		for _, row := range old {
+			key := []store.Cond{{Column: "user_id", Value: row["user_id"]}, {Column: "room_id", Value: row["room_id"]}}
+			if err := unindexPermissions(ctx, index, key); err != nil {
+				return err
+			}
+			updated := make(store.Row, len(row))
+			for col, v := range row {
+				updated[col] = v
+			}
+			for col, v := range set {
+				updated[col] = v
+			}
+			if err := indexPermission(ctx, index, updated); err != nil {
+				return err
+			}
 		}
-		result["room_id"] = roomID
-		result["content"] = content
-	}
-
-	return result, nil
+		return nil
+	})
+	return n, err
 }
 
 // ReadJSON 读取整个数据集（表）的所有数据
@@ -545,446 +390,279 @@ func scanRowToMap(rows *sql.Rows, datasetName string) (map[string]interface{}, e
 //   - "document" 或 "user_room_table" -> document 分片表
 //   - "permission" 或 "room_permission_table" -> permission 分片表
 //   - "content" 或 "room_content_table" -> content 分片表
-func ReadJSON(datasetName string) ([]map[string]interface{}, error) {
-	var results []map[string]interface{}
-
-	// 映射数据集名称到实际表名
-	if datasetName == "user_table" || datasetName == "user" {
-		// 用户表单表
-		query := "SELECT id, user_name, email, password FROM \"user\""
-		rows, err := db.DBOg1.Query(query)
-		if err != nil {
-			return nil, fmt.Errorf("query user failed: %v", err)
-		}
-		defer rows.Close()
+//   - 配置文件 datasets 中声明的数据集及其别名
+func ReadJSON(ctx context.Context, datasetName string) ([]map[string]interface{}, error) {
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return nil, err
+	}
+	return readRows(ctx, t, physicalTables(ctx, t), store.Query{Columns: t.columns})
+}
 
-		for rows.Next() {
-			var id, userName, email, password string
-			if err := rows.Scan(&id, &userName, &email, &password); err != nil {
-				return nil, fmt.Errorf("scan failed: %v", err)
-			}
-			results = append(results, map[string]interface{}{
-				"id":        id,
-				"user_name": userName,
-				"email":     email,
-				"password":  password,
-			})
-		}
-		return results, nil
-
-	} else if datasetName == "user_room_table" || datasetName == "document" {
-		// document 分片表
-		shards := []struct {
-			db    *sql.DB
-			table string
-		}{
-			{db.DBOg1, "document_0"},
-			{db.DBOg2, "document_1"},
-		}
-
-		for _, s := range shards {
-			query := fmt.Sprintf("SELECT room_id, room_name, create_time, overall_permission, owner_user_id FROM %s", s.table)
-			rows, err := s.db.Query(query)
-			if err != nil {
-				return nil, fmt.Errorf("query %s failed: %v", s.table, err)
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var roomID, roomName, owner_user_id string
-				var createTime sql.NullTime
-				var overallPermission sql.NullInt64
-				if err := rows.Scan(&roomID, &roomName, &createTime, &overallPermission); err != nil {
-					return nil, fmt.Errorf("scan failed: %v", err)
-				}
-				row := map[string]interface{}{
-					"room_id":       roomID,
-					"room_name":     roomName,
-					"owner_user_id": owner_user_id,
-				}
-				if createTime.Valid {
-					row["create_time"] = createTime.Time
-				}
-				if overallPermission.Valid {
-					row["overall_permission"] = overallPermission.Int64
-				}
-				results = append(results, row)
-			}
-		}
-		return results, nil
+// WriteJSON 写入整个数据集（表）的数据
+// 先清空表，然后插入新数据；涉及的每个分片各自在一个事务内完成清空和写入，
+// 再通过两阶段提交（PREPARE TRANSACTION / COMMIT PREPARED）一起提交：
+// 要么所有分片都换成新数据，要么全部保持原样。任一行缺少分片键时返回 Validation，不做任何修改
+// dataset_name 支持同 ReadJSON
+func WriteJSON(ctx context.Context, datasetName string, data []map[string]interface{}) error {
+	ctx = forWrite(ctx)
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return err
+	}
+	sharded := t.sharded()
 
-	} else if datasetName == "room_permission_table" || datasetName == "permission" {
-		// permission 分片表
-		shards := []struct {
-			db    *sql.DB
-			table string
-		}{
-			{db.DBOg1, "permission_0"},
-			{db.DBOg2, "permission_1"},
+	// 按分片分组：不分片的表全部写入 user 所在实例，其余按分片键定位分片
+	tables := physicalTables(ctx, t)
+	rowsByShard := make(map[string][]map[string]interface{})
+	for i, row := range data {
+		if !sharded {
+			name := tables[0].shard.Name()
+			rowsByShard[name] = append(rowsByShard[name], row)
+			continue
 		}
 
-		for _, s := range shards {
-			query := fmt.Sprintf("SELECT room_id, user_id, permission FROM %s", s.table)
-			rows, err := s.db.Query(query)
-			if err != nil {
-				return nil, fmt.Errorf("query %s failed: %v", s.table, err)
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var roomID, userID string
-				var permission sql.NullInt64
-				if err := rows.Scan(&roomID, &userID, &permission); err != nil {
-					return nil, fmt.Errorf("scan failed: %v", err)
-				}
-				row := map[string]interface{}{
-					"room_id": roomID,
-					"user_id": userID,
-				}
-				if permission.Valid {
-					row["permission"] = permission.Int64
-				}
-				results = append(results, row)
-			}
+		// 缺少分片键的行无法定位分片，整个请求作废而不是丢掉这一行后清空原数据
+		key, err := t.shardKeyOf(row[t.shardKey])
+		if err != nil || key == "" {
+			return Validation("data[%d] requires non-empty '%s'", i, t.shardKey)
 		}
-		return results, nil
-
-	} else if datasetName == "room_content_table" || datasetName == "content" {
-		// content 分片表
-		shards := []struct {
-			db    *sql.DB
-			table string
-		}{
-			{db.DBOg1, "content_0"},
-			{db.DBOg2, "content_1"},
+		s, _, err := t.route(ctx, key)
+		if err != nil {
+			return err
 		}
+		rowsByShard[s.Name()] = append(rowsByShard[s.Name()], row)
+	}
 
-		for _, s := range shards {
-			query := fmt.Sprintf("SELECT room_id, content FROM %s", s.table)
-			rows, err := s.db.Query(query)
-			if err != nil {
-				return nil, fmt.Errorf("query %s failed: %v", s.table, err)
-			}
-			defer rows.Close()
-
-			for rows.Next() {
-				var roomID, content string
-				if err := rows.Scan(&roomID, &content); err != nil {
-					return nil, fmt.Errorf("scan failed: %v", err)
-				}
-				results = append(results, map[string]interface{}{
-					"room_id": roomID,
-					"content": content,
-				})
-			}
+	// 第一阶段之前：每个分片在自己的事务里清空并写入
+	var txs []store.Tx
+	abort := func() {
+		for _, tx := range txs {
+			tx.Rollback()
 		}
-		return results, nil
-
-	} else {
-		return nil, fmt.Errorf("unknown dataset: %s", datasetName)
 	}
-}
-
-// WriteJSON 写入整个数据集（表）的数据
-// 先清空表，然后插入新数据
-// dataset_name 支持同 ReadJSON
-func WriteJSON(datasetName string, data []map[string]interface{}) error {
-	// 开始事务
-	var targetDB *sql.DB
-	var table string
-	var columns []string
-
-	// 确定目标数据库和表名
-	if datasetName == "user_table" || datasetName == "user" {
-		// 用户表单表，直接清空 user 然后写入
-		targetDB = db.DBOg1
-		table = "\"user\""
-		columns = []string{"id", "user_name", "email", "password"}
-
-		if _, err := targetDB.Exec("TRUNCATE TABLE \"user\""); err != nil {
-			return fmt.Errorf("truncate user failed: %v", err)
-		}
-
-		for _, row := range data {
-			values := []interface{}{
-				row["id"],
-				row["user_name"],
-				row["email"],
-				row["password"],
-			}
-			placeholders := []string{"$1", "$2", "$3", "$4"}
-
-			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-				table,
-				strings.Join(columns, ", "),
-				strings.Join(placeholders, ", "))
-
-			if _, err := targetDB.Exec(query, values...); err != nil {
-				return fmt.Errorf("insert into %s failed: %v", table, err)
-			}
+	for _, st := range tables {
+		tx, err := st.shard.Begin(ctx)
+		if err != nil {
+			abort()
+			return err
 		}
-		return nil
+		txs = append(txs, tx)
 
-	} else if datasetName == "user_room_table" || datasetName == "document" {
-		// document 分片表，按 room_id hash 分片写入
-		columns = []string{"room_id", "room_name", "create_time", "overall_permission", "owner_user_id"}
-
-		// 先清空所有分片
-		for _, t := range []struct {
-			db    *sql.DB
-			table string
-		}{
-			{db.DBOg1, "document_0"},
-			{db.DBOg2, "document_1"},
-		} {
-			if _, err := t.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", t.table)); err != nil {
-				return fmt.Errorf("truncate %s failed: %v", t.table, err)
-			}
+		if err := tx.Truncate(ctx, st.table); err != nil {
+			abort()
+			return fmt.Errorf("truncate %s failed: %w", st.table, err)
 		}
-		columns = []string{"room_id", "room_name", "create_time", "overall_permission", "owner_user_id"}
 
-		// 插入数据
-		for _, row := range data {
-			roomID, ok := row["room_id"].(string)
-			if !ok {
-				log.Printf("Skip row without valid room_id: %v", row)
-				continue
+		for _, row := range rowsByShard[st.shard.Name()] {
+			values := make(store.Row, len(t.columns))
+			for _, col := range t.columns {
+				values[col] = row[col]
 			}
-
-			shardDB, shardTable, err := getRoomShard("document", roomID)
-			if err != nil {
+			if err := t.checkValues("data", values); err != nil {
+				abort()
 				return err
 			}
-
-			values := []interface{}{
-				row["room_id"],
-				row["room_name"],
-				row["create_time"],
-				row["overall_permission"],
-				row["owner_user_id"],
-			}
-			placeholders := []string{"$1", "$2", "$3", "$4"}
-
-			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-				shardTable,
-				strings.Join(columns, ", "),
-				strings.Join(placeholders, ", "))
-
-			if _, err := shardDB.Exec(query, values...); err != nil {
-				return fmt.Errorf("insert into %s failed: %v", shardTable, err)
-			}
-		}
-		return nil
-
-	} else if datasetName == "room_permission_table" || datasetName == "permission" {
-		columns = []string{"room_id", "user_id", "permission"}
-
-		// 清空所有分片
-		for _, t := range []struct {
-			db    *sql.DB
-			table string
-		}{
-			{db.DBOg1, "permission_0"},
-			{db.DBOg2, "permission_1"},
-		} {
-			if _, err := t.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", t.table)); err != nil {
-				return fmt.Errorf("truncate %s failed: %v", t.table, err)
+			if err := tx.Insert(ctx, st.table, values); err != nil {
+				abort()
+				return fmt.Errorf("insert into %s failed: %w", st.table, err)
 			}
 		}
 
-		// 插入数据（按 room_id 分片）
-		for _, row := range data {
-			roomID, ok := row["room_id"].(string)
-			if !ok {
-				log.Printf("Skip row without valid room_id: %v", row)
-				continue
+		// 整表替换 permission 时，user_rooms 在 UserShard 的事务中一并重建，随两阶段提交生效
+		if t.base == "permission" && st.shard.Name() == userShard(ctx).Name() {
+			var placed []map[string]interface{}
+			for _, rows := range rowsByShard {
+				placed = append(placed, rows...)
 			}
-
-			shardDB, shardTable, err := getRoomShard("permission", roomID)
-			if err != nil {
+			if err := rebuildIndex(ctx, tx, placed); err != nil {
+				abort()
 				return err
 			}
+		}
+	}
 
-			values := []interface{}{
-				row["room_id"],
-				row["user_id"],
-				row["permission"],
-			}
-			placeholders := []string{"$1", "$2", "$3"}
-
-			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-				shardTable,
-				strings.Join(columns, ", "),
-				strings.Join(placeholders, ", "))
+	txnID := fmt.Sprintf("writejson_%s_%d", t.base, time.Now().UnixNano())
+	return store.CommitTwoPhase(ctx, backend(ctx), txnID, txs)
+}
 
-			if _, err := shardDB.Exec(query, values...); err != nil {
-				return fmt.Errorf("insert into %s failed: %v", shardTable, err)
-			}
+// RemoveDatasetMainKey 删除 main_key = main_value 的行（main_key 可以是列名数组，对应 main_value 数组），
+// 没有匹配的行时返回 NotFound。删除期间连接中断（store.ErrResultUnknown）时在主库上查询是否还有匹配的行，
+// 没有则按成功处理
+func RemoveDatasetMainKey(ctx context.Context, datasetName string, mainKey interface{}, mainValue interface{}) error {
+	ctx = forWrite(ctx)
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return err
+	}
+	var where []store.Cond
+	switch k := mainKey.(type) {
+	case string:
+		if err := t.checkColumn("main_key", k); err != nil {
+			return err
 		}
-		return nil
+		where = []store.Cond{{Column: k, Value: mainValue}}
 
-	} else if datasetName == "room_content_table" || datasetName == "content" {
-		columns = []string{"room_id", "content"}
-
-		// 清空所有分片
-		for _, t := range []struct {
-			db    *sql.DB
-			table string
-		}{
-			{db.DBOg1, "content_0"},
-			{db.DBOg2, "content_1"},
-		} {
-			if _, err := t.db.Exec(fmt.Sprintf("TRUNCATE TABLE %s", t.table)); err != nil {
-				return fmt.Errorf("truncate %s failed: %v", t.table, err)
-			}
+	case []interface{}:
+		// 复合键，如 permission 的 [room_id, user_id]
+		vals, ok := mainValue.([]interface{})
+		if !ok || len(k) != len(vals) {
+			return Validation("mainKey and mainValue length mismatch")
 		}
-
-		// 插入数据（按 room_id 分片）
-		for _, row := range data {
-			roomID, ok := row["room_id"].(string)
-			if !ok {
-				log.Printf("Skip row without valid room_id: %v", row)
-				continue
-			}
-
-			shardDB, shardTable, err := getRoomShard("content", roomID)
-			if err != nil {
+		for i, col := range k {
+			name, _ := col.(string)
+			if err := t.checkColumn("main_key", name); err != nil {
 				return err
 			}
-
-			values := []interface{}{
-				row["room_id"],
-				row["content"],
-			}
-			placeholders := []string{"$1", "$2"}
-
-			query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
-				shardTable,
-				strings.Join(columns, ", "),
-				strings.Join(placeholders, ", "))
-
-			if _, err := shardDB.Exec(query, values...); err != nil {
-				return fmt.Errorf("insert into %s failed: %v", shardTable, err)
-			}
+			where = append(where, store.Cond{Column: name, Value: vals[i]})
 		}
-		return nil
 
-	} else {
-		return fmt.Errorf("unknown dataset: %s", datasetName)
+	default:
+		return Validation("unsupported mainKey type")
+	}
+	if len(where) == 0 {
+		return Validation("main_key must not be empty")
+	}
+	if err := t.checkConds("main_value", where); err != nil {
+		return err
 	}
-}
-
-func RemoveDatasetMainKey(datasetName string, mainKey interface{}, mainValue interface{}) error {
-	var targetDB *sql.DB
-	var table string
-	var whereClause string
-	var values []interface{}
-
-	if strings.HasPrefix(datasetName, "user") {
 
-		idStr, ok := mainValue.(string)
-		if !ok {
-			return fmt.Errorf("user table requires string id for deletion")
+	// 按分片键删除时只涉及一个分片，其他条件需要删除所有分片上匹配的行
+	tables, err := targetTables(ctx, t, where)
+	if err != nil {
+		return err
+	}
+	if err := checkRoomsWritable(ctx, t, tables, where); err != nil {
+		return err
+	}
+	var deleted int64
+	confirmed := false
+	if t.base == "permission" {
+		// user_rooms 中被删除的记录与所有分片上的删除一起提交
+		if deleted, err = deletePermissions(ctx, tables, where); err != nil {
+			return fmt.Errorf("delete failed: %w", err)
 		}
-
-		targetDB = db.DBOg1
-		table = "\"user\""
-
-		whereClause = "id = $1"
-		values = append(values, idStr)
-
-	} else if datasetName == "permission" {
-		switch k := mainKey.(type) {
-		case []interface{}:
-			// 输入了两个 key: [room_id, user_id]
-			if len(k) != 2 {
-				return fmt.Errorf("permission delete requires 2-element key or single room_id")
-			}
-			vals, ok := mainValue.([]interface{})
-			if !ok || len(vals) != 2 {
-				return fmt.Errorf("permission delete requires 2-element value for [room_id, user_id]")
-			}
-			roomID, ok := vals[0].(string)
-			if !ok {
-				return fmt.Errorf("room_id must be string")
-			}
-			var err error
-			targetDB, table, err = getRoomShard("permission", roomID)
-			if err != nil {
-				return err
-			}
-			whereClause = "room_id = $1 AND user_id = $2"
-			values = append(values, vals[0], vals[1])
-
-		case string:
-			// 输入单个 room_id
-			roomID, ok := mainValue.(string)
-			if !ok {
-				return fmt.Errorf("room_id must be string")
+	} else {
+		for _, st := range tables {
+			n, err := st.shard.Delete(ctx, st.table, where)
+			if errors.Is(err, store.ErrResultUnknown) {
+				var left int64
+				if left, err = recheck(ctx, st, where, err); err == nil && left > 0 {
+					err = ShardUnavailable("%d %s row(s) still match after an interrupted delete, retry", left, t.base)
+				}
+				// 删除是否命中了行已无从得知，不再返回 NotFound
+				confirmed = err == nil
 			}
-			var err error
-			targetDB, table, err = getRoomShard("permission", roomID)
 			if err != nil {
-				return err
+				return fmt.Errorf("delete failed: %w", err)
 			}
-			whereClause = "room_id = $1"
-			values = append(values, roomID)
-
-		default:
-			return fmt.Errorf("invalid key type for permission table")
+			deleted += n
 		}
-	} else if datasetName == "document" || datasetName == "content" {
+	}
 
-		roomID, ok := mainValue.(string)
-		if !ok {
-			return fmt.Errorf("%s requires string room_id", datasetName)
-		}
+	if deleted == 0 && !confirmed {
+		return NotFound("no %s row with %s", t.base, condString(where))
+	}
+	return nil
+}
 
-		var err error
-		targetDB, table, err = getRoomShard(datasetName, roomID)
+// deletePermissions 删除 tables 上满足 where 的 permission 行，并删除被删除的行在 user_rooms 中的记录。
+// 所有分片上的删除和 user_rooms 的修改在一个（两阶段）事务中提交：某个分片失败时全部回滚，
+// 不会出现 permission 还在而 user_rooms 中的记录已经删除的情况
+func deletePermissions(ctx context.Context, tables []shardTable, where []store.Cond) (int64, error) {
+	txs := make(map[string]store.Tx)
+	var order []store.Tx
+	begin := func(s store.Shard) (store.Tx, error) {
+		if tx, ok := txs[s.Name()]; ok {
+			return tx, nil
+		}
+		tx, err := s.Begin(ctx)
 		if err != nil {
-			return err
+			return nil, err
 		}
+		txs[s.Name()] = tx
+		order = append(order, tx)
+		return tx, nil
+	}
+	abort := func() {
+		for _, tx := range order {
+			tx.Rollback()
+		}
+	}
 
-		whereClause = "room_id = $1"
-		values = append(values, roomID)
-
-	} else {
-		targetDB = db.DBOg1
-		table = datasetName
-
-		switch k := mainKey.(type) {
-		case string:
-			whereClause = fmt.Sprintf("%s = $1", k)
-			values = append(values, mainValue)
-
-		case []interface{}:
-			vals, ok := mainValue.([]interface{})
-			if !ok || len(k) != len(vals) {
-				return fmt.Errorf("mainKey and mainValue length mismatch")
-			}
+	var n int64
+	var removed []store.Row
+	for _, st := range tables {
+		tx, err := begin(st.shard)
+		if err != nil {
+			abort()
+			return 0, err
+		}
+		rows, err := store.Select(ctx, tx, store.Query{Table: st.table, Columns: []string{"room_id", "user_id"}, Where: where})
+		if err != nil {
+			abort()
+			return 0, err
+		}
+		k, err := tx.Delete(ctx, st.table, where)
+		if err != nil {
+			abort()
+			return 0, err
+		}
+		n += k
+		removed = append(removed, rows...)
+	}
 
-			conds := make([]string, len(k))
-			for i := range k {
-				keyStr, ok := k[i].(string)
-				if !ok {
-					return fmt.Errorf("mainKey element is not string")
-				}
-				conds[i] = fmt.Sprintf("%s = $%d", keyStr, i+1)
-				values = append(values, vals[i])
-			}
-			whereClause = strings.Join(conds, " AND ")
+	index, err := begin(userShard(ctx))
+	if err != nil {
+		abort()
+		return 0, err
+	}
+	for _, row := range removed {
+		key := []store.Cond{{Column: "user_id", Value: row["user_id"]}, {Column: "room_id", Value: row["room_id"]}}
+		if err := unindexPermissions(ctx, index, key); err != nil {
+			abort()
+			return 0, err
+		}
+	}
 
-		default:
-			return fmt.Errorf("unsupported mainKey type")
+	if len(order) == 1 {
+		if err := order[0].Commit(); err != nil {
+			return 0, fmt.Errorf("commit on %s failed: %w", order[0].Shard().Name(), err)
 		}
+		return n, nil
 	}
+	txnID := fmt.Sprintf("userrooms_%d", time.Now().UnixNano())
+	return n, store.CommitTwoPhase(ctx, backend(ctx), txnID, order)
+}
 
-	query := fmt.Sprintf("DELETE FROM %s WHERE %s", table, whereClause)
-	_, err := targetDB.Exec(query, values...)
+// recheck 写入结果未知时在主库上查询 st 中匹配 where 的行数；查询失败时返回原来的错误 cause
+func recheck(ctx context.Context, st shardTable, where []store.Cond, cause error) (int64, error) {
+	n, err := st.shard.Count(store.ReadFromPrimary(ctx), st.table, where)
 	if err != nil {
-		return fmt.Errorf("delete failed: %v", err)
+		return 0, fmt.Errorf("%w (recheck failed: %v)", cause, err)
 	}
+	return n, nil
+}
 
-	return nil
+// updatedConds 返回 where 在按 set 更新之后对应的条件：被更新列上的条件换成更新后的值
+func updatedConds(where []store.Cond, set store.Row) []store.Cond {
+	conds := make([]store.Cond, 0, len(where)+len(set))
+	for _, c := range where {
+		if _, ok := set[c.Column]; !ok {
+			conds = append(conds, c)
+		}
+	}
+	for col, v := range set {
+		conds = append(conds, store.Cond{Column: col, Value: v})
+	}
+	return conds
+}
+
+// condString 把条件格式化为 "room_id=r1, user_id=u1"，用于错误信息
+func condString(where []store.Cond) string {
+	parts := make([]string, len(where))
+	for i, c := range where {
+		parts[i] = fmt.Sprintf("%s=%v", c.Column, c.Value)
+	}
+	return strings.Join(parts, ", ")
 }
@@ -0,0 +1,96 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+
+	"my-gauss-app/store"
+)
+
+// Code 错误类别，HTTP 响应中以 error.code 返回，客户端据此区分错误而不必解析 message
+type Code string
+
+const (
+	// CodeNotFound 请求的行或房间不存在（404）
+	CodeNotFound Code = "not_found"
+	// CodeConflict 主键或唯一约束冲突（409）
+	CodeConflict Code = "conflict"
+	// CodeValidation 请求参数不合法：未知的数据集或列、值与列类型不符、缺少字段等（400）
+	CodeValidation Code = "validation"
+	// CodeShardUnavailable 分片被熔断或连不上，或房间正在迁移暂时不能写入（503）
+	CodeShardUnavailable Code = "shard_unavailable"
+	// CodeInternal 其他错误（500）
+	CodeInternal Code = "internal"
+)
+
+// Error 带类别的错误。Err 为底层错误（可以为 nil），errors.Is / errors.As 可以穿过它检查
+type Error struct {
+	Code    Code
+	Message string
+	Err     error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NotFound 返回 CodeNotFound 类别的错误
+func NotFound(format string, args ...interface{}) error {
+	return &Error{Code: CodeNotFound, Message: fmt.Sprintf(format, args...)}
+}
+
+// Conflict 返回 CodeConflict 类别的错误
+func Conflict(format string, args ...interface{}) error {
+	return &Error{Code: CodeConflict, Message: fmt.Sprintf(format, args...)}
+}
+
+// Validation 返回 CodeValidation 类别的错误
+func Validation(format string, args ...interface{}) error {
+	return &Error{Code: CodeValidation, Message: fmt.Sprintf(format, args...)}
+}
+
+// ShardUnavailable 返回 CodeShardUnavailable 类别的错误
+func ShardUnavailable(format string, args ...interface{}) error {
+	return &Error{Code: CodeShardUnavailable, Message: fmt.Sprintf(format, args...)}
+}
+
+// Internal 返回 CodeInternal 类别的错误
+func Internal(format string, args ...interface{}) error {
+	return &Error{Code: CodeInternal, Message: fmt.Sprintf(format, args...)}
+}
+
+// ErrorCode 返回错误的类别：错误链中有 *Error 时取最外层的 Code；
+// 否则 *FieldError、ErrInvalidFilter 为 validation，主键冲突为 conflict，
+// 分片不可用（多分片查询时失败的分片全部不可用）、房间正在迁移或写入结果未知（可以确认后重试）为 shard_unavailable，
+// 其余为 internal
+func ErrorCode(err error) Code {
+	var e *Error
+	var fieldErr *FieldError
+	var scatterErr *ScatterError
+	switch {
+	case errors.As(err, &e):
+		return e.Code
+	case errors.As(err, &scatterErr):
+		// 多分片查询：失败的分片都不可用时为 shard_unavailable
+		for _, se := range scatterErr.Errors {
+			if !errors.Is(se, store.ErrShardUnavailable) {
+				return CodeInternal
+			}
+		}
+		return CodeShardUnavailable
+	case errors.As(err, &fieldErr), errors.Is(err, ErrInvalidFilter):
+		return CodeValidation
+	case errors.Is(err, store.ErrDuplicateKey):
+		return CodeConflict
+	case errors.Is(err, store.ErrShardUnavailable), errors.Is(err, store.ErrRoomMigrating), errors.Is(err, store.ErrResultUnknown):
+		return CodeShardUnavailable
+	}
+	return CodeInternal
+}
@@ -0,0 +1,201 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"my-gauss-app/store"
+)
+
+// ErrInvalidFilter filter 参数不符合过滤表达式的语法
+var ErrInvalidFilter = errors.New("invalid filter")
+
+// maxFilterDepth 过滤表达式的最大嵌套层数
+const maxFilterDepth = 16
+
+// ParseFilter 解析 JSON 过滤表达式：
+//
+//	{"and": [条件, ...]}  {"or": [条件, ...]}
+//	{"column": "user_id", "op": "=", "value": "u1"}
+//
+// op 为 =、!=、<、>、in（value 为数组）、like、ilike、is_null（value 可省略，false 表示 IS NOT NULL）。
+// 这里只检查语法，列名由 ReadRowsFilter 按数据集检查
+func ParseFilter(data []byte) (*store.Expr, error) {
+	var node interface{}
+	if err := json.Unmarshal(data, &node); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	return parseFilterNode(node, 0)
+}
+
+func parseFilterNode(node interface{}, depth int) (*store.Expr, error) {
+	if depth >= maxFilterDepth {
+		return nil, fmt.Errorf("%w: nested deeper than %d levels", ErrInvalidFilter, maxFilterDepth)
+	}
+	obj, ok := node.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%w: expected an object, got %s", ErrInvalidFilter, jsonType(node))
+	}
+
+	for _, op := range []store.Op{store.OpAnd, store.OpOr} {
+		list, ok := obj[string(op)]
+		if !ok {
+			continue
+		}
+		if len(obj) != 1 {
+			return nil, fmt.Errorf("%w: %q must be the only key in its object", ErrInvalidFilter, op)
+		}
+		items, ok := list.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %q expects an array", ErrInvalidFilter, op)
+		}
+		e := &store.Expr{Op: op}
+		for _, item := range items {
+			arg, err := parseFilterNode(item, depth+1)
+			if err != nil {
+				return nil, err
+			}
+			e.Args = append(e.Args, arg)
+		}
+		return e, nil
+	}
+
+	for key := range obj {
+		if key != "column" && key != "op" && key != "value" {
+			return nil, fmt.Errorf("%w: unknown key %q", ErrInvalidFilter, key)
+		}
+	}
+	column, _ := obj["column"].(string)
+	if column == "" {
+		return nil, fmt.Errorf("%w: condition needs a \"column\"", ErrInvalidFilter)
+	}
+	opName, _ := obj["op"].(string)
+	value, hasValue := obj["value"]
+
+	e := &store.Expr{Op: store.Op(strings.ToLower(strings.TrimSpace(opName))), Column: column}
+	switch e.Op {
+	case store.OpEq, store.OpNe, store.OpLt, store.OpGt:
+		if !isScalar(value) {
+			return nil, fmt.Errorf("%w: %s %s expects a string, number or boolean value (use is_null for NULL)", ErrInvalidFilter, column, e.Op)
+		}
+		e.Value = value
+	case store.OpIn:
+		items, ok := value.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("%w: %s in expects an array value", ErrInvalidFilter, column)
+		}
+		for _, item := range items {
+			if !isScalar(item) {
+				return nil, fmt.Errorf("%w: %s in expects strings, numbers or booleans", ErrInvalidFilter, column)
+			}
+		}
+		e.Values = items
+	case store.OpLike, store.OpILike:
+		pattern, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s %s expects a string pattern", ErrInvalidFilter, column, e.Op)
+		}
+		e.Value = pattern
+	case store.OpIsNull:
+		if hasValue {
+			isNull, ok := value.(bool)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s is_null expects a boolean value", ErrInvalidFilter, column)
+			}
+			e.Not = !isNull
+		}
+	default:
+		return nil, fmt.Errorf("%w: unknown op %q", ErrInvalidFilter, opName)
+	}
+	return e, nil
+}
+
+func isScalar(v interface{}) bool {
+	switch v.(type) {
+	case string, float64, bool:
+		return true
+	}
+	return false
+}
+
+func jsonType(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// checkFilterColumns 检查表达式中的列都属于 t
+func checkFilterColumns(t tableSpec, e *store.Expr) error {
+	if e == nil {
+		return nil
+	}
+	if e.Op == store.OpAnd || e.Op == store.OpOr {
+		for _, arg := range e.Args {
+			if err := checkFilterColumns(t, arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return t.checkColumn("filter", e.Column)
+}
+
+// pinnedRooms 返回满足 e 的行可能属于的所有房间（分片键 key 的值）；
+// ok 为 false 表示 e 没有限定分片键，需要查询所有分片
+func pinnedRooms(e *store.Expr, key string) (rooms []string, ok bool) {
+	if e == nil {
+		return nil, false
+	}
+	switch e.Op {
+	case store.OpEq:
+		if id, isStr := e.Value.(string); isStr && e.Column == key {
+			return []string{id}, true
+		}
+	case store.OpIn:
+		if e.Column != key {
+			return nil, false
+		}
+		for _, v := range e.Values {
+			id, isStr := v.(string)
+			if !isStr {
+				return nil, false
+			}
+			rooms = append(rooms, id)
+		}
+		return rooms, true
+	case store.OpAnd:
+		// 任一子条件限定了分片键即可，取房间最少的一个
+		for _, arg := range e.Args {
+			if r, ok2 := pinnedRooms(arg, key); ok2 && (!ok || len(r) < len(rooms)) {
+				rooms, ok = r, true
+			}
+		}
+		return rooms, ok
+	case store.OpOr:
+		// 每个分支都限定了分片键时取并集
+		if len(e.Args) == 0 {
+			return nil, false
+		}
+		for _, arg := range e.Args {
+			r, ok2 := pinnedRooms(arg, key)
+			if !ok2 {
+				return nil, false
+			}
+			rooms = append(rooms, r...)
+		}
+		return rooms, true
+	}
+	return nil, false
+}
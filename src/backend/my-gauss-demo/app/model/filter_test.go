@@ -0,0 +1,105 @@
+package model
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"my-gauss-app/store"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name string
+		in   string
+		want *store.Expr
+	}{
+		{
+			name: "comparison",
+			in:   `{"column": "owner_user_id", "op": "=", "value": "u1"}`,
+			want: &store.Expr{Op: store.OpEq, Column: "owner_user_id", Value: "u1"},
+		},
+		{
+			name: "op is case insensitive",
+			in:   `{"column": "overall_permission", "op": " > ", "value": 2}`,
+			want: &store.Expr{Op: store.OpGt, Column: "overall_permission", Value: float64(2)},
+		},
+		{
+			name: "in",
+			in:   `{"column": "room_id", "op": "in", "value": ["r1", "r2"]}`,
+			want: &store.Expr{Op: store.OpIn, Column: "room_id", Values: []interface{}{"r1", "r2"}},
+		},
+		{
+			name: "is_null without value",
+			in:   `{"column": "room_name", "op": "is_null"}`,
+			want: &store.Expr{Op: store.OpIsNull, Column: "room_name"},
+		},
+		{
+			name: "is_null false",
+			in:   `{"column": "room_name", "op": "is_null", "value": false}`,
+			want: &store.Expr{Op: store.OpIsNull, Column: "room_name", Not: true},
+		},
+		{
+			name: "nested and / or",
+			in: `{"and": [{"column": "owner_user_id", "op": "=", "value": "u1"},
+				{"or": [{"column": "room_name", "op": "ilike", "value": "%demo%"}, {"column": "room_name", "op": "is_null"}]}]}`,
+			want: &store.Expr{Op: store.OpAnd, Args: []*store.Expr{
+				{Op: store.OpEq, Column: "owner_user_id", Value: "u1"},
+				{Op: store.OpOr, Args: []*store.Expr{
+					{Op: store.OpILike, Column: "room_name", Value: "%demo%"},
+					{Op: store.OpIsNull, Column: "room_name"},
+				}},
+			}},
+		},
+		{
+			name: "empty and",
+			in:   `{"and": []}`,
+			want: &store.Expr{Op: store.OpAnd},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter([]byte(tt.in))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseFilter(%s) = %+v, want %+v", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterInvalid(t *testing.T) {
+	deep := `{"column": "a", "op": "=", "value": 1}`
+	for i := 0; i < maxFilterDepth; i++ {
+		deep = `{"and": [` + deep + `]}`
+	}
+
+	tests := map[string]string{
+		"not json":            `{`,
+		"not an object":       `[1]`,
+		"missing column":      `{"op": "=", "value": 1}`,
+		"unknown op":          `{"column": "a", "op": "~", "value": 1}`,
+		"unknown key":         `{"column": "a", "op": "=", "value": 1, "x": 1}`,
+		"null value":          `{"column": "a", "op": "=", "value": null}`,
+		"in without array":    `{"column": "a", "op": "in", "value": "x"}`,
+		"in with object":      `{"column": "a", "op": "in", "value": [{}]}`,
+		"like with number":    `{"column": "a", "op": "like", "value": 1}`,
+		"is_null with string": `{"column": "a", "op": "is_null", "value": "yes"}`,
+		"and with extra key":  `{"and": [], "column": "a"}`,
+		"or without array":    `{"or": {}}`,
+		"too deep":            deep,
+	}
+	for name, in := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseFilter([]byte(in))
+			if !errors.Is(err, ErrInvalidFilter) {
+				t.Errorf("ParseFilter(%s) error = %v, want ErrInvalidFilter", in, err)
+			}
+			if ErrorCode(err) != CodeValidation {
+				t.Errorf("ErrorCode = %s, want %s", ErrorCode(err), CodeValidation)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"my-gauss-app/store"
+)
+
+// Partial 收集一次请求中因分片不可用而缺失的分片。
+// 通过 AllowPartial 放入 ctx 后，全分片读取在部分分片不可用时返回其余分片的结果
+type Partial struct {
+	mu      sync.Mutex
+	missing map[string]bool
+}
+
+type partialKey struct{}
+
+// AllowPartial 允许 ctx 上的全分片读取返回部分结果，缺失的分片记录在返回的 Partial 中
+func AllowPartial(ctx context.Context) (context.Context, *Partial) {
+	p := &Partial{missing: make(map[string]bool)}
+	return context.WithValue(ctx, partialKey{}, p), p
+}
+
+func partialFrom(ctx context.Context) *Partial {
+	p, _ := ctx.Value(partialKey{}).(*Partial)
+	return p
+}
+
+func (p *Partial) add(shard string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.missing[shard] = true
+}
+
+// Degraded 结果是否缺少了部分分片
+func (p *Partial) Degraded() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.missing) > 0
+}
+
+// MissingShards 返回缺失的分片名（按名称排序）
+func (p *Partial) MissingShards() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	names := make([]string, 0, len(p.missing))
+	for name := range p.missing {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// isUnavailable 分片被熔断或连不上；只有这类错误可以降级为部分结果。
+// 超过截止时间的分片可能只是慢，降级会把不完整的结果当作 200 返回，按超时处理
+func isUnavailable(err error) bool {
+	return errors.Is(err, store.ErrShardUnavailable) && !errors.Is(err, context.DeadlineExceeded)
+}
+
+// Health 返回每个分片的健康状态；后端不做健康检查时（内存后端）所有分片视为健康
+func Health(ctx context.Context) []store.ShardHealth {
+	if hr, ok := backend(ctx).(store.HealthReporter); ok {
+		return hr.Health()
+	}
+	var result []store.ShardHealth
+	for _, s := range backend(ctx).Shards() {
+		result = append(result, store.ShardHealth{Shard: s.Name(), Healthy: true})
+	}
+	return result
+}
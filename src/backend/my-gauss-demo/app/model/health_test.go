@@ -0,0 +1,150 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// downShard 熔断打开的分片：所有读写立即返回 store.ErrShardUnavailable
+type downShard struct {
+	store.Shard
+}
+
+func (s downShard) err() error {
+	return fmt.Errorf("%w: %s: breaker open", store.ErrShardUnavailable, s.Name())
+}
+
+func (s downShard) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	return s.err()
+}
+
+func (s downShard) Count(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	return 0, s.err()
+}
+
+// downStore 分片 down 被熔断的后端
+type downStore struct {
+	store.Store
+	down string
+}
+
+func (s downStore) wrap(sh store.Shard) store.Shard {
+	if sh.Name() == s.down {
+		return downShard{sh}
+	}
+	return sh
+}
+
+func (s downStore) Shards() []store.Shard {
+	var shards []store.Shard
+	for _, sh := range s.Store.Shards() {
+		shards = append(shards, s.wrap(sh))
+	}
+	return shards
+}
+
+func (s downStore) ShardByName(name string) (store.Shard, bool) {
+	sh, ok := s.Store.ShardByName(name)
+	if !ok {
+		return nil, false
+	}
+	return s.wrap(sh), true
+}
+
+func (s downStore) Locate(key string) store.Shard { return s.wrap(s.Store.Locate(key)) }
+func (s downStore) UserShard() store.Shard        { return s.wrap(s.Store.UserShard()) }
+
+func TestScatterLookupWithShardDown(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+
+	// 每个分片上各有一个房间的权限
+	var rooms []string
+	for i := 0; len(rooms) < 2; i++ {
+		roomID := fmt.Sprintf("r%d", i)
+		if len(rooms) == 1 && s.Locate(roomID).Name() == s.Locate(rooms[0]).Name() {
+			continue
+		}
+		rooms = append(rooms, roomID)
+		err := InsertDataIntoDataset(ctx, "permission", map[string]interface{}{"room_id": roomID, "user_id": "u" + roomID, "permission": float64(1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	down := s.Locate(rooms[1]).Name()
+	Use(downStore{Store: s, down: down})
+
+	pctx, partial := AllowPartial(ctx)
+
+	// 可用分片上的行照常返回
+	if _, err := ReadDatasetCondition(pctx, "permission", "user_id", "u"+rooms[0], "*"); err != nil {
+		t.Errorf("lookup on the healthy shard failed: %v", err)
+	}
+
+	// 行在熔断的分片上：不能回答 not_found
+	_, err := ReadDatasetCondition(pctx, "permission", "user_id", "u"+rooms[1], "*")
+	wantCode(t, err, CodeShardUnavailable)
+	var scatterErr *ScatterError
+	if !errors.As(err, &scatterErr) || fmt.Sprint(scatterErr.FailedShards()) != fmt.Sprint([]string{down}) {
+		t.Errorf("err = %v, want a scatter error listing %s", err, down)
+	}
+	if !partial.Degraded() {
+		t.Error("partial result not marked degraded")
+	}
+
+	// 所有分片都健康时，没有命中仍是 not_found
+	Use(s)
+	pctx, _ = AllowPartial(ctx)
+	_, err = ReadDatasetCondition(pctx, "permission", "user_id", "nobody", "*")
+	wantCode(t, err, CodeNotFound)
+}
+
+// slowShard 查询一直等到 ctx 到期的分片：健康但是慢
+type slowShard struct {
+	store.Shard
+}
+
+func (s slowShard) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+// slowStore 分片 slow 很慢的后端
+type slowStore struct {
+	store.Store
+	slow string
+}
+
+func (s slowStore) Shards() []store.Shard {
+	var shards []store.Shard
+	for _, sh := range s.Store.Shards() {
+		if sh.Name() == s.slow {
+			sh = slowShard{sh}
+		}
+		shards = append(shards, sh)
+	}
+	return shards
+}
+
+func TestScatterTimeoutIsNotDegraded(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+	old := ScatterTimeout
+	ScatterTimeout = 20 * time.Millisecond
+	defer func() { ScatterTimeout = old }()
+
+	Use(slowStore{Store: s, slow: "og2"})
+	pctx, partial := AllowPartial(ctx)
+	_, err := ReadJSON(pctx, "permission")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("err = %v, want a deadline exceeded scatter error", err)
+	}
+	if partial.Degraded() {
+		t.Error("slow shard returned as a degraded partial result")
+	}
+}
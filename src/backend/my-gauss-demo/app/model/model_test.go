@@ -0,0 +1,777 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"my-gauss-app/config"
+	"my-gauss-app/store"
+	"my-gauss-app/store/memory"
+)
+
+// testShards 测试用的分片，og1 是 UserShard
+var testShards = []string{"og1", "og2"}
+
+func TestMain(m *testing.M) {
+	// 配置中声明的数据集：不分片的整数主键表、按 room_id 分片的表和按其他列分片的表（含整数分片键和 NUMERIC 列）
+	err := RegisterDatasets([]config.Dataset{
+		{
+			Name:       "counter",
+			Columns:    []config.Column{{Name: "id", Type: "INT"}, {Name: "n", Type: "INT"}},
+			PrimaryKey: []string{"id"},
+		},
+		{
+			Name:       "comment",
+			Columns:    []config.Column{{Name: "room_id", Type: "VARCHAR(64)"}, {Name: "seq", Type: "INT"}, {Name: "body", Type: "TEXT"}},
+			PrimaryKey: []string{"room_id", "seq"},
+			ShardBy:    "room_id",
+		},
+		{
+			Name:       "tag",
+			Columns:    []config.Column{{Name: "tag", Type: "VARCHAR(64)"}, {Name: "n", Type: "INT"}},
+			PrimaryKey: []string{"tag"},
+			ShardBy:    "tag",
+		},
+		{
+			Name:       "ticket",
+			Columns:    []config.Column{{Name: "id", Type: "INT"}, {Name: "note", Type: "TEXT"}},
+			PrimaryKey: []string{"id"},
+			ShardBy:    "id",
+		},
+		{
+			Name:       "item",
+			Columns:    []config.Column{{Name: "sku", Type: "VARCHAR(64)"}, {Name: "price", Type: "NUMERIC(10, 2)"}},
+			PrimaryKey: []string{"sku"},
+			ShardBy:    "sku",
+		},
+	})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Exit(m.Run())
+}
+
+// useMemory 换上一个空的内存后端
+func useMemory(t *testing.T) *memory.Store {
+	t.Helper()
+	s, err := memory.New(testShards, 0, TableDefs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	Use(s)
+	return s
+}
+
+// addUser 插入用户 id
+func addUser(t *testing.T, id string) {
+	t.Helper()
+	if err := InsertUser(context.Background(), User{ID: id}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// addRoom 以 owner 创建房间 roomID
+func addRoom(t *testing.T, roomID, owner string) {
+	t.Helper()
+	_, err := CreateRoom(context.Background(), Room{RoomID: roomID, RoomName: "room " + roomID, OwnerUserID: owner, Permission: 3, Content: "hello " + roomID})
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// otherShard 返回 s 以外的一个分片
+func otherShard(s store.Shard) store.Shard {
+	for _, sh := range backend(context.Background()).Shards() {
+		if sh.Name() != s.Name() {
+			return sh
+		}
+	}
+	return nil
+}
+
+func wantCode(t *testing.T, err error, code Code) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("got no error, want %s", code)
+	}
+	if got := ErrorCode(err); got != code {
+		t.Fatalf("ErrorCode(%v) = %s, want %s", err, got, code)
+	}
+}
+
+func TestDatasetCRUD(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	name := "alice"
+	if err := InsertDataIntoDataset(ctx, "user_table", map[string]interface{}{"id": "u1", "user_name": name}); err != nil {
+		t.Fatal(err)
+	}
+	got, err := ReadDataset(ctx, "user", "u1", "user_name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != name {
+		t.Errorf("user_name = %v, want %s", got, name)
+	}
+
+	err = InsertDataIntoDataset(ctx, "user", map[string]interface{}{"id": "u1"})
+	wantCode(t, err, CodeConflict)
+
+	if _, err := ModifyDatasetCondition(ctx, "user", "id", "u1", "email", "a@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadDatasetCondition(ctx, "user", "email", "a@example.com", "id"); err != nil || got != "u1" {
+		t.Errorf("ReadDatasetCondition = %v, %v, want u1", got, err)
+	}
+	_, err = ModifyDatasetCondition(ctx, "user", "id", "nobody", "email", "x")
+	wantCode(t, err, CodeNotFound)
+
+	if err := RemoveDatasetMainKey(ctx, "user", "id", "u1"); err != nil {
+		t.Fatal(err)
+	}
+	_, err = ReadDataset(ctx, "user", "u1", "*")
+	wantCode(t, err, CodeNotFound)
+	wantCode(t, RemoveDatasetMainKey(ctx, "user", "id", "u1"), CodeNotFound)
+}
+
+// interruptedShard 模拟执行期间连接中断的写入：executed 为 true 时语句已经生效，都返回 store.ErrResultUnknown
+type interruptedShard struct {
+	store.Shard
+	executed bool
+}
+
+func (s interruptedShard) Update(ctx context.Context, table string, set store.Row, where []store.Cond) (int64, error) {
+	if s.executed {
+		if _, err := s.Shard.Update(ctx, table, set, where); err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("update: %w", store.ErrResultUnknown)
+}
+
+func (s interruptedShard) Delete(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	if s.executed {
+		if _, err := s.Shard.Delete(ctx, table, where); err != nil {
+			return 0, err
+		}
+	}
+	return 0, fmt.Errorf("delete: %w", store.ErrResultUnknown)
+}
+
+// interruptedStore 所有分片都经过 interruptedShard 的后端
+type interruptedStore struct {
+	store.Store
+	executed bool
+}
+
+func (s interruptedStore) Shards() []store.Shard {
+	var shards []store.Shard
+	for _, sh := range s.Store.Shards() {
+		shards = append(shards, interruptedShard{sh, s.executed})
+	}
+	return shards
+}
+
+func (s interruptedStore) ShardByName(name string) (store.Shard, bool) {
+	sh, ok := s.Store.ShardByName(name)
+	if !ok {
+		return nil, false
+	}
+	return interruptedShard{sh, s.executed}, true
+}
+
+func (s interruptedStore) Locate(key string) store.Shard {
+	return interruptedShard{s.Store.Locate(key), s.executed}
+}
+func (s interruptedStore) UserShard() store.Shard {
+	return interruptedShard{s.Store.UserShard(), s.executed}
+}
+
+func TestWriteResultUnknownIsRechecked(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+
+	// 语句没有生效：修改和删除都不能按成功或 NotFound 处理
+	Use(interruptedStore{s, false})
+	if _, err := ModifyDatasetCondition(ctx, "user", "id", "u1", "email", "a@example.com"); !errors.Is(err, store.ErrResultUnknown) {
+		t.Errorf("Modify = %v, want ErrResultUnknown", err)
+	}
+	wantCode(t, RemoveDatasetMainKey(ctx, "user", "id", "u1"), CodeShardUnavailable)
+
+	// 语句已经生效：重新查询确认后按成功处理
+	Use(interruptedStore{s, true})
+	if _, err := ModifyDatasetCondition(ctx, "user", "id", "u1", "email", "a@example.com"); err != nil {
+		t.Errorf("Modify = %v, want success after recheck", err)
+	}
+	// 按被修改的列筛选
+	if _, err := ModifyDatasetCondition(ctx, "user", "email", "a@example.com", "email", "b@example.com"); err != nil {
+		t.Errorf("Modify by goal column = %v, want success after recheck", err)
+	}
+	if err := RemoveDatasetMainKey(ctx, "user", "id", "u1"); err != nil {
+		t.Errorf("Remove = %v, want success after recheck", err)
+	}
+
+	Use(s)
+	_, err := ReadDataset(ctx, "user", "u1", "*")
+	wantCode(t, err, CodeNotFound)
+}
+
+func TestDatasetValidation(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	_, err := ReadDataset(ctx, "no_such_dataset", "x", "*")
+	wantCode(t, err, CodeValidation)
+	if !errors.Is(err, ErrUnknownDataset) {
+		t.Errorf("err = %v, want ErrUnknownDataset", err)
+	}
+
+	_, err = ReadDataset(ctx, "user", "u1", "no_such_column")
+	wantCode(t, err, CodeValidation)
+	if !errors.Is(err, ErrUnknownColumn) {
+		t.Errorf("err = %v, want ErrUnknownColumn", err)
+	}
+
+	// 值与列类型不符
+	err = InsertDataIntoDataset(ctx, "counter", map[string]interface{}{"id": "one"})
+	wantCode(t, err, CodeValidation)
+
+	// 分片表缺少分片键
+	err = InsertDataIntoDataset(ctx, "tag", map[string]interface{}{"n": 1})
+	wantCode(t, err, CodeValidation)
+
+	// 分片键和主键不能原地修改
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+	_, err = ModifyDatasetCondition(ctx, "content", "room_id", "r1", "room_id", "r2")
+	wantCode(t, err, CodeValidation)
+	_, err = ModifyDatasetCondition(ctx, "permission", "room_id", "r1", "user_id", "u2")
+	wantCode(t, err, CodeValidation)
+	if got, err := ReadDataset(ctx, "content", "r1", "content"); err != nil || got != "hello r1" {
+		t.Errorf("content of r1 after rejected modify = %v, %v", got, err)
+	}
+}
+
+func TestIntegerShardKeyRoundTrip(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+
+	// JSON 中的数字和字符串形式的整数定位到同一个分片
+	if err := InsertDataIntoDataset(ctx, "ticket", map[string]interface{}{"id": float64(5), "note": "a"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertDataIntoDataset(ctx, "ticket", map[string]interface{}{"id": "7", "note": "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := s.Locate("5").Count(ctx, s.Locate("5").Table("ticket"), []store.Cond{{Column: "id", Value: int64(5)}}); n != 1 {
+		t.Errorf("ticket 5 not on %s", s.Locate("5").Name())
+	}
+	for _, key := range []interface{}{"5", float64(5)} {
+		if got, err := ReadDataset(ctx, "ticket", key, "note"); err != nil || got != "a" {
+			t.Errorf("ReadDataset(ticket, %v) = %v, %v, want a", key, got, err)
+		}
+	}
+
+	if _, err := ModifyDatasetCondition(ctx, "ticket", "id", "7", "note", "c"); err != nil {
+		t.Fatal(err)
+	}
+	if result, err := UpsertDataset(ctx, "ticket", map[string]interface{}{"id": float64(7), "note": "d"}, nil); err != nil || result != store.UpsertUpdated {
+		t.Errorf("UpsertDataset(ticket 7) = %v, %v, want updated", result, err)
+	}
+	if got, err := ReadDataset(ctx, "ticket", float64(7), "note"); err != nil || got != "d" {
+		t.Errorf("ticket 7 note = %v, %v, want d", got, err)
+	}
+
+	// 迁移按同样的文本形式计算目标分片，已经在目标分片上的行不动
+	if moved, err := rebalanceKeyed(ctx, datasets["ticket"], false); err != nil || moved != 0 {
+		t.Errorf("rebalanceKeyed(ticket) = %d, %v, want 0", moved, err)
+	}
+
+	if err := RemoveDatasetMainKey(ctx, "ticket", "id", "5"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := ReadDataset(ctx, "ticket", "5", "*")
+	wantCode(t, err, CodeNotFound)
+}
+
+func TestUpsertDataset(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	result, err := UpsertDataset(ctx, "counter", map[string]interface{}{"id": float64(1), "n": float64(1)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != store.UpsertInserted {
+		t.Errorf("first upsert = %v, want inserted", result)
+	}
+
+	result, err = UpsertDataset(ctx, "counter", map[string]interface{}{"id": float64(1), "n": float64(2)}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result != store.UpsertUpdated {
+		t.Errorf("second upsert = %v, want updated", result)
+	}
+
+	got, err := ReadDataset(ctx, "counter", float64(1), "n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(got) != "2" {
+		t.Errorf("n = %v, want 2", got)
+	}
+
+	// 空的 update_columns 保持已有的行不变
+	if result, err := UpsertDataset(ctx, "counter", map[string]interface{}{"id": float64(1), "n": float64(3)}, []string{}); err != nil || result != store.UpsertUnchanged {
+		t.Fatalf("upsert with no update columns = %v, %v, want unchanged", result, err)
+	}
+	if got, _ := ReadDataset(ctx, "counter", float64(1), "n"); fmt.Sprint(got) != "2" {
+		t.Errorf("n after upsert with no update columns = %v, want 2", got)
+	}
+
+	_, err = UpsertDataset(ctx, "counter", map[string]interface{}{"n": float64(1)}, nil)
+	wantCode(t, err, CodeValidation)
+	_, err = UpsertDataset(ctx, "counter", map[string]interface{}{"id": float64(1), "n": float64(1)}, []string{"id"})
+	wantCode(t, err, CodeValidation)
+}
+
+func TestConcurrentUpsert(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	// 同一主键的并发 upsert 都成功，只有一个插入
+	const workers = 20
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	inserts := 0
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			result, err := UpsertDataset(ctx, "counter", map[string]interface{}{"id": float64(7), "n": float64(i)}, nil)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if result == store.UpsertInserted {
+				mu.Lock()
+				inserts++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if inserts != 1 {
+		t.Errorf("%d upsert(s) reported an insert, want 1", inserts)
+	}
+}
+
+func TestUpsertPermissionUpdatesUserRooms(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addUser(t, "u2")
+	addRoom(t, "r1", "u1")
+
+	for _, perm := range []float64{1, 2} {
+		if _, err := UpsertDataset(ctx, "permission", map[string]interface{}{"room_id": "r1", "user_id": "u2", "permission": perm}, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rooms, err := UserRooms(ctx, "u2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || fmt.Sprint(rooms[0]["permission"]) != "2" {
+		t.Errorf("UserRooms(u2) = %+v, want r1 with permission 2", rooms)
+	}
+}
+
+// failDeleteStore 分片 fail 上事务内的删除总是失败的后端
+type failDeleteStore struct {
+	store.Store
+	fail string
+}
+
+func (s failDeleteStore) Shards() []store.Shard {
+	var shards []store.Shard
+	for _, sh := range s.Store.Shards() {
+		if sh.Name() == s.fail {
+			sh = failDeleteShard{sh}
+		}
+		shards = append(shards, sh)
+	}
+	return shards
+}
+
+type failDeleteShard struct {
+	store.Shard
+}
+
+func (s failDeleteShard) Begin(ctx context.Context) (store.Tx, error) {
+	tx, err := s.Shard.Begin(ctx)
+	return failDeleteTx{tx}, err
+}
+
+type failDeleteTx struct {
+	store.Tx
+}
+
+func (tx failDeleteTx) Delete(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	return 0, errors.New("delete failed")
+}
+
+func TestRemovePermissionsAcrossShardsIsAtomic(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+	rooms := []string{"r1", otherRoom(t, "r1")}
+	for _, roomID := range rooms {
+		if err := InsertDataIntoDataset(ctx, "permission", map[string]interface{}{"room_id": roomID, "user_id": "u2", "permission": float64(1)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 不在 UserShard 上的分片删除失败：UserShard 上的删除和 user_rooms 的修改一起回滚
+	other := otherShard(s.UserShard()).Name()
+	Use(failDeleteStore{Store: s, fail: other})
+	if err := RemoveDatasetMainKey(ctx, "permission", "user_id", "u2"); err == nil {
+		t.Fatal("remove succeeded although a shard failed")
+	}
+	Use(s)
+	if rows, _ := UserRooms(ctx, "u2"); len(rows) != 2 {
+		t.Errorf("UserRooms(u2) after failed remove = %v, want both rooms", rows)
+	}
+	if rows, _ := ReadRowsFilter(ctx, "permission", nil, []string{"room_id"}, nil, 0); len(rows) != 2 {
+		t.Errorf("permission rows after failed remove = %v, want both", rows)
+	}
+
+	if err := RemoveDatasetMainKey(ctx, "permission", "user_id", "u2"); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := UserRooms(ctx, "u2"); len(rows) != 0 {
+		t.Errorf("UserRooms(u2) after remove = %v, want none", rows)
+	}
+}
+
+func TestWriteJSON(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	var data []map[string]interface{}
+	for i := 0; i < 20; i++ {
+		data = append(data, map[string]interface{}{"tag": fmt.Sprintf("t%02d", i), "n": float64(i)})
+	}
+	if err := WriteJSON(ctx, "tag", data); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := ReadJSON(ctx, "tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(data) {
+		t.Fatalf("ReadJSON returned %d rows, want %d", len(rows), len(data))
+	}
+
+	// 缺少分片键的行让整个请求失败，原数据保持不变
+	err = WriteJSON(ctx, "tag", []map[string]interface{}{{"tag": "new", "n": float64(1)}, {"n": float64(2)}})
+	wantCode(t, err, CodeValidation)
+	rows, err = ReadJSON(ctx, "tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(data) {
+		t.Errorf("ReadJSON after a rejected write returned %d rows, want %d", len(rows), len(data))
+	}
+
+	if err := WriteJSON(ctx, "tag", nil); err != nil {
+		t.Fatal(err)
+	}
+	if rows, _ := ReadJSON(ctx, "tag"); len(rows) != 0 {
+		t.Errorf("ReadJSON after writing no rows returned %d rows, want 0", len(rows))
+	}
+}
+
+func TestCreateAndDeleteRoom(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	rooms, err := UserRooms(ctx, "u1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rooms) != 1 || rooms[0]["room_id"] != "r1" {
+		t.Errorf("UserRooms(u1) = %v, want r1", rooms)
+	}
+	if got, err := ReadDataset(ctx, "content", "r1", "content"); err != nil || got != "hello r1" {
+		t.Errorf("content = %v, %v, want hello r1", got, err)
+	}
+
+	_, err = CreateRoom(ctx, Room{RoomID: "r1", OwnerUserID: "u1"})
+	wantCode(t, err, CodeConflict)
+	_, err = CreateRoom(ctx, Room{RoomID: "r2", OwnerUserID: "nobody"})
+	wantCode(t, err, CodeValidation)
+
+	// 配置中按 room_id 分片的数据集随房间一起删除
+	if err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1), "body": "hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	counts, err := DeleteRoom(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, base := range []string{"document", "permission", "content", "comment"} {
+		if counts[base] != 1 {
+			t.Errorf("deleted %d %s row(s), want 1", counts[base], base)
+		}
+	}
+	if rooms, _ := UserRooms(ctx, "u1"); len(rooms) != 0 {
+		t.Errorf("UserRooms(u1) after delete = %v, want none", rooms)
+	}
+	_, err = DeleteRoom(ctx, "r1")
+	wantCode(t, err, CodeNotFound)
+}
+
+func TestWriteToMigratingRoomIsRejected(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	s, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := directory(ctx).Fence(ctx, "r1", s.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	err = InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1)})
+	wantCode(t, err, CodeShardUnavailable)
+	if !errors.Is(err, store.ErrRoomMigrating) {
+		t.Errorf("err = %v, want ErrRoomMigrating", err)
+	}
+	// 读取不受迁移标记影响
+	if _, err := ReadDataset(ctx, "document", "r1", "*"); err != nil {
+		t.Errorf("read during migration failed: %v", err)
+	}
+
+	if err := directory(ctx).Unfence(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1)}); err != nil {
+		t.Errorf("write after unfence failed: %v", err)
+	}
+}
+
+func TestScatterWriteToMigratingRoomIsRejected(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addUser(t, "u2")
+	addRoom(t, "r1", "u1")
+	if err := InsertDataIntoDataset(ctx, "permission", map[string]interface{}{"room_id": "r1", "user_id": "u2", "permission": float64(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := directory(ctx).Fence(ctx, "r1", s.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	// 按 user_id 修改、删除不经过 room_id 定位，同样拒绝写入迁移中的房间
+	_, err = ModifyDatasetCondition(ctx, "permission", "user_id", "u2", "permission", float64(1))
+	if !errors.Is(err, store.ErrRoomMigrating) {
+		t.Errorf("modify by user_id: err = %v, want ErrRoomMigrating", err)
+	}
+	err = RemoveDatasetMainKey(ctx, "permission", "user_id", "u2")
+	if !errors.Is(err, store.ErrRoomMigrating) {
+		t.Errorf("remove by user_id: err = %v, want ErrRoomMigrating", err)
+	}
+	if got, err := ReadDataset(ctx, "permission", []interface{}{"r1", "u2"}, "permission"); err != nil || got != int64(2) {
+		t.Errorf("permission during migration = %v, %v, want 2", got, err)
+	}
+
+	if err := directory(ctx).Unfence(ctx, "r1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ModifyDatasetCondition(ctx, "permission", "user_id", "u2", "permission", float64(1)); err != nil {
+		t.Errorf("modify after unfence failed: %v", err)
+	}
+	if err := RemoveDatasetMainKey(ctx, "permission", "user_id", "u2"); err != nil {
+		t.Errorf("remove after unfence failed: %v", err)
+	}
+}
+
+// directoryDownStore 向 room_directory 登记房间总是失败的后端
+type directoryDownStore struct {
+	store.Store
+}
+
+func (s directoryDownStore) UserShard() store.Shard { return directoryDownShard{s.Store.UserShard()} }
+
+type directoryDownShard struct {
+	store.Shard
+}
+
+func (s directoryDownShard) Insert(ctx context.Context, table string, row store.Row) error {
+	if table == store.DirectoryTable.Name {
+		return errors.New("room_directory unavailable")
+	}
+	return s.Shard.Insert(ctx, table, row)
+}
+
+func TestRoomWriteFailsWhenRegistrationFails(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+
+	Use(directoryDownStore{s})
+	if _, err := CreateRoom(ctx, Room{RoomID: "r1", OwnerUserID: "u1"}); err == nil {
+		t.Error("CreateRoom succeeded without registering the room")
+	}
+	if err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r2", "seq": float64(1)}); err == nil {
+		t.Error("InsertDataIntoDataset succeeded without registering the room")
+	}
+	if _, err := UpsertDataset(ctx, "comment", map[string]interface{}{"room_id": "r3", "seq": float64(1)}, nil); err == nil {
+		t.Error("UpsertDataset succeeded without registering the room")
+	}
+	// 登记失败时什么也没有写入
+	for _, sh := range s.Shards() {
+		for _, roomID := range []string{"r1", "r2", "r3"} {
+			for _, base := range []string{"document", "permission", "content", "comment"} {
+				if n, _ := sh.Count(ctx, sh.Table(base), []store.Cond{{Column: "room_id", Value: roomID}}); n != 0 {
+					t.Errorf("%d %s row(s) of %s written on %s", n, base, roomID, sh.Name())
+				}
+			}
+		}
+	}
+
+	// 目录恢复后重试成功，房间已登记
+	Use(s)
+	addRoom(t, "r1", "u1")
+	if p := placement(t, "r1"); p == nil {
+		t.Error("r1 missing from directory after retry")
+	}
+}
+
+func TestBackfillDirectory(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+
+	// 引入目录之前写入的房间：数据在哈希环定位之外的分片上，目录中没有记录
+	ring := s.Locate("legacy")
+	placed := otherShard(ring)
+	if err := placed.Insert(ctx, placed.Table("document"), store.Row{"room_id": "legacy", "owner_user_id": "u1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadDataset(ctx, "document", "legacy", "*"); ErrorCode(err) != CodeNotFound {
+		t.Fatalf("ReadDataset before backfill = %v, want not found", err)
+	}
+
+	n, err := BackfillDirectory(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("BackfillDirectory registered %d room(s), want 1", n)
+	}
+	if got, err := ReadDataset(ctx, "document", "legacy", "owner_user_id"); err != nil || got != "u1" {
+		t.Errorf("ReadDataset after backfill = %v, %v, want u1", got, err)
+	}
+
+	// 已登记的房间不再重复登记
+	if n, err := BackfillDirectory(ctx); err != nil || n != 0 {
+		t.Errorf("second BackfillDirectory = %d, %v, want 0", n, err)
+	}
+}
+
+func TestEnterPinsGeneration(t *testing.T) {
+	useMemory(t)
+	addUser(t, "u1")
+
+	ctx, leave := Enter(context.Background())
+	next, err := memory.New(testShards, 0, TableDefs())
+	if err != nil {
+		t.Fatal(err)
+	}
+	drained := Swap(next)
+
+	// Enter 之后的请求继续使用换下的后端
+	if _, err := ReadDataset(ctx, "user", "u1", "id"); err != nil {
+		t.Errorf("pinned read failed: %v", err)
+	}
+	if _, err := ReadDataset(context.Background(), "user", "u1", "id"); ErrorCode(err) != CodeNotFound {
+		t.Errorf("read on the new backend = %v, want not found", err)
+	}
+
+	select {
+	case <-drained:
+		t.Fatal("old backend drained while a request was still in flight")
+	default:
+	}
+	leave()
+	select {
+	case <-drained:
+	case <-time.After(time.Second):
+		t.Fatal("old backend not drained after the last request left")
+	}
+}
+
+func TestResolvePrepared(t *testing.T) {
+	old := store.RecoverAfter
+	store.RecoverAfter = 20 * time.Millisecond
+	defer func() { store.RecoverAfter = old }()
+
+	s := useMemory(t)
+	ctx := context.Background()
+	sh := s.Shards()[1]
+	tx, err := sh.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Truncate(ctx, sh.Table("document")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Prepare(ctx, "ourdoc_abandoned_"+sh.Name()); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go ResolvePrepared(ctx, 5*time.Millisecond)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		txns, err := sh.PreparedTransactions(ctx, "ourdoc_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(txns) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("abandoned transaction still prepared: %v", txns)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
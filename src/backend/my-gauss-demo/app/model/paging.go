@@ -0,0 +1,136 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"my-gauss-app/store"
+)
+
+// MaxPageSize 分页读取时单页的最大行数
+const MaxPageSize = 1000
+
+// ErrInvalidCursor after 参数不是本服务返回的游标
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Page 一页数据；Next 为空表示已经读完
+type Page struct {
+	Rows []map[string]interface{} `json:"result"`
+	Next string                   `json:"next"`
+
+	// 部分分片不可用时由 handler 填写
+	Degraded      bool     `json:"degraded,omitempty"`
+	MissingShards []string `json:"missing_shards,omitempty"`
+}
+
+// ReadPage 按主键顺序分页读取整个数据集。
+// 每个分片各自按主键（文本列按 C 排序规则即字节序，整数、时间列按类型）取 after 之后的 limit 行，
+// 在内存中以同样的顺序归并后取前 limit 行，因此跨分片的顺序稳定、翻页不重不漏。
+// after 为上一页返回的 Next，空串表示从头开始
+func ReadPage(ctx context.Context, datasetName string, after string, limit int) (*Page, error) {
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return nil, err
+	}
+	if limit <= 0 || limit > MaxPageSize {
+		return nil, Validation("limit must be between 1 and %d", MaxPageSize)
+	}
+
+	q := store.Query{Columns: t.columns, OrderBy: t.pk, Bytewise: t.bytewise(t.pk), Limit: limit}
+	if after != "" {
+		if q.After, err = decodeCursor(t, after); err != nil {
+			return nil, err
+		}
+	}
+	rows, err := readRows(ctx, t, physicalTables(ctx, t), q)
+	if err != nil {
+		return nil, err
+	}
+
+	order := pkOrder(t)
+	sort.Slice(rows, func(i, j int) bool {
+		return compareOrder(rows[i], rows[j], order) < 0
+	})
+
+	page := &Page{Rows: rows}
+	if len(rows) >= limit {
+		page.Rows = rows[:limit]
+		page.Next = encodeCursor(t, page.Rows[limit-1])
+	}
+	if page.Rows == nil {
+		page.Rows = []map[string]interface{}{}
+	}
+	return page, nil
+}
+
+// StreamDataset 逐分片扫描整个数据集，每扫描到一行就按列类型转换后交给 fn，不在内存中累积结果。
+// ctx 取消（例如客户端断开）时停止扫描
+func StreamDataset(ctx context.Context, datasetName string, fn func(row map[string]interface{}) error) error {
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return err
+	}
+
+	p := partialFrom(ctx)
+	for _, st := range physicalTables(ctx, t) {
+		q := store.Query{Table: st.table, Columns: t.columns}
+		err := st.shard.Scan(ctx, q, func(row store.Row) error {
+			if err := t.decodeRow(row); err != nil {
+				return err
+			}
+			return fn(row)
+		})
+		if err != nil {
+			// 不可用的分片在扫描开始前就会失败，允许部分结果时跳过它
+			if p != nil && errors.Is(err, store.ErrShardUnavailable) {
+				p.add(st.shard.Name())
+				continue
+			}
+			return &ShardError{Shard: st.shard.Name(), Table: st.table, Err: err}
+		}
+	}
+	return nil
+}
+
+// rowKey 取出一行的主键值
+func rowKey(t tableSpec, row map[string]interface{}) []string {
+	key := make([]string, len(t.pk))
+	for i, pk := range t.pk {
+		key[i] = fmt.Sprint(row[pk])
+	}
+	return key
+}
+
+// encodeCursor / decodeCursor 游标是主键值 JSON 数组的 base64 编码，对调用方不透明。
+// 整数、时间主键在 JSON 中分别为数字和 RFC 3339 字符串，解码时按列类型还原，与数据库中的比较一致
+func encodeCursor(t tableSpec, row map[string]interface{}) string {
+	key := make([]interface{}, len(t.pk))
+	for i, col := range t.pk {
+		key[i] = row[col]
+	}
+	b, _ := json.Marshal(key)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(t tableSpec, cursor string) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var key []interface{}
+	if err := json.Unmarshal(b, &key); err != nil || len(key) != len(t.pk) {
+		return nil, ErrInvalidCursor
+	}
+	for i, col := range t.pk {
+		v, err := decodeValue(t.types[col], key[i])
+		if err != nil || v == nil {
+			return nil, ErrInvalidCursor
+		}
+		key[i] = v
+	}
+	return key, nil
+}
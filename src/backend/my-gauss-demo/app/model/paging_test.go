@@ -0,0 +1,168 @@
+package model
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// readAllPages 以每页 limit 行读完整个数据集，返回每行 col 列的值和页数
+func readAllPages(t *testing.T, dataset, col string, limit int) ([]string, int) {
+	t.Helper()
+	var got []string
+	pages := 0
+	after := ""
+	for {
+		page, err := ReadPage(context.Background(), dataset, after, limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pages++
+		if len(page.Rows) > limit {
+			t.Fatalf("page %d has %d rows, limit %d", pages, len(page.Rows), limit)
+		}
+		for _, row := range page.Rows {
+			got = append(got, fmt.Sprint(row[col]))
+		}
+		if page.Next == "" {
+			return got, pages
+		}
+		if pages > 100 {
+			t.Fatal("paging does not terminate")
+		}
+		after = page.Next
+	}
+}
+
+func TestReadPageAcrossShards(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+
+	var want []string
+	for i := 0; i < 25; i++ {
+		roomID := fmt.Sprintf("r%02d", i)
+		want = append(want, roomID)
+		if err := InsertDataIntoDataset(ctx, "document", map[string]interface{}{"room_id": roomID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 确认数据确实分布在多个分片上
+	for _, sh := range s.Shards() {
+		if n, _ := sh.Count(ctx, sh.Table("document"), nil); n == 0 {
+			t.Fatalf("no rooms on %s, the test needs rows on every shard", sh.Name())
+		}
+	}
+
+	got, pages := readAllPages(t, "document", "room_id", 10)
+	if pages != 3 {
+		t.Errorf("read %d pages, want 3", pages)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("paged rows = %v, want %v", got, want)
+	}
+}
+
+func TestReadPageIntKey(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	var want []string
+	for i := 1; i <= 25; i++ {
+		want = append(want, fmt.Sprint(i))
+		if err := InsertDataIntoDataset(ctx, "counter", map[string]interface{}{"id": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 整数主键按大小排序，而不是 1, 10, 11, ... 的字符串顺序
+	got, _ := readAllPages(t, "counter", "id", 7)
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("paged ids = %v, want %v", got, want)
+	}
+}
+
+func TestPagedAndStreamedRowsAreDecoded(t *testing.T) {
+	s := useMemory(t)
+	ctx := context.Background()
+	for i, price := range []float64{9.5, 10, 100} {
+		if err := InsertDataIntoDataset(ctx, "item", map[string]interface{}{"sku": fmt.Sprintf("sku%d", i), "price": price}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 分片以字符串返回 NUMERIC，三种读取方式都按列类型转换成同样的值
+	Use(recordingStore{s, &recorder{numericText: true}})
+	want, err := ReadJSON(ctx, "item")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prices := func(rows []map[string]interface{}) map[interface{}]interface{} {
+		m := make(map[interface{}]interface{})
+		for _, row := range rows {
+			m[row["sku"]] = row["price"]
+		}
+		return m
+	}
+	if p := prices(want); p["sku0"] != 9.5 {
+		t.Fatalf("read_json prices = %v, want float64 values", p)
+	}
+
+	page, err := ReadPage(ctx, "item", "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prices(page.Rows), prices(want)) {
+		t.Errorf("paged prices = %v, want %v", prices(page.Rows), prices(want))
+	}
+
+	var streamed []map[string]interface{}
+	err = StreamDataset(ctx, "item", func(row map[string]interface{}) error {
+		streamed = append(streamed, row)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(prices(streamed), prices(want)) {
+		t.Errorf("streamed prices = %v, want %v", prices(streamed), prices(want))
+	}
+}
+
+func TestReadPageExactMultiple(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+	for i := 1; i <= 10; i++ {
+		if err := InsertDataIntoDataset(ctx, "counter", map[string]interface{}{"id": float64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	got, pages := readAllPages(t, "counter", "id", 5)
+	if len(got) != 10 {
+		t.Errorf("read %d rows, want 10", len(got))
+	}
+	// 最后一页正好读满时还需要再读一次空页才知道结束
+	if pages != 3 {
+		t.Errorf("read %d pages, want 3", pages)
+	}
+}
+
+func TestReadPageInvalid(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	cursor := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	for _, after := range []string{"!!!", cursor("not json"), cursor(`["a","b"]`), cursor(`["one"]`), cursor(`[null]`)} {
+		if _, err := ReadPage(ctx, "counter", after, 10); !errors.Is(err, ErrInvalidCursor) {
+			t.Errorf("ReadPage(after=%q) error = %v, want ErrInvalidCursor", after, err)
+		}
+	}
+
+	for _, limit := range []int{0, -1, MaxPageSize + 1} {
+		_, err := ReadPage(ctx, "counter", "", limit)
+		wantCode(t, err, CodeValidation)
+	}
+}
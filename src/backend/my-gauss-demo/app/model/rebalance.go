@@ -0,0 +1,859 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// rebalanceJournalTable rebalance_journal 表结构，存放在 UserShard 上
+var rebalanceJournalTable = store.TableDef{
+	Name:       "rebalance_journal",
+	Columns:    []string{"room_id", "from_shard", "to_shard", "state", "updated_at"},
+	PrimaryKey: []string{"room_id"},
+}
+
+// RoomMove 一个需要迁移的房间：当前数据在 From 上，应迁往 To。
+// Pinned 为 true 时迁移后在目录中固定到 To
+type RoomMove struct {
+	RoomID string
+	From   store.Shard
+	To     store.Shard
+	Pinned bool
+}
+
+// RebalanceReport 一次重平衡的结果统计
+type RebalanceReport struct {
+	Planned int
+	Moved   int
+	Failed  int
+	Rows    map[string]int // 逻辑表 -> 迁移行数
+	// Repair 目标分片上已有数据、需要人工修复的房间
+	Repair []string
+}
+
+// errRoomSplit 目标分片上已有房间的行，且不是之前中断的复制写入的（如登记目录失败后写入落到了其他分片）。
+// 这些行可能是线上数据，迁移中止，房间留给人工修复
+var errRoomSplit = errors.New("room already has rows on the target shard, repair manually")
+
+// PlanRebalance 扫描所有分片，找出数据所在分片与目标分片不一致的房间。
+// 目录中固定（pinned）的房间以目录为目标，其余房间以哈希环为目标
+func PlanRebalance(ctx context.Context) ([]RoomMove, error) {
+	var moves []RoomMove
+	for _, s := range backend(ctx).Shards() {
+		err := scanRooms(ctx, s, func(roomID string) error {
+			target, pinned, err := rebalanceTarget(ctx, roomID)
+			if err != nil {
+				return err
+			}
+			if target.Name() != s.Name() {
+				moves = append(moves, RoomMove{RoomID: roomID, From: s, To: target, Pinned: pinned})
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return moves, nil
+}
+
+// BackfillDirectory 把目录中没有记录的房间登记到数据实际所在的分片，返回登记的房间数。
+// 引入房间目录之前按旧的字节和哈希写入的房间没有目录记录，按哈希环定位会找错分片，
+// 因此服务在处理请求之前执行一次。不可用的分片跳过（返回的错误列出这些分片）；
+// 数据分布在多个分片上的房间不登记，交给 check --repair
+func BackfillDirectory(ctx context.Context) (int, error) {
+	found := make(map[string][]string)
+	var failed []string
+	for _, s := range backend(ctx).Shards() {
+		err := scanRooms(ctx, s, func(roomID string) error {
+			found[roomID] = append(found[roomID], s.Name())
+			return nil
+		})
+		if err != nil {
+			log.Printf("Backfill room_directory: %v", err)
+			failed = append(failed, s.Name())
+		}
+	}
+
+	n := 0
+	for _, roomID := range sortedKeys(found) {
+		shards := found[roomID]
+		if len(shards) > 1 {
+			log.Printf("Backfill room_directory: room %s has rows on %s, run check --repair", roomID, strings.Join(shards, ","))
+			continue
+		}
+		p, err := directory(ctx).Lookup(ctx, roomID)
+		if err != nil {
+			return n, err
+		}
+		if p != nil {
+			continue
+		}
+		if err := directory(ctx).Register(ctx, roomID, shards[0]); err != nil {
+			return n, err
+		}
+		n++
+	}
+	if len(failed) > 0 {
+		return n, fmt.Errorf("rooms on %s were not backfilled", strings.Join(failed, ","))
+	}
+	return n, nil
+}
+
+// scanRooms 对分片 s 上出现在任一房间表中的每个 room_id 调用一次 fn
+func scanRooms(ctx context.Context, s store.Shard, fn func(roomID string) error) error {
+	seen := make(map[string]bool)
+	for _, t := range roomTables {
+		q := store.Query{Table: s.Table(t.base), Columns: []string{"room_id"}, Distinct: true}
+		err := s.Scan(ctx, q, func(row store.Row) error {
+			roomID := fmt.Sprint(row["room_id"])
+			if seen[roomID] {
+				return nil
+			}
+			seen[roomID] = true
+			return fn(roomID)
+		})
+		if err != nil {
+			return fmt.Errorf("scan %s on %s failed: %v", s.Table(t.base), s.Name(), err)
+		}
+	}
+	return nil
+}
+
+// rebalanceTarget 返回房间重平衡后应在的分片，以及它是否被固定
+func rebalanceTarget(ctx context.Context, roomID string) (store.Shard, bool, error) {
+	p, err := directory(ctx).Lookup(ctx, roomID)
+	if err != nil {
+		return nil, false, err
+	}
+	if p != nil && p.Pinned {
+		s, ok := backend(ctx).ShardByName(p.ShardName)
+		if !ok {
+			return nil, false, fmt.Errorf("room %s is pinned to unknown shard %s", roomID, p.ShardName)
+		}
+		return s, true, nil
+	}
+	return backend(ctx).Locate(roomID), false, nil
+}
+
+// Rebalance 将路由已变更的房间从旧分片迁移到新分片，每 RebalanceBatch 个房间一批。
+// 每个房间：在 room_directory 中标记为迁移中（暂停写入），等待缓存过期（同一批只等待一次）后在目标分片事务内复制房间表的行，
+// 校验目标行数且源数据未变后把目录切到新分片（仍保持迁移标记），在源分片事务内核对两边的行完全一致后删除原数据，
+// 最后取消迁移标记。进度记录在 rebalance_journal 中；迁移计划每次都根据实际数据重新计算，
+// 因此崩溃后直接重新执行即可从中断处继续。
+func Rebalance(ctx context.Context, dryRun bool) (*RebalanceReport, error) {
+	pending, err := pendingJournal(ctx)
+	if err != nil {
+		return nil, err
+	}
+	report := &RebalanceReport{Rows: make(map[string]int)}
+	if len(pending) > 0 {
+		rooms := make([]string, len(pending))
+		for i, m := range pending {
+			rooms[i] = m.RoomID
+		}
+		log.Printf("Rebalance: resuming %d unfinished room(s) from previous run: %s", len(pending), strings.Join(rooms, ", "))
+	}
+	if !dryRun {
+		for _, m := range pending {
+			if err := resumeMove(ctx, m, report); err != nil {
+				return report, err
+			}
+		}
+	}
+
+	moves, err := PlanRebalance(ctx)
+	if err != nil {
+		return report, err
+	}
+
+	report.Planned += len(moves)
+	log.Printf("Rebalance: %d room(s) to move", len(moves))
+
+	for start := 0; start < len(moves); start += RebalanceBatch {
+		if err := ctx.Err(); err != nil {
+			log.Printf("Rebalance: stopped after %d of %d room(s): %v", start, len(moves), err)
+			return report, err
+		}
+		batch := moves[start:min(start+RebalanceBatch, len(moves))]
+		prefix := func(i int) string {
+			m := batch[i]
+			return fmt.Sprintf("Rebalance [%d/%d] room %s: %s -> %s", start+i+1, len(moves), m.RoomID, m.From.Name(), m.To.Name())
+		}
+		if dryRun {
+			for i := range batch {
+				log.Printf("%s (dry run)", prefix(i))
+			}
+			continue
+		}
+		moveRooms(ctx, batch, func(i int, counts map[string]int, err error) {
+			recordMove(ctx, batch[i], prefix(i), report, counts, err)
+		})
+	}
+
+	// 按其他列分片的数据集不经过房间目录，逐个分片键按哈希环迁移
+	for _, t := range keyedTables() {
+		n, err := rebalanceKeyed(ctx, t, dryRun)
+		report.Rows[t.base] += n
+		if err != nil {
+			return report, err
+		}
+	}
+
+	return report, nil
+}
+
+// resumeMove 处理上次运行中未完成的房间：目录已经切到目标分片（仍在迁移中）时源数据可能还没删除，
+// 核对后删除并取消迁移标记；其余情况取消迁移标记，房间留在源分片，仍需迁移时会重新计划
+func resumeMove(ctx context.Context, m journalEntry, report *RebalanceReport) error {
+	p, err := directory(ctx).Lookup(ctx, m.RoomID)
+	if err != nil {
+		return err
+	}
+	if p == nil || !p.Migrating || p.ShardName != m.To {
+		return directory(ctx).Unfence(ctx, m.RoomID)
+	}
+
+	from, ok := backend(ctx).ShardByName(m.From)
+	to, ok2 := backend(ctx).ShardByName(m.To)
+	if !ok || !ok2 {
+		return fmt.Errorf("journal of room %s refers to unknown shard %s or %s", m.RoomID, m.From, m.To)
+	}
+	report.Planned++
+	runMove(ctx, RoomMove{RoomID: m.RoomID, From: from, To: to, Pinned: p.Pinned}, fmt.Sprintf("Rebalance room %s: %s -> %s (resumed)", m.RoomID, m.From, m.To), report)
+	return nil
+}
+
+// runMove 迁移一个房间并把结果记入 report
+func runMove(ctx context.Context, m RoomMove, prefix string, report *RebalanceReport) {
+	counts, err := moveRoom(ctx, m)
+	recordMove(ctx, m, prefix, report, counts, err)
+}
+
+// recordMove 把一个房间的迁移结果记入 report，失败时在 rebalance_journal 中记为 failed
+func recordMove(ctx context.Context, m RoomMove, prefix string, report *RebalanceReport, counts map[string]int, err error) {
+	if err != nil {
+		report.Failed++
+		if errors.Is(err, errRoomSplit) {
+			report.Repair = append(report.Repair, m.RoomID)
+		}
+		log.Printf("%s failed: %v", prefix, err)
+		// ctx 被取消时也要记下失败状态
+		recordFailure(context.WithoutCancel(ctx), m, err)
+		return
+	}
+
+	report.Moved++
+	var parts []string
+	for _, t := range roomTables {
+		report.Rows[t.base] += counts[t.base]
+		parts = append(parts, fmt.Sprintf("%s=%d", t.base, counts[t.base]))
+	}
+	log.Printf("%s done (%s)", prefix, strings.Join(parts, " "))
+}
+
+// rebalanceKeyBatch rebalanceKeyed 每次从源分片读取的分片键个数
+var rebalanceKeyBatch = 500
+
+// rebalanceKeyed 把 t 中分片键的哈希目标不是当前分片的行迁往目标分片，返回迁移的行数。
+// 按分片键分批（keyset 分页）读取源分片，每个分片键的行作为一个整体迁移（见 moveKey），
+// 不会把整张表读进内存；中断后重新执行即可继续
+func rebalanceKeyed(ctx context.Context, t tableSpec, dryRun bool) (int, error) {
+	moved := 0
+	for _, s := range backend(ctx).Shards() {
+		table := s.Table(t.base)
+		q := store.Query{
+			Table:    table,
+			Columns:  []string{t.shardKey},
+			Distinct: true,
+			OrderBy:  []string{t.shardKey},
+			Bytewise: t.bytewise([]string{t.shardKey}),
+			Limit:    rebalanceKeyBatch,
+		}
+		for {
+			rows, err := store.Select(ctx, s, q)
+			if err != nil {
+				return moved, fmt.Errorf("scan %s on %s failed: %v", table, s.Name(), err)
+			}
+			for _, row := range rows {
+				key, err := t.shardKeyOf(row[t.shardKey])
+				if err != nil {
+					return moved, fmt.Errorf("%s on %s: %v", table, s.Name(), err)
+				}
+				target := backend(ctx).Locate(key)
+				if target.Name() == s.Name() {
+					continue
+				}
+				if dryRun {
+					n, err := s.Count(ctx, table, []store.Cond{{Column: t.shardKey, Value: key}})
+					if err != nil {
+						return moved, fmt.Errorf("count %s on %s failed: %v", table, s.Name(), err)
+					}
+					log.Printf("Rebalance %s %s: %s -> %s (dry run)", t.base, key, s.Name(), target.Name())
+					moved += int(n)
+					continue
+				}
+				n, err := moveKey(ctx, t, s, target, key)
+				moved += n
+				if err != nil {
+					return moved, err
+				}
+			}
+			if len(rows) < rebalanceKeyBatch {
+				break
+			}
+			q.After = []interface{}{rows[len(rows)-1][t.shardKey]}
+		}
+	}
+	if moved > 0 {
+		log.Printf("Rebalance %s: %d row(s) moved", t.base, moved)
+	}
+	return moved, nil
+}
+
+// moveKey 把分片 from 上分片键为 key 的行迁往 to，返回删除的源行数。
+// 按哈希环写入的进程已经写到目标分片，因此：先复制到目标分片（已存在的主键保留目标分片上的行），
+// 再在源分片的事务内只删除目标分片上已有同一主键的行；复制之后才写入源分片的行留到下次执行
+func moveKey(ctx context.Context, t tableSpec, from, to store.Shard, key string) (int, error) {
+	byKey := []store.Cond{{Column: t.shardKey, Value: key}}
+	rows, err := store.Select(ctx, from, store.Query{Table: from.Table(t.base), Columns: t.columns, Where: byKey})
+	if err != nil {
+		return 0, fmt.Errorf("query %s on %s failed: %v", from.Table(t.base), from.Name(), err)
+	}
+
+	tx, err := to.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin on %s failed: %v", to.Name(), err)
+	}
+	if err := copyRows(ctx, tx, to.Table(t.base), t, rows); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit on %s failed: %v", to.Name(), err)
+	}
+
+	copied, err := store.Select(ctx, to, store.Query{Table: to.Table(t.base), Columns: t.pk, Where: byKey})
+	if err != nil {
+		return 0, fmt.Errorf("query %s on %s failed: %v", to.Table(t.base), to.Name(), err)
+	}
+	onTarget := rowsByKey(t, copied)
+
+	src, err := from.Begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("begin on %s failed: %v", from.Name(), err)
+	}
+	current, err := store.Select(ctx, src, store.Query{Table: from.Table(t.base), Columns: t.pk, Where: byKey})
+	if err != nil {
+		src.Rollback()
+		return 0, fmt.Errorf("query %s on %s failed: %v", from.Table(t.base), from.Name(), err)
+	}
+	deleted := 0
+	for _, row := range current {
+		if _, ok := onTarget[strings.Join(rowKey(t, row), "/")]; !ok {
+			continue
+		}
+		n, err := src.Delete(ctx, from.Table(t.base), pkConds(t, row))
+		if err != nil {
+			src.Rollback()
+			return 0, fmt.Errorf("delete from %s on %s failed: %v", from.Table(t.base), from.Name(), err)
+		}
+		deleted += int(n)
+	}
+	if err := src.Commit(); err != nil {
+		return 0, fmt.Errorf("commit on %s failed: %v", from.Name(), err)
+	}
+	return deleted, nil
+}
+
+// MoveRoom 将单个房间迁移到指定分片并固定在那里，不影响其他房间
+func MoveRoom(ctx context.Context, roomID string, shardName string) (map[string]int, error) {
+	target, ok := backend(ctx).ShardByName(shardName)
+	if !ok {
+		return nil, fmt.Errorf("unknown shard: %s", shardName)
+	}
+	current, err := roomShard(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.Name() == target.Name() {
+		// 数据已经在目标分片上，只需固定目录
+		return map[string]int{}, directory(ctx).Assign(ctx, roomID, target.Name(), true)
+	}
+
+	counts, err := moveRoom(ctx, RoomMove{RoomID: roomID, From: current, To: target, Pinned: true})
+	if err != nil {
+		recordFailure(context.WithoutCancel(ctx), RoomMove{RoomID: roomID, From: current, To: target}, err)
+		return nil, err
+	}
+	return counts, nil
+}
+
+// MoveFenceWait 房间标记为迁移中之后、开始复制之前的最短等待时间，让标记之前已经查到源分片的写入有时间完成。
+// 实际等待时间不少于 store.DirectoryCacheTTL，原因见其说明
+var MoveFenceWait = 2 * time.Second
+
+// fenceWait 标记房间为迁移中之后到复制之前实际等待的时间
+func fenceWait() time.Duration {
+	if MoveFenceWait > store.DirectoryCacheTTL {
+		return MoveFenceWait
+	}
+	return store.DirectoryCacheTTL
+}
+
+// RebalanceBatch 重平衡每批迁移的房间数。同一批房间一起标记为迁移中，只等待一次 fenceWait，
+// 之后逐个复制、切换目录并删除源数据，每个房间完成后立即恢复写入
+var RebalanceBatch = 100
+
+// moveStep 一批迁移中已经准备好的房间
+type moveStep struct {
+	RoomMove
+	// copy 为 true 时房间已在源分片上标记为迁移中，等待之后需要复制
+	copy bool
+	// redo 为 true 时先清掉目标分片上之前中断的复制留下的行
+	redo bool
+}
+
+// moveRoom 迁移单个房间，返回每张逻辑表迁移的行数，见 moveRooms
+func moveRoom(ctx context.Context, m RoomMove) (map[string]int, error) {
+	var counts map[string]int
+	var err error
+	moveRooms(ctx, []RoomMove{m}, func(_ int, c map[string]int, e error) {
+		counts, err = c, e
+	})
+	return counts, err
+}
+
+// moveRooms 迁移一批房间，每个房间完成或失败时以它在 moves 中的下标调用一次 done。
+// 复制期间和删除源数据期间房间都处于迁移中（写入被拒绝）：prepareMove 标记整批房间，等待 fenceWait 一次，
+// 然后逐个由 copyRoom 复制并把目录切到目标分片，dropSource 核对两边的行一致后删除源数据，最后取消迁移标记。
+// 复制失败时房间留在源分片；切换目录之后的失败只保留源分片上的行，房间留在已有完整数据的目标分片
+func moveRooms(ctx context.Context, moves []RoomMove, done func(i int, counts map[string]int, err error)) {
+	steps := make(map[int]moveStep)
+	wait := false
+	for i, m := range moves {
+		st, err := prepareMove(ctx, m)
+		if err != nil {
+			done(i, nil, err)
+			continue
+		}
+		steps[i] = st
+		wait = wait || st.copy
+	}
+
+	if wait {
+		select {
+		case <-ctx.Done():
+			for i := range moves {
+				if _, ok := steps[i]; ok {
+					unfence(ctx, moves[i].RoomID)
+					done(i, nil, ctx.Err())
+				}
+			}
+			return
+		case <-time.After(fenceWait()):
+		}
+	}
+
+	for i := range moves {
+		if st, ok := steps[i]; ok {
+			counts, err := finishMove(ctx, st)
+			done(i, counts, err)
+		}
+	}
+}
+
+// prepareMove 判断房间处于迁移的哪一步，需要复制时检查目标分片并把房间标记为迁移中
+func prepareMove(ctx context.Context, m RoomMove) (moveStep, error) {
+	st := moveStep{RoomMove: m}
+	p, err := directory(ctx).Lookup(ctx, m.RoomID)
+	if err != nil {
+		return st, err
+	}
+
+	atTarget := p != nil && p.ShardName == m.To.Name()
+	if atTarget && !p.Migrating {
+		// 目录指向目标分片但那里没有房间的 document（如一致性检查发现数据留在别处）时按普通迁移复制
+		n, err := m.To.Count(ctx, m.To.Table("document"), []store.Cond{{Column: "room_id", Value: m.RoomID}})
+		if err != nil {
+			return st, err
+		}
+		atTarget = n > 0
+	}
+
+	switch {
+	case atTarget && p.Migrating:
+		// 之前的迁移已经切换目录，在删除源数据前中断
+	case atTarget:
+		// 目录已经在目标分片上，源分片上残留了行：暂停写入后再核对
+		if err := directory(ctx).Fence(ctx, m.RoomID, m.To.Name()); err != nil {
+			return st, err
+		}
+	default:
+		st.copy = true
+		if st.redo, err = fenceCopy(ctx, m); err != nil {
+			unfence(ctx, m.RoomID)
+			return st, err
+		}
+	}
+	return st, nil
+}
+
+// finishMove 完成 prepareMove 准备好的房间：需要时复制并切换目录，然后删除源数据、取消迁移标记
+func finishMove(ctx context.Context, st moveStep) (map[string]int, error) {
+	m := st.RoomMove
+	if st.copy {
+		if err := copyRoom(ctx, m, st.redo); err != nil {
+			unfence(ctx, m.RoomID)
+			return nil, err
+		}
+	}
+
+	counts, err := dropSource(ctx, m)
+	if uerr := directory(ctx).Unfence(context.WithoutCancel(ctx), m.RoomID); uerr != nil {
+		if err == nil {
+			return nil, uerr
+		}
+		log.Printf("Rebalance: unfence room %s failed: %v", m.RoomID, uerr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeJournal(ctx, m, "done"); err != nil {
+		return nil, err
+	}
+	return counts, nil
+}
+
+// unfence 取消房间的迁移标记（ctx 被取消时也执行），失败只记录日志
+func unfence(ctx context.Context, roomID string) {
+	if err := directory(ctx).Unfence(context.WithoutCancel(ctx), roomID); err != nil {
+		log.Printf("Rebalance: unfence room %s failed: %v", roomID, err)
+	}
+}
+
+// fenceCopy 检查目标分片后把房间标记为从源分片迁出，返回是否需要清掉之前中断的复制留下的行。
+// 目标分片上已有该房间的行时，只有 rebalance_journal 记录了同一迁移未完成的复制才清掉重新复制，
+// 否则返回 errRoomSplit，不修改任何数据
+func fenceCopy(ctx context.Context, m RoomMove) (bool, error) {
+	redo, err := interruptedCopy(ctx, m)
+	if err != nil {
+		return false, err
+	}
+	if !redo {
+		if err := checkTargetEmpty(ctx, m.To, m); err != nil {
+			return false, err
+		}
+	}
+	if err := writeJournal(ctx, m, "copying"); err != nil {
+		return false, err
+	}
+	return redo, directory(ctx).Fence(ctx, m.RoomID, m.From.Name())
+}
+
+// copyRoom 在 fenceCopy 标记并等待 fenceWait 之后，把源分片上的行原样复制到目标分片，
+// 然后把目录切到目标分片（保持迁移标记）。redo 为 true 时先清掉目标分片上该房间的行
+func copyRoom(ctx context.Context, m RoomMove, redo bool) error {
+	// 读取源分片上的全部行
+	source, err := selectRoomData(ctx, m.From, m.RoomID)
+	if err != nil {
+		return err
+	}
+
+	// 在目标分片的一个事务内复制：先清掉之前中断的复制留下的行，目标分片上的房间数据与源分片完全一致
+	byRoom := []store.Cond{{Column: "room_id", Value: m.RoomID}}
+	tx, err := m.To.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin on %s failed: %v", m.To.Name(), err)
+	}
+	if !redo {
+		// 检查之后到标记生效之前仍可能有写入落到目标分片
+		if err := checkTargetEmpty(ctx, tx, m); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	for _, t := range roomTables {
+		if redo {
+			if _, err := tx.Delete(ctx, m.To.Table(t.base), byRoom); err != nil {
+				tx.Rollback()
+				return fmt.Errorf("clear %s on %s failed: %v", m.To.Table(t.base), m.To.Name(), err)
+			}
+		}
+		if err := copyRows(ctx, tx, m.To.Table(t.base), t, source[t.base]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("commit on %s failed: %v", m.To.Name(), err)
+	}
+
+	// 校验目标分片上的行数，并确认源分片在复制期间没有被修改
+	for _, t := range roomTables {
+		n, err := m.To.Count(ctx, m.To.Table(t.base), byRoom)
+		if err != nil {
+			return fmt.Errorf("verify %s on %s failed: %v", m.To.Table(t.base), m.To.Name(), err)
+		}
+		if n != int64(len(source[t.base])) {
+			return fmt.Errorf("verify %s on %s failed: expected %d rows, got %d", m.To.Table(t.base), m.To.Name(), len(source[t.base]), n)
+		}
+	}
+	again, err := selectRoomData(ctx, m.From, m.RoomID)
+	if err != nil {
+		return err
+	}
+	for _, t := range roomTables {
+		if !sameRows(t, source[t.base], again[t.base]) {
+			return fmt.Errorf("room %s changed on %s while copying", m.RoomID, m.From.Name())
+		}
+	}
+	if err := writeJournal(ctx, m, "copied"); err != nil {
+		return err
+	}
+
+	// 切换目录，之后的读取落到目标分片；写入在删除源数据之前仍被拒绝
+	return directory(ctx).Handover(ctx, m.RoomID, m.To.Name(), m.Pinned)
+}
+
+// checkTargetEmpty 确认目标分片（通过 e 读取）上没有房间的行，有则返回 errRoomSplit
+func checkTargetEmpty(ctx context.Context, e store.Executor, m RoomMove) error {
+	for _, t := range roomTables {
+		n, err := e.Count(ctx, m.To.Table(t.base), []store.Cond{{Column: "room_id", Value: m.RoomID}})
+		if err != nil {
+			return fmt.Errorf("count %s on %s failed: %v", m.To.Table(t.base), m.To.Name(), err)
+		}
+		if n > 0 {
+			return fmt.Errorf("room %s: %d %s row(s) on %s: %w", m.RoomID, n, t.base, m.To.Name(), errRoomSplit)
+		}
+	}
+	return nil
+}
+
+// dropSource 在源分片的一个事务内核对并删除房间的原数据，返回每张逻辑表删除的行数。
+// 调用方持有迁移标记，两边的数据都不会再变化；源分片上已没有该房间的行时（之前的删除已提交）直接返回
+func dropSource(ctx context.Context, m RoomMove) (map[string]int, error) {
+	byRoom := []store.Cond{{Column: "room_id", Value: m.RoomID}}
+	tx, err := m.From.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("begin on %s failed: %v", m.From.Name(), err)
+	}
+	var left int64
+	for _, t := range roomTables {
+		n, err := tx.Count(ctx, m.From.Table(t.base), byRoom)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("count %s on %s failed: %v", m.From.Table(t.base), m.From.Name(), err)
+		}
+		left += n
+	}
+	counts := make(map[string]int)
+	if left == 0 {
+		tx.Rollback()
+		return counts, nil
+	}
+
+	for _, t := range roomTables {
+		if err := verifyCopied(ctx, tx, m, t); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		n, err := tx.Delete(ctx, m.From.Table(t.base), byRoom)
+		if err != nil {
+			tx.Rollback()
+			return nil, fmt.Errorf("delete from %s on %s failed: %v", m.From.Table(t.base), m.From.Name(), err)
+		}
+		counts[t.base] = int(n)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("commit on %s failed: %v", m.From.Name(), err)
+	}
+	return counts, nil
+}
+
+// selectRoomData 读取房间在分片 s 上各张房间表的全部行
+func selectRoomData(ctx context.Context, s store.Shard, roomID string) (map[string][]store.Row, error) {
+	rows := make(map[string][]store.Row)
+	for _, t := range roomTables {
+		r, err := selectRoomRows(ctx, s, s.Table(t.base), t.columns, roomID)
+		if err != nil {
+			return nil, err
+		}
+		rows[t.base] = r
+	}
+	return rows, nil
+}
+
+// verifyCopied 在删除源数据的事务 tx 中核对房间在表 t 中的行：源分片与目标分片上的行按主键一一对应且内容相同，
+// 源分片上多出、缺少或不同的行都会使删除中止
+func verifyCopied(ctx context.Context, tx store.Tx, m RoomMove, t tableSpec) error {
+	rows, err := selectRoomRows(ctx, tx, m.From.Table(t.base), t.columns, m.RoomID)
+	if err != nil {
+		return err
+	}
+	target, err := selectRoomRows(ctx, m.To, m.To.Table(t.base), t.columns, m.RoomID)
+	if err != nil {
+		return err
+	}
+	if !sameRows(t, rows, target) {
+		return fmt.Errorf("room %s: %s rows on %s (%d) differ from %s (%d), keeping source rows", m.RoomID, t.base, m.From.Name(), len(rows), m.To.Name(), len(target))
+	}
+	return nil
+}
+
+// rowsByKey 按主键（各列取值以 / 连接）索引行
+func rowsByKey(t tableSpec, rows []store.Row) map[string]store.Row {
+	m := make(map[string]store.Row, len(rows))
+	for _, row := range rows {
+		m[strings.Join(rowKey(t, row), "/")] = row
+	}
+	return m
+}
+
+// sameRow 两行在 t 的所有列上取值相同
+func sameRow(t tableSpec, a, b store.Row) bool {
+	for _, col := range t.columns {
+		if fmt.Sprint(a[col]) != fmt.Sprint(b[col]) {
+			return false
+		}
+	}
+	return true
+}
+
+// sameRows 两组行按主键一一对应且内容相同
+func sameRows(t tableSpec, a, b []store.Row) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	byKey := rowsByKey(t, b)
+	for _, row := range a {
+		if c, ok := byKey[strings.Join(rowKey(t, row), "/")]; !ok || !sameRow(t, row, c) {
+			return false
+		}
+	}
+	return true
+}
+
+// selectRoomRows 读取某个房间在一张物理表上的所有行
+func selectRoomRows(ctx context.Context, s store.Executor, table string, columns []string, roomID string) ([]store.Row, error) {
+	rows, err := store.Select(ctx, s, store.Query{
+		Table:   table,
+		Columns: columns,
+		Where:   []store.Cond{{Column: "room_id", Value: roomID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query %s failed: %v", table, err)
+	}
+	return rows, nil
+}
+
+// copyRows 在事务内把行写入目标表，主键已存在的行保持不变（重复执行时幂等）
+func copyRows(ctx context.Context, tx store.Tx, table string, t tableSpec, rows []store.Row) error {
+	for _, row := range rows {
+		n, err := tx.Count(ctx, table, pkConds(t, row))
+		if err != nil {
+			return fmt.Errorf("check %s failed: %v", table, err)
+		}
+		if n > 0 {
+			continue
+		}
+		if err := tx.Insert(ctx, table, row); err != nil {
+			return fmt.Errorf("copy into %s failed: %v", table, err)
+		}
+	}
+	return nil
+}
+
+// pkConds 按 t 的主键定位 row 的条件
+func pkConds(t tableSpec, row store.Row) []store.Cond {
+	pk := make([]store.Cond, len(t.pk))
+	for i, col := range t.pk {
+		pk[i] = store.Cond{Column: col, Value: row[col]}
+	}
+	return pk
+}
+
+// writeJournal 记录房间迁移状态：copying -> copied -> done，失败为 failed
+func writeJournal(ctx context.Context, m RoomMove, state string) error {
+	tx, err := userShard(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("begin journal failed: %v", err)
+	}
+	table := rebalanceJournalTable.Name
+	if _, err := tx.Delete(ctx, table, []store.Cond{{Column: "room_id", Value: m.RoomID}}); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("write journal failed: %v", err)
+	}
+	err = tx.Insert(ctx, table, store.Row{
+		"room_id":    m.RoomID,
+		"from_shard": m.From.Name(),
+		"to_shard":   m.To.Name(),
+		"state":      state,
+		"updated_at": time.Now(),
+	})
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("write journal failed: %v", err)
+	}
+	return tx.Commit()
+}
+
+// interruptedCopy 返回 rebalance_journal 是否记录了迁移 m（同一源分片和目标分片）未完成的复制（copying 或 copied）
+func interruptedCopy(ctx context.Context, m RoomMove) (bool, error) {
+	row, err := store.First(store.ReadFromPrimary(ctx), userShard(ctx), store.Query{
+		Table:   rebalanceJournalTable.Name,
+		Columns: []string{"from_shard", "to_shard", "state"},
+		Where:   []store.Cond{{Column: "room_id", Value: m.RoomID}},
+	})
+	if err != nil {
+		return false, fmt.Errorf("read journal failed: %v", err)
+	}
+	if row == nil || row["from_shard"] != m.From.Name() || row["to_shard"] != m.To.Name() {
+		return false, nil
+	}
+	return row["state"] == "copying" || row["state"] == "copied", nil
+}
+
+// recordFailure 在 rebalance_journal 中把迁移记为 failed。复制中途失败时保留 copying/copied 记录，
+// 下次迁移据此清掉这次复制写到目标分片上的行；目标分片上已有其他数据（errRoomSplit）时一律记为 failed
+func recordFailure(ctx context.Context, m RoomMove, err error) {
+	if !errors.Is(err, errRoomSplit) {
+		if ok, jerr := interruptedCopy(ctx, m); jerr == nil && ok {
+			return
+		}
+	}
+	if jerr := writeJournal(ctx, m, "failed"); jerr != nil {
+		log.Printf("Rebalance: record journal for room %s failed: %v", m.RoomID, jerr)
+	}
+}
+
+// journalEntry rebalance_journal 中的一条记录
+type journalEntry struct {
+	RoomID string
+	From   string
+	To     string
+}
+
+// pendingJournal 返回上次运行中未完成（非 done）的房间
+func pendingJournal(ctx context.Context) ([]journalEntry, error) {
+	var entries []journalEntry
+	q := store.Query{Table: rebalanceJournalTable.Name, Columns: []string{"room_id", "from_shard", "to_shard", "state"}, OrderBy: []string{"room_id"}, Bytewise: []string{"room_id"}}
+	err := userShard(ctx).Scan(ctx, q, func(row store.Row) error {
+		if row["state"] != "done" {
+			entries = append(entries, journalEntry{RoomID: fmt.Sprint(row["room_id"]), From: fmt.Sprint(row["from_shard"]), To: fmt.Sprint(row["to_shard"])})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("read journal failed: %v", err)
+	}
+	return entries, nil
+}
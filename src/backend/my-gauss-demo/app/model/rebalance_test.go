@@ -0,0 +1,458 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// fastMoves 缩短迁移前的等待时间
+func fastMoves(t *testing.T) {
+	t.Helper()
+	oldWait, oldTTL := MoveFenceWait, store.DirectoryCacheTTL
+	MoveFenceWait, store.DirectoryCacheTTL = time.Millisecond, time.Millisecond
+	t.Cleanup(func() { MoveFenceWait, store.DirectoryCacheTTL = oldWait, oldTTL })
+}
+
+// roomRows 返回房间在分片 s 上 document、permission、content 的行数之和
+func roomRows(t *testing.T, s store.Shard, roomID string) int64 {
+	t.Helper()
+	var n int64
+	for _, base := range []string{"document", "permission", "content"} {
+		c, err := s.Count(context.Background(), s.Table(base), []store.Cond{{Column: "room_id", Value: roomID}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += c
+	}
+	return n
+}
+
+// placement 不经过缓存读取房间在目录中的记录
+func placement(t *testing.T, roomID string) *store.Placement {
+	t.Helper()
+	ctx := context.Background()
+	directory(ctx).Invalidate(roomID)
+	p, err := directory(ctx).Lookup(ctx, roomID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p == nil {
+		t.Fatalf("room %s is not in the directory", roomID)
+	}
+	return p
+}
+
+func TestMoveRoom(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+	if err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1), "body": "first"}); err != nil {
+		t.Fatal(err)
+	}
+
+	from, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := otherShard(from)
+	counts, err := MoveRoom(ctx, "r1", to.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if counts["document"] != 1 || counts["comment"] != 1 {
+		t.Errorf("moved %v, want one document and one comment", counts)
+	}
+
+	if p := placement(t, "r1"); p.ShardName != to.Name() || !p.Pinned || p.Migrating {
+		t.Fatalf("placement after move = %+v, want pinned to %s", p, to.Name())
+	}
+	if n := roomRows(t, from, "r1"); n != 0 {
+		t.Errorf("%d row(s) left on %s after move", n, from.Name())
+	}
+	if got, err := ReadDataset(ctx, "comment", []interface{}{"r1", float64(1)}, "body"); err != nil || got != "first" {
+		t.Errorf("comment after move = %v, %v, want first", got, err)
+	}
+	if rooms, _ := UserRooms(ctx, "u1"); len(rooms) != 1 {
+		t.Errorf("UserRooms(u1) after move = %v, want r1", rooms)
+	}
+}
+
+func TestMoveRoomWaitsForDirectoryCache(t *testing.T) {
+	fastMoves(t)
+	store.DirectoryCacheTTL = 50 * time.Millisecond
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	from, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if _, err := MoveRoom(ctx, "r1", otherShard(from).Name()); err != nil {
+		t.Fatal(err)
+	}
+	// 其他进程在标记之前缓存的位置过期之前不能切换目录
+	if elapsed := time.Since(start); elapsed < store.DirectoryCacheTTL {
+		t.Errorf("MoveRoom took %v, want at least the directory cache TTL %v", elapsed, store.DirectoryCacheTTL)
+	}
+}
+
+func TestRebalance(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+
+	// 目录指向哈希环以外分片的房间（未固定）要迁回哈希环定位的分片
+	rooms := []string{"r1", "r2", "r3"}
+	for _, roomID := range rooms {
+		addRoom(t, roomID, "u1")
+		ring := backend(ctx).Locate(roomID)
+		if _, err := MoveRoom(ctx, roomID, otherShard(ring).Name()); err != nil {
+			t.Fatal(err)
+		}
+		if err := directory(ctx).Assign(ctx, roomID, otherShard(ring).Name(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	report, err := Rebalance(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Planned != len(rooms) || report.Moved != 0 {
+		t.Errorf("dry run report = %+v, want %d planned and none moved", report, len(rooms))
+	}
+
+	report, err = Rebalance(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Moved != len(rooms) || report.Failed != 0 || report.Rows["document"] != len(rooms) {
+		t.Errorf("report = %+v, want %d rooms moved", report, len(rooms))
+	}
+	for _, roomID := range rooms {
+		ring := backend(ctx).Locate(roomID)
+		if p := placement(t, roomID); p.ShardName != ring.Name() || p.Migrating {
+			t.Errorf("placement of %s = %+v, want %s", roomID, p, ring.Name())
+		}
+		if n := roomRows(t, otherShard(ring), roomID); n != 0 {
+			t.Errorf("%d row(s) of %s left on %s", n, roomID, otherShard(ring).Name())
+		}
+		if got, err := ReadDataset(ctx, "content", roomID, "content"); err != nil || got != "hello "+roomID {
+			t.Errorf("content of %s = %v, %v", roomID, got, err)
+		}
+	}
+
+	// 已经平衡后再执行没有需要迁移的房间
+	if report, err := Rebalance(ctx, false); err != nil || report.Planned != 0 {
+		t.Errorf("second Rebalance = %+v, %v, want nothing planned", report, err)
+	}
+}
+
+func TestRebalanceWaitsOncePerBatch(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+
+	rooms := []string{"r1", "r2", "r3", "r4"}
+	for _, roomID := range rooms {
+		addRoom(t, roomID, "u1")
+		ring := backend(ctx).Locate(roomID)
+		if _, err := MoveRoom(ctx, roomID, otherShard(ring).Name()); err != nil {
+			t.Fatal(err)
+		}
+		if err := directory(ctx).Assign(ctx, roomID, otherShard(ring).Name(), false); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	store.DirectoryCacheTTL = 100 * time.Millisecond
+	start := time.Now()
+	report, err := Rebalance(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Moved != len(rooms) {
+		t.Fatalf("report = %+v, want %d rooms moved", report, len(rooms))
+	}
+	// 同一批房间一起标记，只等待一次目录缓存过期
+	elapsed := time.Since(start)
+	if elapsed < store.DirectoryCacheTTL || elapsed >= 2*store.DirectoryCacheTTL {
+		t.Errorf("Rebalance of %d rooms took %v, want one directory cache TTL %v", len(rooms), elapsed, store.DirectoryCacheTTL)
+	}
+}
+
+func TestRebalanceResumesAfterHandover(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+	addRoom(t, "r2", "u1")
+
+	// 模拟两次中断：r1 切换目录后、删除源数据前；r2 删除源数据后、取消迁移标记前
+	moves := make(map[string]RoomMove)
+	for _, roomID := range []string{"r1", "r2"} {
+		from, err := roomShard(ctx, roomID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := RoomMove{RoomID: roomID, From: from, To: otherShard(from), Pinned: true}
+		redo, err := fenceCopy(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := copyRoom(ctx, m, redo); err != nil {
+			t.Fatal(err)
+		}
+		moves[roomID] = m
+	}
+	if _, err := dropSource(ctx, moves["r2"]); err != nil {
+		t.Fatal(err)
+	}
+
+	// 迁移中的房间拒绝写入
+	err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1)})
+	wantCode(t, err, CodeShardUnavailable)
+
+	report, err := Rebalance(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Moved != 2 || report.Failed != 0 {
+		t.Errorf("report = %+v, want both rooms finished", report)
+	}
+	for roomID, m := range moves {
+		if p := placement(t, roomID); p.ShardName != m.To.Name() || !p.Pinned || p.Migrating {
+			t.Errorf("placement of %s = %+v, want pinned to %s", roomID, p, m.To.Name())
+		}
+		if n := roomRows(t, m.From, roomID); n != 0 {
+			t.Errorf("%d row(s) of %s left on %s", n, roomID, m.From.Name())
+		}
+		if n := roomRows(t, m.To, roomID); n != 3 {
+			t.Errorf("%d row(s) of %s on %s, want 3", n, roomID, m.To.Name())
+		}
+	}
+	if err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1)}); err != nil {
+		t.Errorf("write after resume failed: %v", err)
+	}
+}
+
+func TestRebalanceKeepsDivergentSourceRows(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	from, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := otherShard(from)
+	if _, err := MoveRoom(ctx, "r1", to.Name()); err != nil {
+		t.Fatal(err)
+	}
+	// 源分片上残留一行与目标分片不同的 document
+	if err := from.Insert(ctx, from.Table("document"), store.Row{"room_id": "r1", "room_name": "stale"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Rebalance(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 {
+		t.Errorf("report = %+v, want the room to fail verification", report)
+	}
+	if n := roomRows(t, from, "r1"); n != 1 {
+		t.Errorf("%d row(s) of r1 left on %s, want the stale document kept", n, from.Name())
+	}
+	// 核对失败后房间仍在目标分片上，并且可以写入
+	if p := placement(t, "r1"); p.ShardName != to.Name() || p.Migrating {
+		t.Errorf("placement = %+v, want %s", p, to.Name())
+	}
+	if err := InsertDataIntoDataset(ctx, "comment", map[string]interface{}{"room_id": "r1", "seq": float64(1)}); err != nil {
+		t.Errorf("write after failed verification: %v", err)
+	}
+}
+
+func TestMoveRoomKeepsRowsAlreadyOnTarget(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	from, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := otherShard(from)
+	// 登记目录失败后写入落到了目标分片上的评论，不是迁移复制的
+	if err := to.Insert(ctx, to.Table("comment"), store.Row{"room_id": "r1", "seq": int64(1), "body": "live"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MoveRoom(ctx, "r1", to.Name()); !errors.Is(err, errRoomSplit) {
+		t.Fatalf("MoveRoom = %v, want errRoomSplit", err)
+	}
+	if n, _ := to.Count(ctx, to.Table("comment"), []store.Cond{{Column: "room_id", Value: "r1"}}); n != 1 {
+		t.Errorf("%d comment(s) of r1 on %s, want the live comment kept", n, to.Name())
+	}
+	if n := roomRows(t, from, "r1"); n != 3 {
+		t.Errorf("%d row(s) of r1 on %s, want 3", n, from.Name())
+	}
+	if p := placement(t, "r1"); p.ShardName != from.Name() || p.Migrating {
+		t.Errorf("placement = %+v, want %s", p, from.Name())
+	}
+
+	// 重平衡同样中止，并报告需要人工修复
+	if err := directory(ctx).Assign(ctx, "r1", to.Name(), true); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Rebalance(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 || len(report.Repair) != 1 || report.Repair[0] != "r1" {
+		t.Errorf("report = %+v, want r1 reported for repair", report)
+	}
+	if n, _ := to.Count(ctx, to.Table("comment"), []store.Cond{{Column: "room_id", Value: "r1"}}); n != 1 {
+		t.Errorf("%d comment(s) of r1 on %s after Rebalance, want 1", n, to.Name())
+	}
+}
+
+func TestMoveRoomRedoesInterruptedCopy(t *testing.T) {
+	fastMoves(t)
+	useMemory(t)
+	ctx := context.Background()
+	addUser(t, "u1")
+	addRoom(t, "r1", "u1")
+
+	from, err := roomShard(ctx, "r1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := otherShard(from)
+	// 上一次复制提交后中断，目标分片上留下一份旧的 document
+	m := RoomMove{RoomID: "r1", From: from, To: to}
+	if err := writeJournal(ctx, m, "copying"); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Insert(ctx, to.Table("document"), store.Row{"room_id": "r1", "room_name": "old copy"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := MoveRoom(ctx, "r1", to.Name()); err != nil {
+		t.Fatal(err)
+	}
+	if n := roomRows(t, to, "r1"); n != 3 {
+		t.Errorf("%d row(s) of r1 on %s, want 3", n, to.Name())
+	}
+	if got, err := ReadDataset(ctx, "document", "r1", "room_name"); err != nil || got == "old copy" {
+		t.Errorf("room_name = %v, %v, want the source row", got, err)
+	}
+}
+
+func TestRebalanceKeyed(t *testing.T) {
+	fastMoves(t)
+	old := rebalanceKeyBatch
+	rebalanceKeyBatch = 2
+	defer func() { rebalanceKeyBatch = old }()
+
+	s := useMemory(t)
+	ctx := context.Background()
+
+	// 写在哈希环定位以外分片上的 tag 行；tag0 在目标分片上已有更新的行
+	var tags []string
+	for i := 0; i < 7; i++ {
+		tag := fmt.Sprintf("tag%d", i)
+		tags = append(tags, tag)
+		wrong := otherShard(s.Locate(tag))
+		if err := wrong.Insert(ctx, wrong.Table("tag"), store.Row{"tag": tag, "n": int64(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	right := s.Locate("tag0")
+	if err := right.Insert(ctx, right.Table("tag"), store.Row{"tag": "tag0", "n": int64(100)}); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Rebalance(ctx, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows["tag"] != len(tags) {
+		t.Errorf("dry run rows = %d, want %d", report.Rows["tag"], len(tags))
+	}
+
+	report, err = Rebalance(ctx, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Rows["tag"] != len(tags) {
+		t.Errorf("moved %d tag row(s), want %d", report.Rows["tag"], len(tags))
+	}
+	for i, tag := range tags {
+		right := s.Locate(tag)
+		wrong := otherShard(right)
+		if n, _ := wrong.Count(ctx, wrong.Table("tag"), []store.Cond{{Column: "tag", Value: tag}}); n != 0 {
+			t.Errorf("%s still on %s", tag, wrong.Name())
+		}
+		want := int64(i)
+		if tag == "tag0" {
+			want = 100
+		}
+		if got, err := ReadDataset(ctx, "tag", tag, "n"); err != nil || got != want {
+			t.Errorf("%s.n = %v, %v, want %d", tag, got, err, want)
+		}
+	}
+}
+
+func TestRebalanceKeyedIntegerKey(t *testing.T) {
+	old := rebalanceKeyBatch
+	rebalanceKeyBatch = 2
+	defer func() { rebalanceKeyBatch = old }()
+
+	s := useMemory(t)
+	ctx := context.Background()
+	for id := int64(1); id <= 5; id++ {
+		wrong := otherShard(s.Locate(fmt.Sprint(id)))
+		if err := wrong.Insert(ctx, wrong.Table("ticket"), store.Row{"id": id, "note": "n"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// openGauss 不接受对整数列 COLLATE "C"，分页查询只对文本分片键按字节序排序
+	rec := &recorder{}
+	Use(recordingStore{s, rec})
+	moved, err := rebalanceKeyed(ctx, datasets["ticket"], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if moved != 5 {
+		t.Errorf("moved %d ticket row(s), want 5", moved)
+	}
+	for _, q := range rec.queries {
+		if len(q.Bytewise) > 0 {
+			t.Errorf("query on %s sorts %v bytewise", q.Table, q.Bytewise)
+		}
+	}
+	for id := 1; id <= 5; id++ {
+		if got, err := ReadDataset(ctx, "ticket", float64(id), "note"); err != nil || got != "n" {
+			t.Errorf("ticket %d note = %v, %v, want n", id, got, err)
+		}
+	}
+}
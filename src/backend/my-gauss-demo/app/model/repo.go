@@ -0,0 +1,477 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// 内置数据集的类型化访问：每张表一个结构体，可为 NULL 的列用指针表示（NULL 为 nil），
+// 列名只出现在 row / scan 方法中。通用的 /api/dataset/* 接口读写内置数据集时，
+// 行同样经过 scan / row 转换（见 codecs），插入由对应的仓库完成
+
+// Document document 表的一行（房间的元数据）
+type Document struct {
+	RoomID            string     `json:"room_id"`
+	RoomName          *string    `json:"room_name"`
+	CreateTime        *time.Time `json:"create_time"`
+	OverallPermission *int64     `json:"overall_permission"`
+	OwnerUserID       *string    `json:"owner_user_id"`
+}
+
+// Permission permission 表的一行：用户在房间中的权限
+type Permission struct {
+	RoomID     string `json:"room_id"`
+	UserID     string `json:"user_id"`
+	Permission *int64 `json:"permission"`
+}
+
+// Content content 表的一行：房间的文档内容
+type Content struct {
+	RoomID  string  `json:"room_id"`
+	Content *string `json:"content"`
+}
+
+func (u User) row() store.Row {
+	return store.Row{
+		"id":        u.ID,
+		"user_name": nullable(u.UserName),
+		"email":     nullable(u.Email),
+		"password":  nullable(u.Password),
+	}
+}
+
+func scanUser(row store.Row) (User, error) {
+	return User{
+		ID:       decodeKey(row["id"]),
+		UserName: decodeString(row["user_name"]),
+		Email:    decodeString(row["email"]),
+		Password: decodeString(row["password"]),
+	}, nil
+}
+
+func (d Document) row() store.Row {
+	var createTime interface{}
+	if d.CreateTime != nil {
+		createTime = *d.CreateTime
+	}
+	return store.Row{
+		"room_id":            d.RoomID,
+		"room_name":          nullable(d.RoomName),
+		"create_time":        createTime,
+		"overall_permission": nullable(d.OverallPermission),
+		"owner_user_id":      nullable(d.OwnerUserID),
+	}
+}
+
+func scanDocument(row store.Row) (Document, error) {
+	d := Document{
+		RoomID:      decodeKey(row["room_id"]),
+		RoomName:    decodeString(row["room_name"]),
+		OwnerUserID: decodeString(row["owner_user_id"]),
+	}
+	var err error
+	if d.CreateTime, err = decodeTime(row["create_time"]); err != nil {
+		return d, fmt.Errorf("document %s: create_time: %v", d.RoomID, err)
+	}
+	if d.OverallPermission, err = decodeInt(row["overall_permission"]); err != nil {
+		return d, fmt.Errorf("document %s: overall_permission: %v", d.RoomID, err)
+	}
+	return d, nil
+}
+
+func (p Permission) row() store.Row {
+	return store.Row{"room_id": p.RoomID, "user_id": p.UserID, "permission": nullable(p.Permission)}
+}
+
+func scanPermission(row store.Row) (Permission, error) {
+	p := Permission{RoomID: decodeKey(row["room_id"]), UserID: decodeKey(row["user_id"])}
+	var err error
+	if p.Permission, err = decodeInt(row["permission"]); err != nil {
+		return p, fmt.Errorf("permission %s/%s: %v", p.RoomID, p.UserID, err)
+	}
+	return p, nil
+}
+
+func (c Content) row() store.Row {
+	return store.Row{"room_id": c.RoomID, "content": nullable(c.Content)}
+}
+
+func scanContent(row store.Row) (Content, error) {
+	return Content{RoomID: decodeKey(row["room_id"]), Content: decodeString(row["content"])}, nil
+}
+
+// codecs 内置数据集的 scan / row 往返：通用接口读到的行经类型化结构体转换一次，
+// 列值与 scanUser、scanDocument 等返回的结构体一致
+var codecs = map[string]func(store.Row) (store.Row, error){
+	"user":       codec(scanUser),
+	"document":   codec(scanDocument),
+	"permission": codec(scanPermission),
+	"content":    codec(scanContent),
+}
+
+func codec[T interface{ row() store.Row }](scan func(store.Row) (T, error)) func(store.Row) (store.Row, error) {
+	return func(row store.Row) (store.Row, error) {
+		v, err := scan(row)
+		if err != nil {
+			return nil, err
+		}
+		return v.row(), nil
+	}
+}
+
+// UserRepo "user" 表（不分片，在 UserShard 上）
+type UserRepo struct{}
+
+// RoomRepo document 表，按 room_id 定位分片
+type RoomRepo struct{}
+
+// PermissionRepo permission 表，写入时同步 user_rooms 索引
+type PermissionRepo struct{}
+
+// ContentRepo content 表，按 room_id 定位分片
+type ContentRepo struct{}
+
+var (
+	Users       UserRepo
+	Rooms       RoomRepo
+	Permissions PermissionRepo
+	Contents    ContentRepo
+)
+
+// Get 返回 id 对应的用户，不存在时返回 nil
+func (UserRepo) Get(ctx context.Context, id string) (*User, error) {
+	row, err := store.First(ctx, userShard(ctx), store.Query{
+		Table:   userTable.base,
+		Columns: userTable.columns,
+		Where:   []store.Cond{{Column: "id", Value: id}},
+	})
+	if err != nil || row == nil {
+		return nil, err
+	}
+	u, err := scanUser(row)
+	return &u, err
+}
+
+// List 返回所有用户，按 id 排序
+func (UserRepo) List(ctx context.Context) ([]User, error) {
+	users := []User{}
+	q := store.Query{Table: userTable.base, Columns: userTable.columns, OrderBy: userTable.pk, Bytewise: userTable.pk}
+	err := userShard(ctx).Scan(ctx, q, func(row store.Row) error {
+		u, err := scanUser(row)
+		if err != nil {
+			return err
+		}
+		users = append(users, u)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+// Insert 插入用户，id 已存在时返回 store.ErrDuplicateKey
+func (UserRepo) Insert(ctx context.Context, u User) error {
+	if u.ID == "" {
+		return Validation("empty user ID")
+	}
+	return userShard(ctx).Insert(ctx, userTable.base, u.row())
+}
+
+// insertOn 在 s（分片或事务）的物理表 table 中插入 document 行，room_id 已存在时返回 store.ErrDuplicateKey
+func (RoomRepo) insertOn(ctx context.Context, s store.Executor, table string, d Document) error {
+	return s.Insert(ctx, table, d.row())
+}
+
+// insertOn 在分片 s 上插入权限行，user_rooms 在同一事务中更新；(room_id, user_id) 已存在时返回 store.ErrDuplicateKey
+func (r PermissionRepo) insertOn(ctx context.Context, s store.Shard, table string, p Permission) error {
+	return withUserRooms(ctx, s, func(tx, index store.Tx) error {
+		return r.insertTx(ctx, tx, index, table, p)
+	})
+}
+
+// insertTx 在事务 tx 中插入权限行，并在 user_rooms 的事务 index 中写入索引记录
+func (PermissionRepo) insertTx(ctx context.Context, tx, index store.Tx, table string, p Permission) error {
+	row := p.row()
+	if err := tx.Insert(ctx, table, row); err != nil {
+		return err
+	}
+	return indexPermission(ctx, index, row)
+}
+
+// insertOn 在 s（分片或事务）的物理表 table 中插入 content 行，room_id 已存在时返回 store.ErrDuplicateKey
+func (ContentRepo) insertOn(ctx context.Context, s store.Executor, table string, c Content) error {
+	return s.Insert(ctx, table, c.row())
+}
+
+// roomTableOf 返回房间在逻辑表 base 上所在的分片和物理表
+func roomTableOf(ctx context.Context, base, roomID string) (store.Shard, string, error) {
+	if roomID == "" {
+		return nil, "", Validation("room_id must not be empty")
+	}
+	t, _ := lookupTable(base)
+	return t.route(ctx, roomID)
+}
+
+// selectRoom 读取房间在逻辑表 base 上满足 where 的行
+func selectRoom(ctx context.Context, base, roomID string, where []store.Cond) ([]store.Row, error) {
+	s, table, err := roomTableOf(ctx, base, roomID)
+	if err != nil {
+		return nil, err
+	}
+	t, _ := lookupTable(base)
+	where = append([]store.Cond{{Column: "room_id", Value: roomID}}, where...)
+	rows, err := store.Select(ctx, s, store.Query{Table: table, Columns: t.columns, Where: where})
+	if err != nil {
+		return nil, fmt.Errorf("query %s failed: %w", table, err)
+	}
+	return rows, nil
+}
+
+// nullable 把可为 NULL 的字段转为列值：nil 指针写入 NULL
+func nullable[T any](p *T) interface{} {
+	if p == nil {
+		return nil
+	}
+	return *p
+}
+
+// decodeKey 主键列的值（不会是 NULL）
+func decodeKey(v interface{}) string {
+	if s := decodeString(v); s != nil {
+		return *s
+	}
+	return ""
+}
+
+// decodeString 文本列：NULL 为 nil
+func decodeString(v interface{}) *string {
+	switch x := v.(type) {
+	case nil:
+		return nil
+	case string:
+		return &x
+	case []byte:
+		s := string(x)
+		return &s
+	}
+	s := fmt.Sprint(v)
+	return &s
+}
+
+// decodeInt 整数列：openGauss 返回 int64，内存存储中可能是 JSON 解析得到的 float64 或字符串
+func decodeInt(v interface{}) (*int64, error) {
+	var n int64
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case int:
+		n = int64(x)
+	case int32:
+		n = int64(x)
+	case int64:
+		n = x
+	case float64:
+		if x != float64(int64(x)) {
+			return nil, fmt.Errorf("%v is not an integer", x)
+		}
+		n = int64(x)
+	case string:
+		var err error
+		if n, err = strconv.ParseInt(strings.TrimSpace(x), 10, 64); err != nil {
+			return nil, fmt.Errorf("%q is not an integer", x)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %T value %v", v, v)
+	}
+	return &n, nil
+}
+
+// decodeFloat NUMERIC / DECIMAL 列：openGauss 驱动以字符串返回，内存存储中是 JSON 解析得到的 float64
+func decodeFloat(v interface{}) (*float64, error) {
+	var f float64
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case int:
+		f = float64(x)
+	case int32:
+		f = float64(x)
+	case int64:
+		f = float64(x)
+	case float32:
+		f = float64(x)
+	case float64:
+		f = x
+	case string:
+		var err error
+		if f, err = strconv.ParseFloat(strings.TrimSpace(x), 64); err != nil {
+			return nil, fmt.Errorf("%q is not a number", x)
+		}
+	default:
+		return nil, fmt.Errorf("unexpected %T value %v", v, v)
+	}
+	return &f, nil
+}
+
+// timeLayouts 时间列可接受的字符串格式（内存存储中保存的是请求中的原始字符串）
+var timeLayouts = []string{"2006-01-02 15:04:05", time.RFC3339Nano, "2006-01-02T15:04:05", "2006-01-02"}
+
+// decodeTime 时间列：openGauss 返回 time.Time，内存存储中可能是字符串
+func decodeTime(v interface{}) (*time.Time, error) {
+	switch x := v.(type) {
+	case nil:
+		return nil, nil
+	case time.Time:
+		return &x, nil
+	case string:
+		for _, layout := range timeLayouts {
+			if t, err := time.Parse(layout, x); err == nil {
+				return &t, nil
+			}
+		}
+		return nil, fmt.Errorf("%q is not a valid time", x)
+	}
+	return nil, fmt.Errorf("unexpected %T value %v", v, v)
+}
+
+// decodeValue 按列类型转换列值，使两种存储后端返回的值类型一致：
+// 整数类型为 int64，NUMERIC / DECIMAL 为 float64，TIMESTAMP / DATE 为 time.Time，其余保持原样；NULL 为 nil
+func decodeValue(typ string, v interface{}) (interface{}, error) {
+	name := strings.ToUpper(strings.TrimSpace(typ))
+	if i := strings.IndexByte(name, '('); i >= 0 {
+		name = strings.TrimSpace(name[:i])
+	}
+	switch {
+	case name == "INT" || name == "INTEGER" || name == "BIGINT" || name == "SMALLINT" || strings.HasPrefix(name, "INT"):
+		n, err := decodeInt(v)
+		if err != nil || n == nil {
+			return nil, err
+		}
+		return *n, nil
+	case name == "NUMERIC" || name == "DECIMAL":
+		f, err := decodeFloat(v)
+		if err != nil || f == nil {
+			return nil, err
+		}
+		return *f, nil
+	case strings.HasPrefix(name, "TIMESTAMP") || name == "DATE":
+		t, err := decodeTime(v)
+		if err != nil || t == nil {
+			return nil, err
+		}
+		return *t, nil
+	}
+	return v, nil
+}
+
+// decodeRow 按 t 的列类型原地转换一行；内置数据集再经类型化结构体往返一次（只保留 row 中已有的列）
+func (t tableSpec) decodeRow(row store.Row) error {
+	for col, v := range row {
+		typ, ok := t.types[col]
+		if !ok {
+			continue
+		}
+		dv, err := decodeValue(typ, v)
+		if err != nil {
+			return fmt.Errorf("%s.%s: %v", t.base, col, err)
+		}
+		row[col] = dv
+	}
+
+	c, ok := codecs[t.base]
+	if !ok || t.create {
+		return nil
+	}
+	typed, err := c(row)
+	if err != nil {
+		return err
+	}
+	for col := range row {
+		if _, ok := t.types[col]; ok {
+			row[col] = typed[col]
+		}
+	}
+	return nil
+}
+
+// decodeRows 按 t 的列类型转换多行
+func (t tableSpec) decodeRows(rows []store.Row) error {
+	for _, row := range rows {
+		if err := t.decodeRow(row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkValues 按列类型原地转换请求中的值（写入和查询条件使用与读取相同的类型），
+// 值与列类型不符时返回 field 字段的 *FieldError
+func (t tableSpec) checkValues(field string, row store.Row) error {
+	for col, v := range row {
+		dv, err := decodeValue(t.types[col], v)
+		if err != nil {
+			return &FieldError{Field: field, Value: col, Err: err}
+		}
+		row[col] = dv
+	}
+	return nil
+}
+
+// checkConds 同 checkValues，转换查询条件中的值
+func (t tableSpec) checkConds(field string, where []store.Cond) error {
+	for i, c := range where {
+		v, err := decodeValue(t.types[c.Column], c.Value)
+		if err != nil {
+			return &FieldError{Field: field, Value: c.Column, Err: err}
+		}
+		where[i].Value = v
+	}
+	return nil
+}
+
+// checkFilterValues 同 checkValues，转换过滤表达式中的比较值；LIKE 的模式保持字符串
+func (t tableSpec) checkFilterValues(e *store.Expr) error {
+	if e == nil {
+		return nil
+	}
+	switch e.Op {
+	case store.OpAnd, store.OpOr:
+		for _, arg := range e.Args {
+			if err := t.checkFilterValues(arg); err != nil {
+				return err
+			}
+		}
+		return nil
+	case store.OpLike, store.OpILike, store.OpIsNull:
+		return nil
+	}
+	typ := t.types[e.Column]
+	var err error
+	if e.Value, err = decodeValue(typ, e.Value); err != nil {
+		return &FieldError{Field: "filter", Value: e.Column, Err: err}
+	}
+	for i, v := range e.Values {
+		if e.Values[i], err = decodeValue(typ, v); err != nil {
+			return &FieldError{Field: "filter", Value: e.Column, Err: err}
+		}
+	}
+	return nil
+}
+
+// readRows 在 tables 上并发执行 q，按 t 的列类型转换结果
+func readRows(ctx context.Context, t tableSpec, tables []shardTable, q store.Query) ([]map[string]interface{}, error) {
+	rows, err := scatterRows(ctx, tables, q)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.decodeRows(rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
@@ -0,0 +1,73 @@
+package model
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func ptr[T any](v T) *T { return &v }
+
+func TestRowScanRoundTrip(t *testing.T) {
+	created := time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC)
+	users := []User{{ID: "u1"}, {ID: "u2", UserName: ptr("alice"), Email: ptr("a@example.com"), Password: ptr("hash")}}
+	for _, u := range users {
+		if got, err := scanUser(u.row()); err != nil || !reflect.DeepEqual(got, u) {
+			t.Errorf("scanUser(row(%+v)) = %+v, %v", u, got, err)
+		}
+	}
+
+	docs := []Document{{RoomID: "r1"}, {RoomID: "r2", RoomName: ptr("demo"), CreateTime: &created, OverallPermission: ptr(int64(2)), OwnerUserID: ptr("u1")}}
+	for _, d := range docs {
+		if got, err := scanDocument(d.row()); err != nil || !reflect.DeepEqual(got, d) {
+			t.Errorf("scanDocument(row(%+v)) = %+v, %v", d, got, err)
+		}
+	}
+
+	perms := []Permission{{RoomID: "r1", UserID: "u1"}, {RoomID: "r1", UserID: "u2", Permission: ptr(int64(3))}}
+	for _, p := range perms {
+		if got, err := scanPermission(p.row()); err != nil || !reflect.DeepEqual(got, p) {
+			t.Errorf("scanPermission(row(%+v)) = %+v, %v", p, got, err)
+		}
+	}
+
+	contents := []Content{{RoomID: "r1"}, {RoomID: "r2", Content: ptr("hello")}}
+	for _, c := range contents {
+		if got, err := scanContent(c.row()); err != nil || !reflect.DeepEqual(got, c) {
+			t.Errorf("scanContent(row(%+v)) = %+v, %v", c, got, err)
+		}
+	}
+}
+
+func TestScanRejectsMistypedColumns(t *testing.T) {
+	if _, err := scanDocument(map[string]interface{}{"room_id": "r1", "overall_permission": "high"}); err == nil {
+		t.Error("scanDocument accepted a non-integer overall_permission")
+	}
+	if _, err := scanPermission(map[string]interface{}{"room_id": "r1", "user_id": "u1", "permission": 1.5}); err == nil {
+		t.Error("scanPermission accepted a non-integer permission")
+	}
+}
+
+func TestDatasetEndpointsUseRepos(t *testing.T) {
+	useMemory(t)
+	ctx := context.Background()
+
+	// 通用接口写入的 permission 经 PermissionRepo 写入，user_rooms 同步更新
+	err := InsertDataIntoDataset(ctx, "room_permission_table", map[string]interface{}{"room_id": "r1", "user_id": "u1", "permission": float64(3)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rooms, _ := UserRooms(ctx, "u1"); len(rooms) != 1 {
+		t.Errorf("UserRooms(u1) = %+v, want one row", rooms)
+	}
+
+	// 读到的值与类型化仓库一致
+	got, err := ReadDataset(ctx, "permission", []interface{}{"r1", "u1"}, "permission")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != int64(3) {
+		t.Errorf("permission = %#v, want int64(3)", got)
+	}
+}
@@ -0,0 +1,139 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+var (
+	// ErrRoomExists 房间 room_id 已存在
+	ErrRoomExists error = &Error{Code: CodeConflict, Message: "room already exists"}
+	// ErrOwnerNotFound owner_user_id 在 "user" 表中不存在
+	ErrOwnerNotFound error = &Error{Code: CodeValidation, Message: "owner user does not exist"}
+	// ErrRoomNotFound 房间在所属分片上没有任何数据
+	ErrRoomNotFound error = &Error{Code: CodeNotFound, Message: "room not found"}
+)
+
+// Room 一个房间：document 行 + owner 的 permission 行 + content 行
+type Room struct {
+	RoomID            string `json:"room_id"`
+	RoomName          string `json:"room_name"`
+	CreateTime        string `json:"create_time"`
+	OverallPermission int    `json:"overall_permission"`
+	OwnerUserID       string `json:"owner_user_id"`
+	Permission        int    `json:"permission"` // owner 在 permission 表中的权限
+	Content           string `json:"content"`
+}
+
+// CreateRoom 在房间所属分片上用一个事务写入 document、permission、content 三行，
+// owner 的权限同时写入 user_rooms 索引；任一步失败都整体回滚，不会留下缺内容或缺 owner 权限的房间
+func CreateRoom(ctx context.Context, room Room) (*Room, error) {
+	ctx = forWrite(ctx)
+	if room.RoomID == "" {
+		return nil, Validation("room requires 'room_id' field")
+	}
+	if room.OwnerUserID == "" {
+		return nil, Validation("room requires 'owner_user_id' field")
+	}
+	if room.CreateTime == "" {
+		room.CreateTime = time.Now().Format("2006-01-02 15:04:05")
+	}
+
+	createTime, err := decodeTime(room.CreateTime)
+	if err != nil {
+		return nil, &FieldError{Field: "create_time", Value: room.CreateTime, Err: err}
+	}
+
+	// owner 必须是已注册用户（user 表不分片，和房间不一定在同一实例，事务外检查）
+	owner, err := Users.Get(ctx, room.OwnerUserID)
+	if err != nil {
+		return nil, fmt.Errorf("query user failed: %w", err)
+	}
+	if owner == nil {
+		return nil, ErrOwnerNotFound
+	}
+
+	// 先登记到目录再写入，登记失败时不创建房间
+	s, err := placeRoom(ctx, room.RoomID)
+	if err != nil {
+		return nil, err
+	}
+
+	overall, permission := int64(room.OverallPermission), int64(room.Permission)
+	doc := Document{
+		RoomID:            room.RoomID,
+		RoomName:          &room.RoomName,
+		CreateTime:        createTime,
+		OverallPermission: &overall,
+		OwnerUserID:       &room.OwnerUserID,
+	}
+	perm := Permission{RoomID: room.RoomID, UserID: room.OwnerUserID, Permission: &permission}
+	content := Content{RoomID: room.RoomID, Content: &room.Content}
+	// 三张表经各自的仓库在同一事务中写入，owner 的权限同时写入 user_rooms 索引
+	err = withUserRooms(ctx, s, func(tx, index store.Tx) error {
+		err := Rooms.insertOn(ctx, tx, s.Table("document"), doc)
+		if err == nil {
+			err = Permissions.insertTx(ctx, tx, index, s.Table("permission"), perm)
+		}
+		if err == nil {
+			err = Contents.insertOn(ctx, tx, s.Table("content"), content)
+		}
+		if errors.Is(err, store.ErrDuplicateKey) {
+			return ErrRoomExists
+		}
+		if err != nil {
+			return fmt.Errorf("create room failed: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &room, nil
+}
+
+// DeleteRoom 在房间所属分片上用一个事务删除所有房间表（roomTables，含配置中按 room_id 分片的数据集）中该房间的行，
+// 返回每张表删除的行数。这些表都没有该房间时返回 ErrRoomNotFound；
+// 只缺 document 行的残留权限/内容也会一并清理，user_rooms 中该房间的记录同时删除
+func DeleteRoom(ctx context.Context, roomID string) (map[string]int64, error) {
+	ctx = forWrite(ctx)
+	if roomID == "" {
+		return nil, Validation("room_id must not be empty")
+	}
+
+	s, err := roomShard(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[string]int64)
+	byRoom := []store.Cond{{Column: "room_id", Value: roomID}}
+	err = withUserRooms(ctx, s, func(tx, index store.Tx) error {
+		var total int64
+		for _, t := range roomTables {
+			n, err := tx.Delete(ctx, s.Table(t.base), byRoom)
+			if err != nil {
+				return fmt.Errorf("delete from %s failed: %w", s.Table(t.base), err)
+			}
+			counts[t.base] = n
+			total += n
+		}
+		if total == 0 {
+			return ErrRoomNotFound
+		}
+		return unindexPermissions(ctx, index, byRoom)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if err := directory(ctx).Remove(ctx, roomID); err != nil {
+		log.Printf("Remove room %s from directory failed: %v", roomID, err)
+	}
+	return counts, nil
+}
@@ -0,0 +1,225 @@
+package model
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// ScatterTimeout 一次全分片并发查询的总超时，0 表示只受请求的处理时限限制。
+// 超时不会降级为部分结果：分片只是慢而没有熔断时，请求以超时失败
+var ScatterTimeout time.Duration
+
+// ShardError 某个分片上的查询失败
+type ShardError struct {
+	Shard string
+	Table string
+	Err   error
+}
+
+func (e *ShardError) Error() string {
+	return fmt.Sprintf("shard %s (%s): %v", e.Shard, e.Table, e.Err)
+}
+
+func (e *ShardError) Unwrap() error {
+	return e.Err
+}
+
+// ScatterError 全分片查询中失败的分片
+type ScatterError struct {
+	Errors []*ShardError
+}
+
+func (e *ScatterError) Error() string {
+	parts := make([]string, len(e.Errors))
+	for i, se := range e.Errors {
+		parts[i] = se.Error()
+	}
+	return fmt.Sprintf("%d shard(s) failed: %s", len(e.Errors), strings.Join(parts, "; "))
+}
+
+// Unwrap 返回各分片的错误，errors.Is(err, context.DeadlineExceeded) 可以判断是否有分片超时
+func (e *ScatterError) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i, se := range e.Errors {
+		errs[i] = se
+	}
+	return errs
+}
+
+// FailedShards 返回失败的分片名
+func (e *ScatterError) FailedShards() []string {
+	names := make([]string, len(e.Errors))
+	for i, se := range e.Errors {
+		names[i] = se.Shard
+	}
+	return names
+}
+
+// missingError 部分结果不足以作答时（例如单行查询在可用的分片上都没有命中），
+// 把跳过的分片 missing 转为 *ScatterError，其中每个分片都是不可用
+func missingError(tables []shardTable, missing []string) error {
+	var failed []*ShardError
+	for _, st := range tables {
+		if containsString(missing, st.shard.Name()) {
+			failed = append(failed, &ShardError{Shard: st.shard.Name(), Table: st.table, Err: store.ErrShardUnavailable})
+		}
+	}
+	return &ScatterError{Errors: failed}
+}
+
+// scatter 在每个分片表上并发执行 fn，按分片顺序合并结果。
+// 所有分片共享请求的截止时间（设置了 ScatterTimeout 时取两者中较早的）；任一分片失败时返回 *ScatterError，
+// 其中列出每个失败的分片。ctx 经过 AllowPartial 时，若失败的分片都是不可用（熔断）
+// 且至少一个分片成功，则返回其余分片的结果，并把失败的分片记入 Partial
+func scatter[T any](ctx context.Context, tables []shardTable, fn func(ctx context.Context, st shardTable) ([]T, error)) ([]T, error) {
+	if ScatterTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, ScatterTimeout)
+		defer cancel()
+	}
+
+	results := make([][]T, len(tables))
+	errs := make([]error, len(tables))
+
+	var wg sync.WaitGroup
+	for i, st := range tables {
+		wg.Add(1)
+		go func(i int, st shardTable) {
+			defer wg.Done()
+			results[i], errs[i] = fn(ctx, st)
+		}(i, st)
+	}
+	wg.Wait()
+
+	var merged []T
+	var failed []*ShardError
+	for i, st := range tables {
+		if errs[i] != nil {
+			failed = append(failed, &ShardError{Shard: st.shard.Name(), Table: st.table, Err: errs[i]})
+			continue
+		}
+		merged = append(merged, results[i]...)
+	}
+	if len(failed) > 0 {
+		p := partialFrom(ctx)
+		if p == nil || len(failed) == len(tables) {
+			return nil, &ScatterError{Errors: failed}
+		}
+		for _, se := range failed {
+			if !isUnavailable(se.Err) {
+				return nil, &ScatterError{Errors: failed}
+			}
+		}
+		for _, se := range failed {
+			p.add(se.Shard)
+		}
+	}
+	return merged, nil
+}
+
+// scatterRows 在所有分片表上并发执行查询 q，q.Table 由各分片的物理表名替换
+func scatterRows(ctx context.Context, tables []shardTable, q store.Query) ([]map[string]interface{}, error) {
+	return scatter(ctx, tables, func(ctx context.Context, st shardTable) ([]map[string]interface{}, error) {
+		sq := q
+		sq.Table = st.table
+		return store.Select(ctx, st.shard, sq)
+	})
+}
+
+// scatterSorted 在所有分片表上并发执行查询 q，各分片按 order 排序并只取前 limit 行（limit 大于 0 时），
+// 再 k 路归并各分片的有序结果，取前 limit 行。q 的排序、方向和 Limit 由 order、limit 设置
+func scatterSorted(ctx context.Context, t tableSpec, tables []shardTable, q store.Query, order []OrderKey, limit int) ([]map[string]interface{}, error) {
+	q.OrderBy, q.Desc = nil, nil
+	for _, k := range order {
+		q.OrderBy = append(q.OrderBy, k.Column)
+		if k.Desc {
+			q.Desc = append(q.Desc, k.Column)
+		}
+	}
+	q.Bytewise = t.bytewise(q.OrderBy)
+	q.Limit = limit
+
+	lists, err := scatter(ctx, tables, func(ctx context.Context, st shardTable) ([][]map[string]interface{}, error) {
+		sq := q
+		sq.Table = st.table
+		rows, err := store.Select(ctx, st.shard, sq)
+		if err != nil {
+			return nil, err
+		}
+		// 归并前按列类型转换，NUMERIC 等以字符串返回的列才能按数值比较，与各分片上的排序一致
+		if err := t.decodeRows(rows); err != nil {
+			return nil, err
+		}
+		return [][]map[string]interface{}{rows}, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeSorted(lists, order, limit), nil
+}
+
+// mergeSorted 归并已按 order 排序的多组行，取值相同时先取排在前面的组；limit 大于 0 时只取前 limit 行
+func mergeSorted(lists [][]map[string]interface{}, order []OrderKey, limit int) []map[string]interface{} {
+	h := &rowHeap{order: order}
+	total := 0
+	for i, rows := range lists {
+		total += len(rows)
+		if len(rows) > 0 {
+			h.cursors = append(h.cursors, rowCursor{list: i, rows: rows})
+		}
+	}
+	if limit > 0 && total > limit {
+		total = limit
+	}
+	heap.Init(h)
+
+	merged := make([]map[string]interface{}, 0, total)
+	for h.Len() > 0 && len(merged) < total {
+		c := &h.cursors[0]
+		merged = append(merged, c.rows[0])
+		if c.rows = c.rows[1:]; len(c.rows) == 0 {
+			heap.Pop(h)
+		} else {
+			heap.Fix(h, 0)
+		}
+	}
+	return merged
+}
+
+// rowCursor 一组有序行中尚未取出的部分
+type rowCursor struct {
+	list int
+	rows []map[string]interface{}
+}
+
+// rowHeap 按各组的第一行排序的最小堆
+type rowHeap struct {
+	order   []OrderKey
+	cursors []rowCursor
+}
+
+func (h *rowHeap) Len() int { return len(h.cursors) }
+
+func (h *rowHeap) Less(i, j int) bool {
+	a, b := h.cursors[i], h.cursors[j]
+	if c := compareOrder(a.rows[0], b.rows[0], h.order); c != 0 {
+		return c < 0
+	}
+	return a.list < b.list
+}
+
+func (h *rowHeap) Swap(i, j int) { h.cursors[i], h.cursors[j] = h.cursors[j], h.cursors[i] }
+
+func (h *rowHeap) Push(x any) { h.cursors = append(h.cursors, x.(rowCursor)) }
+
+func (h *rowHeap) Pop() any {
+	last := h.cursors[len(h.cursors)-1]
+	h.cursors = h.cursors[:len(h.cursors)-1]
+	return last
+}
@@ -0,0 +1,197 @@
+package model
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// generation 一代存储后端。热加载配置时换上新的一代，
+// 旧的一代在它之前开始的请求全部结束后才关闭连接池
+type generation struct {
+	store     store.Store
+	directory *store.Directory
+
+	mu       sync.Mutex
+	inflight int
+	retired  bool
+	drained  chan struct{}
+}
+
+var current atomic.Pointer[generation]
+
+func newGeneration(s store.Store) *generation {
+	return &generation{store: s, directory: store.NewDirectory(s.UserShard()), drained: make(chan struct{})}
+}
+
+type generationKey struct{}
+
+// generationOf 返回 ctx 上由 Enter 固定的一代后端，没有时（子命令、启动阶段）为当前的一代
+func generationOf(ctx context.Context) *generation {
+	if g, ok := ctx.Value(generationKey{}).(*generation); ok {
+		return g
+	}
+	return current.Load()
+}
+
+// backend 请求使用的存储后端（openGauss 或内存）
+func backend(ctx context.Context) store.Store {
+	return generationOf(ctx).store
+}
+
+// directory room_id -> 分片 的目录
+func directory(ctx context.Context) *store.Directory {
+	return generationOf(ctx).directory
+}
+
+// Use 设置 model 层使用的存储后端，启动时在处理请求之前调用一次
+func Use(s store.Store) {
+	current.Store(newGeneration(s))
+}
+
+// Swap 换上新的存储后端。返回的 channel 在换下的后端上所有进行中的请求（Enter 之后尚未 leave）
+// 结束时关闭，之后才可以关闭旧后端的连接池
+func Swap(s store.Store) <-chan struct{} {
+	old := current.Swap(newGeneration(s))
+
+	old.mu.Lock()
+	defer old.mu.Unlock()
+	old.retired = true
+	if old.inflight == 0 {
+		close(old.drained)
+	}
+	return old.drained
+}
+
+// Enter 登记一个进行中的请求，返回的 ctx 固定使用当前的一代后端，
+// 请求处理中途热加载也不会换到新的连接池；leave 在请求结束时调用
+func Enter(ctx context.Context) (context.Context, func()) {
+	for {
+		g := current.Load()
+		g.mu.Lock()
+		if g.retired {
+			// 刚被换下，重新读取当前的一代
+			g.mu.Unlock()
+			continue
+		}
+		g.inflight++
+		g.mu.Unlock()
+
+		return context.WithValue(ctx, generationKey{}, g), func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			g.inflight--
+			if g.retired && g.inflight == 0 {
+				close(g.drained)
+			}
+		}
+	}
+}
+
+// ResolvePrepared 每隔 interval 在当前的一代后端上执行一次 store.RecoverPrepared，直到 ctx 取消。
+// 只在服务进程中运行：启动时还不满 store.RecoverAfter 的事务和第二阶段失败的事务由它补完
+func ResolvePrepared(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		gctx, leave := Enter(ctx)
+		store.RecoverPrepared(gctx, backend(gctx))
+		leave()
+	}
+}
+
+// TableDefs 返回 model 层用到的所有表，内存后端据此建表；配置中声明的数据集带有列类型，openGauss 后端据此建表
+func TableDefs() []store.TableDef {
+	defs := []store.TableDef{
+		rebalanceJournalTable,
+		userRoomsTable,
+		store.DirectoryTable,
+		store.TwoPCLogTable,
+	}
+	for _, t := range allTables() {
+		def := store.TableDef{Name: t.base, Columns: t.columns, PrimaryKey: t.pk, Sharded: t.sharded()}
+		if t.create {
+			def.Types = t.types
+		}
+		defs = append(defs, def)
+	}
+	return defs
+}
+
+// shardTable 某个分片上的一张物理表
+type shardTable struct {
+	shard store.Shard
+	table string
+}
+
+type writeKey struct{}
+
+// forWrite 标记 ctx 上的路由用于写入：房间位置不使用目录缓存，迁移中的房间拒绝写入
+func forWrite(ctx context.Context) context.Context {
+	return context.WithValue(ctx, writeKey{}, true)
+}
+
+// isWrite ctx 是否由 forWrite 标记
+func isWrite(ctx context.Context) bool {
+	v, _ := ctx.Value(writeKey{}).(bool)
+	return v
+}
+
+// roomShard 返回 room_id 当前所在的分片：目录中有记录时以目录为准，否则按哈希环定位。
+// 写入时（forWrite）绕过目录缓存，房间正在迁移时返回 store.ErrRoomMigrating
+func roomShard(ctx context.Context, roomID string) (store.Shard, error) {
+	lookup := directory(ctx).Lookup
+	if isWrite(ctx) {
+		lookup = directory(ctx).LookupForWrite
+	}
+	p, err := lookup(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if p == nil {
+		return backend(ctx).Locate(roomID), nil
+	}
+
+	s, ok := backend(ctx).ShardByName(p.ShardName)
+	if !ok {
+		return nil, Internal("room %s is placed on unknown shard %s", roomID, p.ShardName)
+	}
+	return s, nil
+}
+
+// placeRoom 在写入房间数据之前把房间登记到 room_directory（已有记录时不变），返回目录中登记的分片：
+// 登记失败时不写入，调用方可以重试；写入之后即使哈希环变化也能找到这些数据
+func placeRoom(ctx context.Context, roomID string) (store.Shard, error) {
+	s, err := roomShard(forWrite(ctx), roomID)
+	if err != nil {
+		return nil, err
+	}
+	if err := directory(ctx).Register(ctx, roomID, s.Name()); err != nil {
+		return nil, err
+	}
+	// 并发登记时以目录中先写入的记录为准
+	return roomShard(forWrite(ctx), roomID)
+}
+
+// allShardTables 返回逻辑表在所有分片上的物理表，用于全表扫描或非 room_id 条件
+func allShardTables(ctx context.Context, baseTable string) []shardTable {
+	shards := backend(ctx).Shards()
+	tables := make([]shardTable, 0, len(shards))
+	for _, s := range shards {
+		tables = append(tables, shardTable{s, s.Table(baseTable)})
+	}
+	return tables
+}
+
+// userShard 返回不分片的用户表所在分片
+func userShard(ctx context.Context) store.Shard {
+	return backend(ctx).UserShard()
+}
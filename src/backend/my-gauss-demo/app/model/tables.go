@@ -0,0 +1,366 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"my-gauss-app/config"
+	"my-gauss-app/store"
+)
+
+// tableSpec 一张逻辑表的结构：列及类型、主键和分片键。
+// 请求中的数据集名和列名都必须先在这里查到，才会用于构造查询
+type tableSpec struct {
+	base    string
+	aliases []string
+	columns []string
+	// types 列名 -> openGauss 类型
+	types map[string]string
+	pk    []string
+	// shardKey 分片键，为空表示不分片（只在 UserShard 上有一张表）；
+	// room_id 按房间目录定位，其他列按一致性哈希定位
+	shardKey string
+	// create 为 true 时由存储后端按 types 建表（配置中声明的数据集），否则由版本迁移建表
+	create bool
+}
+
+// roomShardKey 按房间分片的数据集的分片键
+const roomShardKey = "room_id"
+
+// userTable 不分片的用户表
+var userTable = tableSpec{
+	base:    "user",
+	aliases: []string{"user_table"},
+	columns: []string{"id", "user_name", "email", "password"},
+	types:   map[string]string{"id": "VARCHAR(64)", "user_name": "VARCHAR(64)", "email": "VARCHAR(100)", "password": "VARCHAR(256)"},
+	pk:      []string{"id"},
+}
+
+// roomTables 按 room_id 分片的数据集：内置的 document、permission、content，
+// 以及配置中 shard_by: room_id 的数据集。rebalance 按房间迁移这些表中的行
+var roomTables = []tableSpec{
+	{
+		base:     "document",
+		aliases:  []string{"user_room_table"},
+		columns:  []string{"room_id", "room_name", "create_time", "overall_permission", "owner_user_id"},
+		types:    map[string]string{"room_id": "VARCHAR(64)", "room_name": "VARCHAR(128)", "create_time": "TIMESTAMP", "overall_permission": "INT", "owner_user_id": "VARCHAR(64)"},
+		pk:       []string{"room_id"},
+		shardKey: roomShardKey,
+	},
+	{
+		base:     "permission",
+		aliases:  []string{"room_permission_table"},
+		columns:  []string{"room_id", "user_id", "permission"},
+		types:    map[string]string{"room_id": "VARCHAR(64)", "user_id": "VARCHAR(64)", "permission": "INT"},
+		pk:       []string{"room_id", "user_id"},
+		shardKey: roomShardKey,
+	},
+	{
+		base:     "content",
+		aliases:  []string{"room_content_table"},
+		columns:  []string{"room_id", "content"},
+		types:    map[string]string{"room_id": "VARCHAR(64)", "content": "TEXT"},
+		pk:       []string{"room_id"},
+		shardKey: roomShardKey,
+	},
+}
+
+// declaredTables 配置中声明的数据集，由 RegisterDatasets 添加
+var declaredTables []tableSpec
+
+// datasets 数据集名和别名 -> 逻辑表
+var datasets = make(map[string]tableSpec)
+
+func init() {
+	addDataset(userTable)
+	for _, t := range roomTables {
+		addDataset(t)
+	}
+}
+
+func addDataset(t tableSpec) {
+	datasets[t.base] = t
+	for _, alias := range t.aliases {
+		datasets[alias] = t
+	}
+}
+
+// allTables 返回所有数据集：内置的在前，配置中声明的按声明顺序在后
+func allTables() []tableSpec {
+	tables := []tableSpec{userTable}
+	for _, t := range roomTables {
+		if !t.create {
+			tables = append(tables, t)
+		}
+	}
+	return append(tables, declaredTables...)
+}
+
+// keyedTables 按 room_id 以外的列分片的数据集
+func keyedTables() []tableSpec {
+	var tables []tableSpec
+	for _, t := range declaredTables {
+		if t.sharded() && t.shardKey != roomShardKey {
+			tables = append(tables, t)
+		}
+	}
+	return tables
+}
+
+// reservedTables model 和 store 内部使用的表，数据集不能使用这些名称
+var reservedTables = []string{"schema_migrations", "rebalance_journal", "twopc_log", "room_directory", "user_rooms"}
+
+var (
+	// shardSuffix 分片表的后缀，数据集名以它结尾会与其他数据集的分片表重名
+	shardSuffix = regexp.MustCompile(`_[0-9]+$`)
+	// typeSyntax 列类型的写法：类型名（单词之间一个或多个空格，如 DOUBLE PRECISION）加可选的长度或精度
+	typeSyntax = regexp.MustCompile(`^([A-Za-z][A-Za-z0-9]*(?: +[A-Za-z][A-Za-z0-9]*)*) *(?:\( *([0-9]+) *(?:, *([0-9]+) *)?\))?$`)
+)
+
+// columnTypes 数据集允许使用的 openGauss 列类型（大写，单词之间一个空格）及最多可带的参数个数，
+// 如 VARCHAR(64) 的长度、NUMERIC(10, 2) 的精度和小数位数
+var columnTypes = map[string]int{
+	"SMALLINT": 0, "INT": 0, "INTEGER": 0, "BIGINT": 0, "INT2": 0, "INT4": 0, "INT8": 0,
+	"REAL": 0, "FLOAT4": 0, "FLOAT8": 0, "DOUBLE PRECISION": 0, "FLOAT": 1, "NUMERIC": 2, "DECIMAL": 2,
+	"BOOLEAN": 0, "BOOL": 0,
+	"CHAR": 1, "CHARACTER": 1, "VARCHAR": 1, "CHARACTER VARYING": 1, "VARCHAR2": 1, "NVARCHAR2": 1, "TEXT": 0, "CLOB": 0,
+	"BYTEA": 0, "BLOB": 0,
+	"DATE": 0, "TIME": 1, "TIMESTAMP": 1, "TIMESTAMPTZ": 1,
+	"TIMESTAMP WITH TIME ZONE": 0, "TIMESTAMP WITHOUT TIME ZONE": 0,
+	"JSON": 0, "JSONB": 0, "UUID": 0,
+}
+
+// validColumnType 检查列类型是否是 columnTypes 中的类型名加上它允许的长度或精度
+func validColumnType(typ string) bool {
+	m := typeSyntax.FindStringSubmatch(strings.TrimSpace(typ))
+	if m == nil {
+		return false
+	}
+	name := strings.ToUpper(strings.Join(strings.Fields(m[1]), " "))
+	maxParams, ok := columnTypes[name]
+	if !ok {
+		return false
+	}
+	params := 0
+	for _, p := range m[2:] {
+		if p != "" {
+			params++
+		}
+	}
+	return params <= maxParams
+}
+
+// RegisterDatasets 注册配置中声明的数据集，须在 TableDefs 和 Use 之前调用。
+// 数据集名、别名、列名必须是合法的标识符且不能与已有的数据集或内部表重名；
+// 主键的列必须已声明，分片键必须是主键的一部分
+func RegisterDatasets(defs []config.Dataset) error {
+	for _, d := range defs {
+		t, err := newTableSpec(d)
+		if err != nil {
+			return fmt.Errorf("dataset %q: %v", d.Name, err)
+		}
+		addDataset(t)
+		declaredTables = append(declaredTables, t)
+		if t.shardKey == roomShardKey {
+			roomTables = append(roomTables, t)
+		}
+	}
+	return nil
+}
+
+func newTableSpec(d config.Dataset) (tableSpec, error) {
+	t := tableSpec{base: d.Name, aliases: d.Aliases, types: make(map[string]string), pk: d.PrimaryKey, shardKey: d.ShardBy, create: true}
+	for _, name := range append([]string{d.Name}, d.Aliases...) {
+		if !identPattern.MatchString(name) || shardSuffix.MatchString(name) {
+			return t, fmt.Errorf("invalid name %q: use lowercase letters, digits and underscores, not ending in _<number>", name)
+		}
+		if _, ok := datasets[name]; ok || containsString(reservedTables, name) {
+			return t, fmt.Errorf("name %q is already in use", name)
+		}
+	}
+
+	if len(d.Columns) == 0 {
+		return t, fmt.Errorf("no columns")
+	}
+	for _, c := range d.Columns {
+		if !identPattern.MatchString(c.Name) {
+			return t, fmt.Errorf("invalid column name %q", c.Name)
+		}
+		if _, ok := t.types[c.Name]; ok {
+			return t, fmt.Errorf("duplicate column %q", c.Name)
+		}
+		if !validColumnType(c.Type) {
+			return t, fmt.Errorf("column %s: invalid type %q", c.Name, c.Type)
+		}
+		t.columns = append(t.columns, c.Name)
+		t.types[c.Name] = c.Type
+	}
+
+	if len(d.PrimaryKey) == 0 {
+		return t, fmt.Errorf("primary_key is required")
+	}
+	for i, col := range d.PrimaryKey {
+		if !t.hasColumn(col) {
+			return t, fmt.Errorf("primary key column %q is not declared", col)
+		}
+		if containsString(d.PrimaryKey[:i], col) {
+			return t, fmt.Errorf("duplicate primary key column %q", col)
+		}
+	}
+	// 主键唯一性只在单个分片内保证，分片键在主键中才能保证全局唯一
+	if d.ShardBy != "" && !containsString(d.PrimaryKey, d.ShardBy) {
+		return t, fmt.Errorf("shard_by column %q must be part of the primary key", d.ShardBy)
+	}
+	// 分片键按文本形式定位分片，只有文本和整数的文本形式是唯一的
+	if d.ShardBy != "" && !isTextType(t.types[d.ShardBy]) && !isIntegerType(t.types[d.ShardBy]) {
+		return t, fmt.Errorf("shard_by column %q must be a text or integer column", d.ShardBy)
+	}
+	return t, nil
+}
+
+var (
+	// ErrUnknownDataset 请求中的数据集名不是已注册的数据集或别名
+	ErrUnknownDataset = errors.New("unknown dataset")
+	// ErrUnknownColumn 请求中引用了数据集中不存在的列
+	ErrUnknownColumn = errors.New("unknown column")
+	// ErrInvalidIdentifier 数据集名或列名不是合法的标识符
+	ErrInvalidIdentifier = errors.New("invalid identifier")
+)
+
+// FieldError 请求的某个字段引用了未知的数据集或列，handler 返回 400
+type FieldError struct {
+	// Field 请求中的字段名，如 dataset_name、goal_key、data
+	Field string
+	Value string
+	Err   error
+}
+
+func (e *FieldError) Error() string {
+	return fmt.Sprintf("invalid %s %q: %v", e.Field, e.Value, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// identPattern 合法的标识符：小写字母或下划线开头，只含小写字母、数字和下划线
+var identPattern = regexp.MustCompile(`^[a-z_][a-z0-9_]{0,62}$`)
+
+// lookupTable 根据数据集名称（含 user_table、room_content_table 等别名）返回逻辑表
+func lookupTable(datasetName string) (tableSpec, bool) {
+	t, ok := datasets[datasetName]
+	return t, ok
+}
+
+// datasetTable 同 lookupTable，查不到时返回 dataset_name 字段的 *FieldError
+func datasetTable(datasetName string) (tableSpec, error) {
+	if t, ok := lookupTable(datasetName); ok {
+		return t, nil
+	}
+	if !identPattern.MatchString(datasetName) {
+		return tableSpec{}, &FieldError{Field: "dataset_name", Value: datasetName, Err: ErrInvalidIdentifier}
+	}
+	return tableSpec{}, &FieldError{Field: "dataset_name", Value: datasetName, Err: ErrUnknownDataset}
+}
+
+// checkColumn 检查 col 是 t 的列，否则返回 field 字段的 *FieldError
+func (t tableSpec) checkColumn(field, col string) error {
+	if t.hasColumn(col) {
+		return nil
+	}
+	if !identPattern.MatchString(col) {
+		return &FieldError{Field: field, Value: col, Err: ErrInvalidIdentifier}
+	}
+	return &FieldError{Field: field, Value: col, Err: fmt.Errorf("%w in dataset %s", ErrUnknownColumn, t.base)}
+}
+
+func (t tableSpec) hasColumn(col string) bool {
+	_, ok := t.types[col]
+	return ok
+}
+
+// bytewise 返回 cols 中的文本列，排序时按字节序比较（store.Query.Bytewise）
+func (t tableSpec) bytewise(cols []string) []string {
+	var text []string
+	for _, col := range cols {
+		if isTextType(t.types[col]) {
+			text = append(text, col)
+		}
+	}
+	return text
+}
+
+// isTextType 列类型是否为字符串类型（VARCHAR、CHAR、TEXT 等）
+func isTextType(typ string) bool {
+	name := strings.ToUpper(strings.TrimSpace(typ))
+	for _, prefix := range []string{"VARCHAR", "NVARCHAR", "CHAR", "TEXT", "CLOB"} {
+		if strings.HasPrefix(name, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isIntegerType 列类型是否为整数类型（INT、BIGINT 等），与 decodeValue 转为 int64 的类型一致
+func isIntegerType(typ string) bool {
+	name := strings.ToUpper(strings.TrimSpace(typ))
+	return strings.HasPrefix(name, "INT") || name == "BIGINT" || name == "SMALLINT"
+}
+
+// sharded 表是否按 shardKey 分布在各分片上
+func (t tableSpec) sharded() bool {
+	return t.shardKey != ""
+}
+
+// route 返回分片键取值为 key 的行所在的分片和物理表：不分片的表在 UserShard 上，
+// 按 room_id 分片的表以房间目录为准，其余按分片键的一致性哈希
+func (t tableSpec) route(ctx context.Context, key string) (store.Shard, string, error) {
+	switch {
+	case !t.sharded():
+		return userShard(ctx), t.base, nil
+	case t.shardKey == roomShardKey:
+		s, err := roomShard(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+		return s, s.Table(t.base), nil
+	}
+	s := backend(ctx).Locate(key)
+	return s, s.Table(t.base), nil
+}
+
+// shardKeyOf 返回分片键取值 v 用于定位分片的文本形式：v 先按列类型转换，
+// 整数分片键的 5、5.0、"5" 都定位到同一个分片
+func (t tableSpec) shardKeyOf(v interface{}) (string, error) {
+	dv, err := decodeValue(t.types[t.shardKey], v)
+	if err != nil {
+		return "", &FieldError{Field: t.shardKey, Value: fmt.Sprint(v), Err: err}
+	}
+	switch x := dv.(type) {
+	case string:
+		return x, nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	}
+	return "", Validation("%s must be string", t.shardKey)
+}
+
+// physicalTables 返回逻辑表对应的所有物理表：用户表只有一张，其余每个分片一张
+func physicalTables(ctx context.Context, t tableSpec) []shardTable {
+	if !t.sharded() {
+		return []shardTable{{userShard(ctx), t.base}}
+	}
+	return allShardTables(ctx, t.base)
+}
+
+// selectColumns goalKey 为 "*" 时返回整行的列，否则只取 goalKey
+func selectColumns(t tableSpec, goalKey string) []string {
+	if goalKey == "*" {
+		return t.columns
+	}
+	return []string{goalKey}
+}
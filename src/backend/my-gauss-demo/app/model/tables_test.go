@@ -0,0 +1,63 @@
+package model
+
+import (
+	"strings"
+	"testing"
+
+	"my-gauss-app/config"
+)
+
+func TestValidColumnType(t *testing.T) {
+	valid := []string{
+		"INT", "bigint", "VARCHAR(64)", "varchar (64)", "NUMERIC(10, 2)", "numeric(10,2)",
+		"DOUBLE PRECISION", "double  precision", "TIMESTAMP", "TIMESTAMP(3)", "TIMESTAMP WITH TIME ZONE", "TEXT", "JSONB",
+	}
+	for _, typ := range valid {
+		if !validColumnType(typ) {
+			t.Errorf("validColumnType(%q) = false, want true", typ)
+		}
+	}
+
+	invalid := []string{
+		"", "INT PRIMARY KEY", "TEXT DEFAULT now", "VARCHAR(64) NOT NULL", "INT REFERENCES user",
+		"TEXT COLLATE C", "SERIAL", "MONEY", "INT(1)", "VARCHAR(1, 2)", "NUMERIC(1, 2, 3)", "VARCHAR(64", "INT; DROP TABLE user",
+	}
+	for _, typ := range invalid {
+		if validColumnType(typ) {
+			t.Errorf("validColumnType(%q) = true, want false", typ)
+		}
+	}
+}
+
+func TestNewTableSpecErrors(t *testing.T) {
+	base := func() config.Dataset {
+		return config.Dataset{
+			Name:       "note",
+			Columns:    []config.Column{{Name: "id", Type: "INT"}, {Name: "body", Type: "TEXT"}},
+			PrimaryKey: []string{"id"},
+		}
+	}
+	if _, err := newTableSpec(base()); err != nil {
+		t.Fatalf("valid dataset rejected: %v", err)
+	}
+
+	cases := []struct {
+		name   string
+		change func(d *config.Dataset)
+		want   string
+	}{
+		{"type with constraint", func(d *config.Dataset) { d.Columns[1].Type = "TEXT NOT NULL" }, "invalid type"},
+		{"reserved name", func(d *config.Dataset) { d.Name = "room_directory" }, "already in use"},
+		{"shard suffix", func(d *config.Dataset) { d.Name = "note_1" }, "invalid name"},
+		{"undeclared key", func(d *config.Dataset) { d.PrimaryKey = []string{"seq"} }, "not declared"},
+		{"shard key outside primary key", func(d *config.Dataset) { d.ShardBy = "body" }, "part of the primary key"},
+		{"numeric shard key", func(d *config.Dataset) { d.Columns[0].Type = "NUMERIC(10, 2)"; d.ShardBy = "id" }, "text or integer"},
+	}
+	for _, c := range cases {
+		d := base()
+		c.change(&d)
+		if _, err := newTableSpec(d); err == nil || !strings.Contains(err.Error(), c.want) {
+			t.Errorf("%s: err = %v, want %q", c.name, err, c.want)
+		}
+	}
+}
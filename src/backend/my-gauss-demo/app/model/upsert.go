@@ -0,0 +1,80 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+
+	"my-gauss-app/store"
+)
+
+// UpsertDataset 按主键插入或更新一行：主键不存在时插入整行（同 InsertDataIntoDataset），
+// 已存在时只覆盖 update 中的列。update 为 nil 时覆盖 data 中除主键外的所有列，
+// 为空数组时保持已有的行不变。data 必须包含所有主键列。
+// 在目标分片上原子地完成（permission 与 user_rooms 一起提交），返回插入、更新或保持不变
+func UpsertDataset(ctx context.Context, datasetName string, data map[string]interface{}, update []string) (store.UpsertResult, error) {
+	ctx = forWrite(ctx)
+	t, err := datasetTable(datasetName)
+	if err != nil {
+		return 0, err
+	}
+	target, table, row, err := prepareRow(ctx, t, data)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, col := range t.pk {
+		if row[col] == nil {
+			return 0, Validation("upsert into %s requires primary key column '%s'", t.base, col)
+		}
+	}
+
+	if update == nil {
+		for _, col := range t.columns {
+			if _, ok := data[col]; ok && !containsString(t.pk, col) {
+				update = append(update, col)
+			}
+		}
+	}
+	for _, col := range update {
+		if err := t.checkColumn("update_columns", col); err != nil {
+			return 0, err
+		}
+		if containsString(t.pk, col) {
+			return 0, &FieldError{Field: "update_columns", Value: col, Err: errors.New("primary key columns cannot be updated")}
+		}
+		if _, ok := data[col]; !ok {
+			return 0, &FieldError{Field: "update_columns", Value: col, Err: errors.New("column is missing from data")}
+		}
+	}
+
+	result, err := upsertRow(ctx, t, target, table, row, update)
+	if err != nil {
+		log.Printf("Upsert into %s failed: %v", table, err)
+		return 0, fmt.Errorf("upsert failed: %w", err)
+	}
+	return result, nil
+}
+
+// upsertRow 在分片 s 上用一条语句插入或更新 row（store.Executor.Upsert）；
+// permission 在同一事务中更新 user_rooms，与 permission 一起提交
+func upsertRow(ctx context.Context, t tableSpec, s store.Shard, table string, row store.Row, update []string) (store.UpsertResult, error) {
+	if t.base != "permission" {
+		return s.Upsert(ctx, table, row, update)
+	}
+
+	var result store.UpsertResult
+	err := withUserRooms(ctx, s, func(tx, index store.Tx) error {
+		var err error
+		if result, err = tx.Upsert(ctx, table, row, update); err != nil {
+			return err
+		}
+		// 已有的行没有更新 permission 列时，user_rooms 中的记录不变
+		if result != store.UpsertInserted && !containsString(update, "permission") {
+			return nil
+		}
+		return indexPermission(ctx, index, row)
+	})
+	return result, err
+}
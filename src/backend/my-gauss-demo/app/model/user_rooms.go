@@ -0,0 +1,139 @@
+package model
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// userRoomsTable user_rooms 全局二级索引：user_id -> 可访问的房间及权限，存放在 UserShard 上。
+// 与各分片上的 permission 表在同一个（两阶段）事务中修改，按用户查房间时不必扫描所有分片
+var userRoomsTable = store.TableDef{
+	Name:       "user_rooms",
+	Columns:    []string{"user_id", "room_id", "permission"},
+	PrimaryKey: []string{"user_id", "room_id"},
+}
+
+// UserRooms 返回用户可访问的房间（room_id、permission），按 room_id 排序，只查询 user_rooms 索引
+func UserRooms(ctx context.Context, userID string) ([]map[string]interface{}, error) {
+	rows, err := store.Select(ctx, userShard(ctx), store.Query{
+		Table:    userRoomsTable.Name,
+		Columns:  []string{"room_id", "permission"},
+		Where:    []store.Cond{{Column: "user_id", Value: userID}},
+		OrderBy:  []string{"room_id"},
+		Bytewise: []string{"room_id"},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("query user_rooms failed: %w", err)
+	}
+	if rows == nil {
+		rows = []map[string]interface{}{}
+	}
+	return rows, nil
+}
+
+// withUserRooms 在房间所在分片 s 上开启事务 tx，在 UserShard 上开启维护 user_rooms 的事务 index
+// （s 就是 UserShard 时二者是同一个事务），fn 成功后一起提交：不在同一实例时通过两阶段提交，
+// permission 和 user_rooms 要么同时生效，要么都不生效
+func withUserRooms(ctx context.Context, s store.Shard, fn func(tx, index store.Tx) error) error {
+	tx, err := s.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	index := tx
+	if s.Name() != userShard(ctx).Name() {
+		if index, err = userShard(ctx).Begin(ctx); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := fn(tx, index); err != nil {
+		tx.Rollback()
+		if index != tx {
+			index.Rollback()
+		}
+		return err
+	}
+
+	if index == tx {
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("commit on %s failed: %w", s.Name(), err)
+		}
+		return nil
+	}
+	txnID := fmt.Sprintf("userrooms_%d", time.Now().UnixNano())
+	return store.CommitTwoPhase(ctx, backend(ctx), txnID, []store.Tx{tx, index})
+}
+
+// indexPermission 按 permission 行写入或更新 user_rooms 中的记录
+func indexPermission(ctx context.Context, index store.Tx, row store.Row) error {
+	if row["user_id"] == nil || row["room_id"] == nil {
+		return nil
+	}
+	key := []store.Cond{{Column: "user_id", Value: row["user_id"]}, {Column: "room_id", Value: row["room_id"]}}
+	n, err := index.Update(ctx, userRoomsTable.Name, store.Row{"permission": row["permission"]}, key)
+	if err != nil {
+		return fmt.Errorf("update user_rooms failed: %w", err)
+	}
+	if n > 0 {
+		return nil
+	}
+	err = index.Insert(ctx, userRoomsTable.Name, store.Row{
+		"user_id":    row["user_id"],
+		"room_id":    row["room_id"],
+		"permission": row["permission"],
+	})
+	if err != nil {
+		return fmt.Errorf("insert into user_rooms failed: %w", err)
+	}
+	return nil
+}
+
+// unindexPermissions 删除 user_rooms 中满足 where（只含 room_id/user_id 条件）的记录
+func unindexPermissions(ctx context.Context, index store.Tx, where []store.Cond) error {
+	if _, err := index.Delete(ctx, userRoomsTable.Name, where); err != nil {
+		return fmt.Errorf("delete from user_rooms failed: %w", err)
+	}
+	return nil
+}
+
+// RebuildUserRooms 按所有分片上的 permission 表重建 user_rooms，
+// 用于首次启用索引或索引与 permission 不一致时。返回写入的记录数
+func RebuildUserRooms(ctx context.Context) (int, error) {
+	t, _ := lookupTable("permission")
+	rows, err := scatterRows(ctx, allShardTables(ctx, t.base), store.Query{Columns: t.columns})
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := userShard(ctx).Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if err := rebuildIndex(ctx, tx, rows); err != nil {
+		tx.Rollback()
+		return 0, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("commit user_rooms failed: %w", err)
+	}
+	log.Printf("Rebuilt user_rooms from %d permission row(s)", len(rows))
+	return len(rows), nil
+}
+
+// rebuildIndex 在事务 index 中清空 user_rooms 并按 rows（permission 行）重新写入
+func rebuildIndex(ctx context.Context, index store.Tx, rows []map[string]interface{}) error {
+	if err := index.Truncate(ctx, userRoomsTable.Name); err != nil {
+		return fmt.Errorf("truncate user_rooms failed: %w", err)
+	}
+	for _, row := range rows {
+		if err := indexPermission(ctx, index, row); err != nil {
+			return err
+		}
+	}
+	return nil
+}
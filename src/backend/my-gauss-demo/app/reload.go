@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"my-gauss-app/config"
+	"my-gauss-app/db"
+	"my-gauss-app/model"
+)
+
+// DrainTimeout 热加载后等待旧连接池上的请求结束的最长时间，超时后直接关闭
+var DrainTimeout = 30 * time.Second
+
+// ConfigPollInterval 检查配置文件是否修改的间隔
+var ConfigPollInterval = 2 * time.Second
+
+// reloader 在收到 SIGHUP 或配置文件修改时重新加载分片和连接池配置
+type reloader struct {
+	mu    sync.Mutex
+	cfg   *config.Config
+	store *db.Store
+}
+
+// watch 监听 SIGHUP 并轮询配置文件的修改时间，触发时执行 reload
+func (r *reloader) watch() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	path, _ := config.Path()
+	lastMod := modTime(path)
+	ticker := time.NewTicker(ConfigPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hup:
+			log.Println("Reload: received SIGHUP")
+			lastMod = modTime(path)
+			r.reload()
+		case <-ticker.C:
+			if m := modTime(path); !m.Equal(lastMod) {
+				lastMod = m
+				log.Printf("Reload: %s changed", path)
+				r.reload()
+			}
+		}
+	}
+}
+
+// reload 加载新配置并切换存储后端；配置无效或新分片不可用时保留当前配置
+func (r *reloader) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cfg, err := config.Load()
+	if err == nil {
+		cfg, err = reloadConfig(r.cfg, cfg)
+	}
+	if err != nil {
+		log.Printf("Reload rejected: %v", err)
+		return
+	}
+
+	next, err := r.store.Reload(context.Background(), cfg)
+	if err != nil {
+		log.Printf("Reload rejected: %v", err)
+		return
+	}
+
+	old := r.store
+	drained := model.Swap(next)
+	r.store, r.cfg = next, cfg
+	log.Printf("Reload: switched to %d shard(s)", len(cfg.Shards))
+
+	go func() {
+		select {
+		case <-drained:
+		case <-time.After(DrainTimeout):
+			log.Printf("Reload: requests on old pools still running after %s, closing anyway", DrainTimeout)
+		}
+		old.Retire(next)
+	}()
+}
+
+// reloadConfig 检查新配置能否热加载：存储后端不能切换；监听地址和超时在重启后才生效；
+// 数据集的修改在重启后才生效，返回的配置沿用当前的数据集
+func reloadConfig(cur, cfg *config.Config) (*config.Config, error) {
+	if cfg.Store != cur.Store {
+		return nil, fmt.Errorf("store cannot change from %s to %s without restart", cur.Store, cfg.Store)
+	}
+	if cfg.Listen != cur.Listen || !reflect.DeepEqual(cfg.Timeouts, cur.Timeouts) {
+		log.Println("Reload: listen address and timeouts only take effect after restart")
+	}
+	if !reflect.DeepEqual(cfg.Datasets, cur.Datasets) {
+		log.Println("Reload: dataset changes only take effect after restart")
+		cfg.Datasets = cur.Datasets
+	}
+	return cfg, nil
+}
+
+func modTime(path string) time.Time {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}
+	}
+	return fi.ModTime()
+}
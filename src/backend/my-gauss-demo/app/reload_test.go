@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"my-gauss-app/config"
+)
+
+// loadConfig 把 content 写入 GAUSS_CONFIG 指向的文件并加载
+func loadConfig(t *testing.T, path, content string) *config.Config {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+const reloadBase = `
+store: memory
+shards:
+  - name: og1
+  - name: og2
+datasets:
+  - name: note
+    columns:
+      - {name: id, type: VARCHAR(64)}
+    primary_key: [id]
+`
+
+func TestReloadConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	t.Setenv("GAUSS_CONFIG", path)
+	cur := loadConfig(t, path, reloadBase)
+
+	// 分片和连接池的修改直接生效，数据集的修改沿用当前配置
+	changed := strings.Replace(reloadBase, "  - name: og2\n", "  - name: og2\n  - name: og3\n", 1)
+	changed = strings.Replace(changed, "VARCHAR(64)", "VARCHAR(128)", 1) + "pool:\n  max_open_conns: 7\n"
+	next, err := reloadConfig(cur, loadConfig(t, path, changed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(next.ShardNames(), ","); got != "og1,og2,og3" {
+		t.Errorf("shards = %s, want og1,og2,og3", got)
+	}
+	if next.Pool.MaxOpenConns != 7 {
+		t.Errorf("max_open_conns = %d, want 7", next.Pool.MaxOpenConns)
+	}
+	if typ := next.Datasets[0].Columns[0].Type; typ != "VARCHAR(64)" {
+		t.Errorf("dataset column type = %s, want the current VARCHAR(64)", typ)
+	}
+
+	// 监听地址的修改只记录日志，配置仍然可以加载
+	if _, err := reloadConfig(cur, loadConfig(t, path, reloadBase+"listen: \":9090\"\n")); err != nil {
+		t.Errorf("listen change: %v", err)
+	}
+
+	// 存储后端不能热切换
+	gauss := loadConfig(t, path, reloadBase)
+	gauss.Store = "gauss"
+	_, err = reloadConfig(cur, gauss)
+	if err == nil || !strings.Contains(err.Error(), "store cannot change") {
+		t.Errorf("store change: err = %v, want rejection", err)
+	}
+}
+
+func TestModTime(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if !modTime(path).IsZero() {
+		t.Error("modTime of a missing file is not zero")
+	}
+	if err := os.WriteFile(path, []byte(reloadBase), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	first := modTime(path)
+	later := first.Add(time.Second)
+	if err := os.Chtimes(path, later, later); err != nil {
+		t.Fatal(err)
+	}
+	// watch 通过修改时间的变化发现配置文件被修改
+	if m := modTime(path); m.Equal(first) || !m.Equal(later) {
+		t.Errorf("modTime = %v, want %v", m, later)
+	}
+}
@@ -0,0 +1,90 @@
+// shard/ring.go
+package shard
+
+import (
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// DefaultVirtualNodes 每个物理分片在哈希环上的虚拟节点数
+const DefaultVirtualNodes = 160
+
+// Ring 一致性哈希环：每个分片按名称在环上放置若干虚拟节点，
+// key 顺时针找到的第一个虚拟节点即为其所属分片。
+// 新增分片时只有落在新虚拟节点区间内的 key 会迁移。
+type Ring struct {
+	vnodes int
+	points []uint32          // 已排序的虚拟节点 hash
+	owners map[uint32]string // 虚拟节点 hash -> 分片名
+	names  []string
+}
+
+// NewRing 根据分片名构建哈希环，vnodes <= 0 时使用 DefaultVirtualNodes
+func NewRing(names []string, vnodes int) (*Ring, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("ring requires at least one shard")
+	}
+	if vnodes <= 0 {
+		vnodes = DefaultVirtualNodes
+	}
+
+	r := &Ring{
+		vnodes: vnodes,
+		owners: make(map[uint32]string, len(names)*vnodes),
+		names:  append([]string(nil), names...),
+	}
+
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if name == "" {
+			return nil, fmt.Errorf("shard name must not be empty")
+		}
+		if seen[name] {
+			return nil, fmt.Errorf("duplicate shard name: %s", name)
+		}
+		seen[name] = true
+
+		for i := 0; i < vnodes; i++ {
+			h := hashKey(fmt.Sprintf("%s#%d", name, i))
+			// 极少数情况下两个虚拟节点 hash 冲突，保留先放置的那个即可
+			if _, ok := r.owners[h]; ok {
+				continue
+			}
+			r.owners[h] = name
+			r.points = append(r.points, h)
+		}
+	}
+
+	sort.Slice(r.points, func(i, j int) bool { return r.points[i] < r.points[j] })
+	return r, nil
+}
+
+// Locate 返回 key 所属的分片名
+func (r *Ring) Locate(key string) string {
+	h := hashKey(key)
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= h })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.owners[r.points[i]]
+}
+
+// Names 返回环上的分片名（按构建时的顺序）
+func (r *Ring) Names() []string {
+	return append([]string(nil), r.names...)
+}
+
+// hashKey 对 key 求 32 位 hash：FNV-1a 之后再做一次 murmur3 的 fmix 混淆，
+// 避免 6 位数字 room_id 这类相近 key 聚集在环的同一区段
+func hashKey(key string) uint32 {
+	f := fnv.New32a()
+	f.Write([]byte(key))
+	h := f.Sum32()
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
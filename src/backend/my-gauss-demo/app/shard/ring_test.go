@@ -0,0 +1,81 @@
+package shard
+
+import (
+	"fmt"
+	"testing"
+)
+
+// roomIDs 生成 n 个 6 位数字的 room_id，与线上 room_id 的形式相同
+func roomIDs(n int) []string {
+	ids := make([]string, n)
+	for i := range ids {
+		ids[i] = fmt.Sprintf("%06d", 100000+i)
+	}
+	return ids
+}
+
+func TestRingDistribution(t *testing.T) {
+	for _, names := range [][]string{{"og1", "og2"}, {"og1", "og2", "og3"}, {"og1", "og2", "og3", "og4"}} {
+		r, err := NewRing(names, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys := roomIDs(60000)
+		counts := make(map[string]int)
+		for _, key := range keys {
+			counts[r.Locate(key)]++
+		}
+
+		fair := float64(len(keys)) / float64(len(names))
+		for _, name := range names {
+			if dev := (float64(counts[name]) - fair) / fair; dev < -0.15 || dev > 0.15 {
+				t.Errorf("%d shards: %s got %d keys, %.1f%% off the fair share", len(names), name, counts[name], dev*100)
+			}
+		}
+	}
+}
+
+func TestRingAddShardMovesOnlyToNewShard(t *testing.T) {
+	before, err := NewRing([]string{"og1", "og2"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	after, err := NewRing([]string{"og1", "og2", "og3"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := roomIDs(30000)
+	moved := 0
+	for _, key := range keys {
+		from, to := before.Locate(key), after.Locate(key)
+		if from == to {
+			continue
+		}
+		if to != "og3" {
+			t.Fatalf("key %s moved from %s to %s, want moves only onto the new shard", key, from, to)
+		}
+		moved++
+	}
+	if share := float64(moved) / float64(len(keys)); share < 0.25 || share > 0.42 {
+		t.Errorf("%.1f%% of keys moved after adding a third shard, want about a third", share*100)
+	}
+}
+
+func TestRingLocateIsDeterministic(t *testing.T) {
+	a, _ := NewRing([]string{"og1", "og2"}, 0)
+	b, _ := NewRing([]string{"og1", "og2"}, 0)
+	for _, key := range roomIDs(1000) {
+		if a.Locate(key) != b.Locate(key) {
+			t.Fatalf("key %s located differently by two identical rings", key)
+		}
+	}
+}
+
+func TestNewRingRejectsInvalidNames(t *testing.T) {
+	for _, names := range [][]string{nil, {"og1", ""}, {"og1", "og1"}} {
+		if _, err := NewRing(names, 0); err == nil {
+			t.Errorf("NewRing(%q) succeeded, want error", names)
+		}
+	}
+}
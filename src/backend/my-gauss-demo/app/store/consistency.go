@@ -0,0 +1,23 @@
+// store/consistency.go
+package store
+
+import "context"
+
+type readPreferenceKey struct{}
+
+// ReadFromReplica 返回允许从只读副本读取的 ctx：分片上（非事务）的 Scan/Count 会发往副本，
+// 可能读不到刚提交的写入。只应用于对一致性没有要求的查询接口
+func ReadFromReplica(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readPreferenceKey{}, true)
+}
+
+// ReadFromPrimary 返回强制读主库的 ctx，覆盖外层的 ReadFromReplica
+func ReadFromPrimary(ctx context.Context) context.Context {
+	return context.WithValue(ctx, readPreferenceKey{}, false)
+}
+
+// UseReplica ctx 是否允许从只读副本读取；默认读主库
+func UseReplica(ctx context.Context) bool {
+	v, _ := ctx.Value(readPreferenceKey{}).(bool)
+	return v
+}
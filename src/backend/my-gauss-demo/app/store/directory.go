@@ -0,0 +1,226 @@
+// store/directory.go
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// DirectoryCacheTTL 目录缓存的有效期。写入不经过缓存（LookupForWrite），读取可能使用这么久以前的位置。
+// 迁移房间时先标记为迁移中，至少等待 DirectoryCacheTTL 之后才复制并切换目录（等待时间取它与 model.MoveFenceWait 的较大值，
+// 重平衡时同一批房间一起标记、只等待一次，见 model.RebalanceBatch）：
+// 标记之前缓存的位置届时都已过期，标记之后读到的迁移中记录不会从缓存返回，
+// 因此切换之后没有进程再按旧位置读取，源分片上的数据可以删除。调整该值时迁移的等待时间随之变化
+var DirectoryCacheTTL = 30 * time.Second
+
+// DirectoryCacheSize 目录缓存最多保存的房间数，满了以后先清理过期的记录，仍然满时随机淘汰
+var DirectoryCacheSize = 100000
+
+// DirectoryTable room_directory 表结构，存放在 UserShard 上
+var DirectoryTable = TableDef{
+	Name:       "room_directory",
+	Columns:    []string{"room_id", "shard_name", "pinned", "migrating", "updated_at"},
+	PrimaryKey: []string{"room_id"},
+}
+
+// ErrRoomMigrating 房间正在迁移，暂时不接受写入
+var ErrRoomMigrating = errors.New("room is being migrated")
+
+// Placement room_directory 中的一条记录
+type Placement struct {
+	RoomID    string
+	ShardName string
+	// Pinned 为 true 表示人工指定的位置，重平衡时不会按 hash 移走
+	Pinned bool
+	// Migrating 为 true 表示房间正在迁移（Fence 之后从 ShardName 迁出，Handover 之后迁入 ShardName），写入会被拒绝
+	Migrating bool
+}
+
+type directoryEntry struct {
+	placement *Placement
+	expires   time.Time
+}
+
+// Directory room_id -> 分片 的目录表，带进程内缓存。只缓存目录中存在的房间：
+// 任意 room_id 的查询都会走到这里，缓存不存在的房间会被无效的 room_id 占满
+type Directory struct {
+	exec  Executor
+	mu    sync.RWMutex
+	cache map[string]directoryEntry
+}
+
+// NewDirectory 基于 exec 上的 room_directory 表创建目录
+func NewDirectory(exec Executor) *Directory {
+	return &Directory{exec: exec, cache: make(map[string]directoryEntry)}
+}
+
+// Lookup 查询房间在目录中的位置，目录中没有时返回 nil
+func (d *Directory) Lookup(ctx context.Context, roomID string) (*Placement, error) {
+	d.mu.RLock()
+	e, ok := d.cache[roomID]
+	d.mu.RUnlock()
+	// 迁移中的房间随时可能切换分片，不使用缓存
+	if ok && time.Now().Before(e.expires) && !e.placement.Migrating {
+		return e.placement, nil
+	}
+	return d.load(ctx, roomID)
+}
+
+// LookupForWrite 不经过缓存查询房间的位置：其他进程修改目录后写入立即看到新位置，
+// 房间正在迁移时返回 ErrRoomMigrating
+func (d *Directory) LookupForWrite(ctx context.Context, roomID string) (*Placement, error) {
+	p, err := d.load(ctx, roomID)
+	if err != nil {
+		return nil, err
+	}
+	if p != nil && p.Migrating {
+		return nil, fmt.Errorf("room %s: %w", roomID, ErrRoomMigrating)
+	}
+	return p, nil
+}
+
+// load 从 room_directory 读取房间的位置并更新缓存
+func (d *Directory) load(ctx context.Context, roomID string) (*Placement, error) {
+	// 房间迁移后目录必须立即可见，不从副本读取
+	row, err := First(ReadFromPrimary(ctx), d.exec, Query{
+		Table:   DirectoryTable.Name,
+		Columns: []string{"room_id", "shard_name", "pinned", "migrating"},
+		Where:   []Cond{{"room_id", roomID}},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("lookup room_directory failed: %w", err)
+	}
+	if row == nil {
+		d.Invalidate(roomID)
+		return nil, nil
+	}
+
+	p := &Placement{RoomID: roomID}
+	p.ShardName, _ = row["shard_name"].(string)
+	p.Pinned, _ = row["pinned"].(bool)
+	p.Migrating, _ = row["migrating"].(bool)
+	d.store(roomID, p)
+	return p, nil
+}
+
+// Register 将房间登记到指定分片；房间已在目录中时保持原记录不变
+func (d *Directory) Register(ctx context.Context, roomID string, shardName string) error {
+	if p, err := d.Lookup(ctx, roomID); err != nil || p != nil {
+		return err
+	}
+
+	err := d.exec.Insert(ctx, DirectoryTable.Name, Row{
+		"room_id":    roomID,
+		"shard_name": shardName,
+		"pinned":     false,
+		"migrating":  false,
+		"updated_at": time.Now(),
+	})
+	// 并发登记时另一方已经写入，保留它的记录
+	if err != nil && !errors.Is(err, ErrDuplicateKey) {
+		return fmt.Errorf("register room %s failed: %v", roomID, err)
+	}
+
+	d.Invalidate(roomID)
+	return nil
+}
+
+// Assign 设置房间的位置（覆盖已有记录并结束迁移状态），pinned 为 true 时固定在该分片
+func (d *Directory) Assign(ctx context.Context, roomID string, shardName string, pinned bool) error {
+	set := Row{"shard_name": shardName, "pinned": pinned, "migrating": false, "updated_at": time.Now()}
+	if err := d.put(ctx, roomID, set); err != nil {
+		return fmt.Errorf("assign room %s failed: %v", roomID, err)
+	}
+	d.store(roomID, &Placement{RoomID: roomID, ShardName: shardName, Pinned: pinned})
+	return nil
+}
+
+// Fence 把房间标记为正在从 shardName 迁出（保留已有的 pinned），之后 LookupForWrite 拒绝写入，
+// 直到 Handover 之后的 Unfence 或 Assign 结束迁移，或直接 Unfence 取消迁移
+func (d *Directory) Fence(ctx context.Context, roomID string, shardName string) error {
+	set := Row{"shard_name": shardName, "migrating": true, "updated_at": time.Now()}
+	if err := d.put(ctx, roomID, set); err != nil {
+		return fmt.Errorf("fence room %s failed: %v", roomID, err)
+	}
+	d.Invalidate(roomID)
+	return nil
+}
+
+// Handover 把迁移中的房间切换到 shardName 并保持迁移标记：读取立即落到新分片，写入仍被拒绝，
+// 直到删除源分片上的数据后 Unfence。pinned 为 true 时固定在该分片
+func (d *Directory) Handover(ctx context.Context, roomID string, shardName string, pinned bool) error {
+	set := Row{"shard_name": shardName, "pinned": pinned, "migrating": true, "updated_at": time.Now()}
+	if err := d.put(ctx, roomID, set); err != nil {
+		return fmt.Errorf("hand over room %s failed: %v", roomID, err)
+	}
+	d.Invalidate(roomID)
+	return nil
+}
+
+// Unfence 取消房间的迁移状态，房间留在当前分片
+func (d *Directory) Unfence(ctx context.Context, roomID string) error {
+	set := Row{"migrating": false, "updated_at": time.Now()}
+	if _, err := d.exec.Update(ctx, DirectoryTable.Name, set, []Cond{{"room_id", roomID}}); err != nil {
+		return fmt.Errorf("unfence room %s failed: %v", roomID, err)
+	}
+	d.Invalidate(roomID)
+	return nil
+}
+
+// put 按 room_id 更新目录记录，没有记录时插入（未指定的 pinned、migrating 为 false）
+func (d *Directory) put(ctx context.Context, roomID string, set Row) error {
+	n, err := d.exec.Update(ctx, DirectoryTable.Name, set, []Cond{{"room_id", roomID}})
+	if err != nil || n > 0 {
+		return err
+	}
+	row := Row{"room_id": roomID, "pinned": false, "migrating": false}
+	for col, v := range set {
+		row[col] = v
+	}
+	return d.exec.Insert(ctx, DirectoryTable.Name, row)
+}
+
+// Remove 从目录中删除房间
+func (d *Directory) Remove(ctx context.Context, roomID string) error {
+	if _, err := d.exec.Delete(ctx, DirectoryTable.Name, []Cond{{"room_id", roomID}}); err != nil {
+		return fmt.Errorf("remove room %s from directory failed: %v", roomID, err)
+	}
+	d.Invalidate(roomID)
+	return nil
+}
+
+// Invalidate 丢弃某个房间的缓存
+func (d *Directory) Invalidate(roomID string) {
+	d.mu.Lock()
+	delete(d.cache, roomID)
+	d.mu.Unlock()
+}
+
+func (d *Directory) store(roomID string, p *Placement) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.cache[roomID]; !ok && len(d.cache) >= DirectoryCacheSize {
+		d.evict()
+	}
+	d.cache[roomID] = directoryEntry{placement: p, expires: time.Now().Add(DirectoryCacheTTL)}
+}
+
+// evict 为新记录腾出空间：删除所有过期的记录，仍然超过九成容量时随机删除到九成。调用方持有 d.mu
+func (d *Directory) evict() {
+	now := time.Now()
+	for roomID, e := range d.cache {
+		if !now.Before(e.expires) {
+			delete(d.cache, roomID)
+		}
+	}
+	// map 的遍历顺序是随机的
+	for roomID := range d.cache {
+		if len(d.cache) < DirectoryCacheSize-DirectoryCacheSize/10 {
+			break
+		}
+		delete(d.cache, roomID)
+	}
+}
@@ -0,0 +1,50 @@
+package store
+
+// Op 过滤表达式的运算符
+type Op string
+
+const (
+	OpAnd    Op = "and"
+	OpOr     Op = "or"
+	OpEq     Op = "="
+	OpNe     Op = "!="
+	OpLt     Op = "<"
+	OpGt     Op = ">"
+	OpIn     Op = "in"
+	OpLike   Op = "like"
+	OpILike  Op = "ilike"
+	OpIsNull Op = "is_null"
+)
+
+// Expr 过滤表达式树：
+//   - OpAnd / OpOr：Args 中的子表达式全部 / 任一成立，Args 为空时分别为真 / 假
+//   - OpEq、OpNe、OpLt、OpGt、OpLike、OpILike：Column 与 Value 比较，NULL 与任何值比较都不成立
+//   - OpIn：Column 等于 Values 中的某个值，Values 为空时不成立
+//   - OpIsNull：Column 为 NULL；Not 为 true 时为 IS NOT NULL
+//
+// LIKE / ILIKE 按列的文本形式匹配，% 匹配任意串，_ 匹配单个字符，\ 转义
+type Expr struct {
+	Op     Op
+	Column string
+	Value  interface{}
+	Values []interface{}
+	Not    bool
+	Args   []*Expr
+}
+
+// And 把多个表达式组合为 AND，忽略 nil
+func And(exprs ...*Expr) *Expr {
+	var args []*Expr
+	for _, e := range exprs {
+		if e != nil {
+			args = append(args, e)
+		}
+	}
+	switch len(args) {
+	case 0:
+		return nil
+	case 1:
+		return args[0]
+	}
+	return &Expr{Op: OpAnd, Args: args}
+}
@@ -0,0 +1,111 @@
+package memory
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+
+	"my-gauss-app/store"
+)
+
+// matchesExpr 判断行是否满足过滤表达式，NULL 的处理与 SQL 一致
+func matchesExpr(r store.Row, e *store.Expr) bool {
+	if e == nil {
+		return true
+	}
+	switch e.Op {
+	case store.OpAnd:
+		for _, arg := range e.Args {
+			if !matchesExpr(r, arg) {
+				return false
+			}
+		}
+		return true
+	case store.OpOr:
+		for _, arg := range e.Args {
+			if matchesExpr(r, arg) {
+				return true
+			}
+		}
+		return false
+	case store.OpIsNull:
+		return (r[e.Column] == nil) != e.Not
+	}
+
+	v := r[e.Column]
+	if v == nil {
+		return false
+	}
+	switch e.Op {
+	case store.OpIn:
+		for _, want := range e.Values {
+			if want != nil && valueKey(v) == valueKey(want) {
+				return true
+			}
+		}
+		return false
+	case store.OpLike, store.OpILike:
+		pattern, ok := e.Value.(string)
+		return ok && likeRegexp(pattern, e.Op == store.OpILike).MatchString(valueKey(v))
+	}
+
+	if e.Value == nil {
+		return false
+	}
+	c := compareValues(v, e.Value)
+	switch e.Op {
+	case store.OpEq:
+		return c == 0
+	case store.OpNe:
+		return c != 0
+	case store.OpLt:
+		return c < 0
+	case store.OpGt:
+		return c > 0
+	}
+	return false
+}
+
+// compareValues 两边都是数值时按数值比较，否则按字节序比较规范化后的字符串
+func compareValues(a, b interface{}) int {
+	ka, kb := valueKey(a), valueKey(b)
+	x, errA := strconv.ParseFloat(ka, 64)
+	y, errB := strconv.ParseFloat(kb, 64)
+	if errA == nil && errB == nil {
+		switch {
+		case x < y:
+			return -1
+		case x > y:
+			return 1
+		}
+		return 0
+	}
+	return strings.Compare(ka, kb)
+}
+
+// likeRegexp 把 LIKE 模式转换为正则：% 匹配任意串，_ 匹配单个字符，\ 转义下一个字符
+func likeRegexp(pattern string, fold bool) *regexp.Regexp {
+	var b strings.Builder
+	if fold {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("(?s)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			b.WriteString(".*")
+		case c == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
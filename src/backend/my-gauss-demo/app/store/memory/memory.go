@@ -0,0 +1,385 @@
+// store/memory/memory.go
+package memory
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// 内存存储后端：与 openGauss 后端使用同一套分片、哈希环和物理表命名，
+// 用于测试和没有 openGauss 的本地开发。数据只保存在进程内。
+//
+// 事务在第一次访问某张表时复制该表，提交时只把新增、修改、删除的行写回原表：
+// 并发事务修改不同的行互不影响，同一行以后提交者为准；没有行锁和 MVCC，不适合作为生产存储。
+
+// Store 内存存储后端，实现 store.Store
+type Store struct {
+	*store.ShardSet
+	shards []*Shard
+}
+
+// New 按分片名和表结构创建内存存储，vnodes <= 0 时使用默认虚拟节点数
+func New(names []string, vnodes int, defs []store.TableDef) (*Store, error) {
+	var shards []*Shard
+	var members []store.Shard
+	for i, name := range names {
+		s := &Shard{id: i, name: name, tables: make(map[string]*table), prepared: make(map[string]*Tx)}
+		shards = append(shards, s)
+		members = append(members, s)
+	}
+
+	set, err := store.NewShardSet(members, vnodes)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, def := range defs {
+		if !def.Sharded {
+			shards[0].tables[def.Name] = newTable(def.PrimaryKey)
+			continue
+		}
+		for _, s := range shards {
+			s.tables[s.Table(def.Name)] = newTable(def.PrimaryKey)
+		}
+	}
+	return &Store{ShardSet: set, shards: shards}, nil
+}
+
+func (s *Store) Close() error {
+	return nil
+}
+
+// table 一张内存表，行按主键值索引
+type table struct {
+	pk   []string
+	rows map[string]store.Row
+	seq  int
+}
+
+func newTable(pk []string) *table {
+	return &table{pk: pk, rows: make(map[string]store.Row)}
+}
+
+func (t *table) clone() *table {
+	c := newTable(t.pk)
+	c.seq = t.seq
+	for k, r := range t.rows {
+		c.rows[k] = copyRow(r)
+	}
+	return c
+}
+
+// keyOf 返回行的主键索引；没有主键的表每行单独编号
+func (t *table) keyOf(r store.Row) string {
+	if len(t.pk) == 0 {
+		t.seq++
+		return "#" + strconv.Itoa(t.seq)
+	}
+	parts := make([]string, len(t.pk))
+	for i, col := range t.pk {
+		parts[i] = valueKey(r[col])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// tableSource 分片和事务获取表的方式不同：分片加锁直接访问，事务访问自己的副本
+type tableSource interface {
+	withTable(name string, write bool, fn func(t *table) error) error
+}
+
+// executor 在 tableSource 之上实现 store.Executor
+type executor struct {
+	src tableSource
+}
+
+func (e executor) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	var result []store.Row
+	err := e.src.withTable(q.Table, false, func(t *table) error {
+		result = selectRows(t, q)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// 在锁外回调，fn 中可以继续访问存储
+	for _, r := range result {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if err := fn(r); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (e executor) Count(ctx context.Context, tableName string, where []store.Cond) (int64, error) {
+	var n int64
+	err := e.src.withTable(tableName, false, func(t *table) error {
+		for _, r := range t.rows {
+			if matches(r, where) {
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (e executor) Insert(ctx context.Context, tableName string, row store.Row) error {
+	return e.src.withTable(tableName, true, func(t *table) error {
+		k := t.keyOf(row)
+		if _, ok := t.rows[k]; ok {
+			return fmt.Errorf("%w: %s", store.ErrDuplicateKey, tableName)
+		}
+		t.rows[k] = copyRow(row)
+		return nil
+	})
+}
+
+func (e executor) Upsert(ctx context.Context, tableName string, row store.Row, update []string) (store.UpsertResult, error) {
+	var result store.UpsertResult
+	err := e.src.withTable(tableName, true, func(t *table) error {
+		k := t.keyOf(row)
+		old, ok := t.rows[k]
+		if !ok {
+			result = store.UpsertInserted
+			t.rows[k] = copyRow(row)
+			return nil
+		}
+		if len(update) == 0 {
+			result = store.UpsertUnchanged
+			return nil
+		}
+		nr := copyRow(old)
+		for _, col := range update {
+			nr[col] = row[col]
+		}
+		t.rows[k] = nr
+		result = store.UpsertUpdated
+		return nil
+	})
+	return result, err
+}
+
+func (e executor) Update(ctx context.Context, tableName string, set store.Row, where []store.Cond) (int64, error) {
+	var n int64
+	err := e.src.withTable(tableName, true, func(t *table) error {
+		updated := make(map[string]store.Row)
+		for k, r := range t.rows {
+			if !matches(r, where) {
+				continue
+			}
+			nr := copyRow(r)
+			for col, v := range set {
+				nr[col] = v
+			}
+			delete(t.rows, k)
+			updated[k] = nr
+			n++
+		}
+		// 更新可能修改主键，重新索引并检查冲突
+		for _, r := range updated {
+			k := t.keyOf(r)
+			if _, ok := t.rows[k]; ok {
+				return fmt.Errorf("%w: %s", store.ErrDuplicateKey, tableName)
+			}
+			t.rows[k] = r
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (e executor) Delete(ctx context.Context, tableName string, where []store.Cond) (int64, error) {
+	var n int64
+	err := e.src.withTable(tableName, true, func(t *table) error {
+		for k, r := range t.rows {
+			if matches(r, where) {
+				delete(t.rows, k)
+				n++
+			}
+		}
+		return nil
+	})
+	return n, err
+}
+
+func (e executor) Truncate(ctx context.Context, tableName string) error {
+	return e.src.withTable(tableName, true, func(t *table) error {
+		t.rows = make(map[string]store.Row)
+		return nil
+	})
+}
+
+// selectRows 按 Query 过滤、排序、分页并投影
+func selectRows(t *table, q store.Query) []store.Row {
+	var rows []store.Row
+	for _, r := range t.rows {
+		if matches(r, q.Where) && matchesExpr(r, q.Filter) {
+			rows = append(rows, r)
+		}
+	}
+
+	// 没有指定排序时按主键排序，结果稳定
+	order := q.OrderBy
+	if len(order) == 0 {
+		order = t.pk
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		return compareRows(rows[i], rows[j], order, q.Desc) < 0
+	})
+
+	if len(q.After) > 0 {
+		after := make(store.Row, len(q.OrderBy))
+		for i, col := range q.OrderBy {
+			after[col] = q.After[i]
+		}
+		i := sort.Search(len(rows), func(i int) bool {
+			return compareRows(rows[i], after, q.OrderBy, q.Desc) > 0
+		})
+		rows = rows[i:]
+	}
+
+	var result []store.Row
+	seen := make(map[string]bool)
+	for _, r := range rows {
+		out := r
+		if len(q.Columns) > 0 {
+			out = make(store.Row, len(q.Columns))
+			for _, col := range q.Columns {
+				out[col] = r[col]
+			}
+		} else {
+			out = copyRow(r)
+		}
+
+		if q.Distinct {
+			k := fmt.Sprint(out) // fmt 按键排序输出 map
+			if len(q.Columns) > 0 {
+				k = rowKey(out, q.Columns)
+			}
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+
+		result = append(result, out)
+		if q.Limit > 0 && len(result) >= q.Limit {
+			break
+		}
+	}
+	return result
+}
+
+// matches 判断行是否满足所有等值条件；与 SQL 一致，NULL 不等于任何值
+func matches(r store.Row, where []store.Cond) bool {
+	for _, c := range where {
+		v := r[c.Column]
+		if v == nil || c.Value == nil || valueKey(v) != valueKey(c.Value) {
+			return false
+		}
+	}
+	return true
+}
+
+// compareRows 按 cols 逐列比较两行，desc 中的列降序；与 openGauss 一致，NULL 大于任何值
+func compareRows(a, b store.Row, cols []string, desc []string) int {
+	for _, col := range cols {
+		var c int
+		switch x, y := a[col], b[col]; {
+		case x == nil && y == nil:
+		case x == nil:
+			c = 1
+		case y == nil:
+			c = -1
+		default:
+			c = orderValues(x, y)
+		}
+		if slices.Contains(desc, col) {
+			c = -c
+		}
+		if c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+// orderValues 排序用的比较：数值类型按大小、时间按先后比较，与 openGauss 中 INT、TIMESTAMP 列的顺序一致；
+// 其余（包括内容像数字的字符串）按 valueKey 的字节序比较，与文本列的 COLLATE "C" 一致
+func orderValues(a, b interface{}) int {
+	if x, ok := toFloat(a); ok {
+		if y, ok := toFloat(b); ok {
+			return cmp.Compare(x, y)
+		}
+	}
+	if x, ok := a.(time.Time); ok {
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y)
+		}
+	}
+	return strings.Compare(valueKey(a), valueKey(b))
+}
+
+func toFloat(v interface{}) (float64, bool) {
+	switch x := v.(type) {
+	case int:
+		return float64(x), true
+	case int32:
+		return float64(x), true
+	case int64:
+		return float64(x), true
+	case float64:
+		return x, true
+	}
+	return 0, false
+}
+
+func rowKey(r store.Row, cols []string) string {
+	parts := make([]string, len(cols))
+	for i, col := range cols {
+		parts[i] = valueKey(r[col])
+	}
+	return strings.Join(parts, "\x00")
+}
+
+// valueKey 把值规范化为可比较的字符串：JSON 解出的 float64 整数与 int64 视为相同，
+// 这样 "1"、1、1.0 的比较结果与 openGauss 对 INT 列的隐式转换一致
+func valueKey(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	case float64:
+		if x == float64(int64(x)) {
+			return strconv.FormatInt(int64(x), 10)
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64)
+	case time.Time:
+		return x.UTC().Format(time.RFC3339Nano)
+	default:
+		return fmt.Sprint(x)
+	}
+}
+
+func copyRow(r store.Row) store.Row {
+	c := make(store.Row, len(r))
+	for k, v := range r {
+		c[k] = v
+	}
+	return c
+}
@@ -0,0 +1,186 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+
+	"my-gauss-app/store"
+)
+
+var itemTable = store.TableDef{
+	Name:       "item",
+	Columns:    []string{"id", "n"},
+	PrimaryKey: []string{"id"},
+	Sharded:    true,
+}
+
+func newShard(t *testing.T) (*Shard, string) {
+	t.Helper()
+	s, err := New([]string{"og1"}, 0, []store.TableDef{itemTable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sh := s.shards[0]
+	return sh, sh.Table(itemTable.Name)
+}
+
+func count(t *testing.T, sh *Shard, table string, where []store.Cond) int64 {
+	t.Helper()
+	n, err := sh.Count(context.Background(), table, where)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestConcurrentTransactionsKeepEachOthersWrites(t *testing.T) {
+	sh, table := newShard(t)
+	ctx := context.Background()
+
+	const workers = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, workers)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			tx, err := sh.Begin(ctx)
+			if err != nil {
+				errs <- err
+				return
+			}
+			if err := tx.Insert(ctx, table, store.Row{"id": fmt.Sprint(i), "n": int64(i)}); err != nil {
+				errs <- err
+				return
+			}
+			errs <- tx.Commit()
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := count(t, sh, table, nil); n != workers {
+		t.Errorf("rows after %d concurrent commits = %d, want %d", workers, n, workers)
+	}
+}
+
+func TestTransactionsUpdatingDifferentRows(t *testing.T) {
+	sh, table := newShard(t)
+	ctx := context.Background()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := sh.Insert(ctx, table, store.Row{"id": id, "n": int64(0)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 两个事务都在对方提交之前复制了表
+	tx1, _ := sh.Begin(ctx)
+	tx2, _ := sh.Begin(ctx)
+	if _, err := tx1.Update(ctx, table, store.Row{"n": int64(1)}, []store.Cond{{Column: "id", Value: "a"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx2.Update(ctx, table, store.Row{"n": int64(2)}, []store.Cond{{Column: "id", Value: "b"}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx2.Delete(ctx, table, []store.Cond{{Column: "id", Value: "c"}}); err != nil {
+		t.Fatal(err)
+	}
+	// 事务之外的写入
+	if err := sh.Insert(ctx, table, store.Row{"id": "d", "n": int64(3)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx2.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]int64{"a": 1, "b": 2, "d": 3}
+	rows, err := store.Select(ctx, sh, store.Query{Table: table})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("rows = %v, want %v", rows, want)
+	}
+	for _, r := range rows {
+		if n, ok := want[r["id"].(string)]; !ok || r["n"] != n {
+			t.Errorf("row %v, want n = %d", r, n)
+		}
+	}
+}
+
+func TestCommitRejectsConcurrentDuplicate(t *testing.T) {
+	sh, table := newShard(t)
+	ctx := context.Background()
+
+	tx, _ := sh.Begin(ctx)
+	if err := tx.Insert(ctx, table, store.Row{"id": "a", "n": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Insert(ctx, table, store.Row{"id": "b", "n": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.Insert(ctx, table, store.Row{"id": "a", "n": int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := tx.Commit(); !errors.Is(err, store.ErrDuplicateKey) {
+		t.Fatalf("Commit = %v, want ErrDuplicateKey", err)
+	}
+	// 事务整体不生效
+	if n := count(t, sh, table, []store.Cond{{Column: "id", Value: "b"}}); n != 0 {
+		t.Error("rows of the failed transaction were written")
+	}
+	if n := count(t, sh, table, []store.Cond{{Column: "id", Value: "a"}, {Column: "n", Value: int64(2)}}); n != 1 {
+		t.Error("the concurrently inserted row was overwritten")
+	}
+}
+
+func TestCommitPreparedKeepsConcurrentWrites(t *testing.T) {
+	sh, table := newShard(t)
+	ctx := context.Background()
+
+	tx, _ := sh.Begin(ctx)
+	if err := tx.Insert(ctx, table, store.Row{"id": "a", "n": int64(1)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Prepare(ctx, "ourdoc_t1_og1"); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.Insert(ctx, table, store.Row{"id": "b", "n": int64(2)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := sh.CommitPrepared(ctx, "ourdoc_t1_og1"); err != nil {
+		t.Fatal(err)
+	}
+	if n := count(t, sh, table, nil); n != 2 {
+		t.Errorf("rows after commit prepared = %d, want 2", n)
+	}
+}
+
+func TestUpsert(t *testing.T) {
+	sh, table := newShard(t)
+	ctx := context.Background()
+
+	if result, err := sh.Upsert(ctx, table, store.Row{"id": "a", "n": int64(1)}, []string{"n"}); err != nil || result != store.UpsertInserted {
+		t.Fatalf("first Upsert = %v, %v, want inserted", result, err)
+	}
+	if result, err := sh.Upsert(ctx, table, store.Row{"id": "a", "n": int64(2)}, []string{"n"}); err != nil || result != store.UpsertUpdated {
+		t.Fatalf("second Upsert = %v, %v, want updated", result, err)
+	}
+	if result, err := sh.Upsert(ctx, table, store.Row{"id": "a", "n": int64(3)}, nil); err != nil || result != store.UpsertUnchanged {
+		t.Fatalf("Upsert without update columns = %v, %v, want unchanged", result, err)
+	}
+	if n := count(t, sh, table, []store.Cond{{Column: "id", Value: "a"}, {Column: "n", Value: int64(2)}}); n != 1 {
+		t.Error("row after upserts, want n = 2")
+	}
+}
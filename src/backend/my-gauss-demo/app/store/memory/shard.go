@@ -0,0 +1,267 @@
+// store/memory/shard.go
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"my-gauss-app/store"
+)
+
+// Shard 一个内存分片
+type Shard struct {
+	id       int
+	name     string
+	mu       sync.RWMutex
+	tables   map[string]*table
+	prepared map[string]*Tx
+}
+
+func (s *Shard) ID() int      { return s.id }
+func (s *Shard) Name() string { return s.name }
+
+func (s *Shard) Table(baseTable string) string {
+	return fmt.Sprintf("%s_%d", baseTable, s.id)
+}
+
+func (s *Shard) withTable(name string, write bool, fn func(t *table) error) error {
+	if write {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+	} else {
+		s.mu.RLock()
+		defer s.mu.RUnlock()
+	}
+
+	t, ok := s.tables[name]
+	if !ok {
+		return fmt.Errorf("relation %q does not exist on %s", name, s.name)
+	}
+	return fn(t)
+}
+
+func (s *Shard) exec() executor { return executor{s} }
+
+func (s *Shard) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	return s.exec().Scan(ctx, q, fn)
+}
+
+func (s *Shard) Count(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	return s.exec().Count(ctx, table, where)
+}
+
+func (s *Shard) Insert(ctx context.Context, table string, row store.Row) error {
+	return s.exec().Insert(ctx, table, row)
+}
+
+func (s *Shard) Upsert(ctx context.Context, table string, row store.Row, update []string) (store.UpsertResult, error) {
+	return s.exec().Upsert(ctx, table, row, update)
+}
+
+func (s *Shard) Update(ctx context.Context, table string, set store.Row, where []store.Cond) (int64, error) {
+	return s.exec().Update(ctx, table, set, where)
+}
+
+func (s *Shard) Delete(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	return s.exec().Delete(ctx, table, where)
+}
+
+func (s *Shard) Truncate(ctx context.Context, table string) error {
+	return s.exec().Truncate(ctx, table)
+}
+
+func (s *Shard) Begin(ctx context.Context) (store.Tx, error) {
+	return &Tx{shard: s, copies: make(map[string]*table), base: make(map[string]map[string]store.Row)}, nil
+}
+
+func (s *Shard) CommitPrepared(ctx context.Context, gid string) error {
+	s.mu.Lock()
+	tx, ok := s.prepared[gid]
+	delete(s.prepared, gid)
+	s.mu.Unlock()
+	if !ok {
+		return fmt.Errorf("prepared transaction with identifier %q does not exist", gid)
+	}
+	return tx.apply()
+}
+
+func (s *Shard) RollbackPrepared(ctx context.Context, gid string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.prepared[gid]; !ok {
+		return fmt.Errorf("prepared transaction with identifier %q does not exist", gid)
+	}
+	delete(s.prepared, gid)
+	return nil
+}
+
+func (s *Shard) PreparedTransactions(ctx context.Context, prefix string) ([]store.PreparedTxn, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var txns []store.PreparedTxn
+	for gid, tx := range s.prepared {
+		if strings.HasPrefix(gid, prefix) {
+			txns = append(txns, store.PreparedTxn{GID: gid, Age: time.Since(tx.preparedAt)})
+		}
+	}
+	sort.Slice(txns, func(i, j int) bool { return txns[i].GID < txns[j].GID })
+	return txns, nil
+}
+
+// Tx 内存事务：第一次访问某张表时复制它，之后的读写都在副本上进行，提交时只把有变化的行写回分片
+type Tx struct {
+	shard  *Shard
+	copies map[string]*table
+	// base 复制时分片上各表的行，提交时与副本比较得出事务写入的行
+	base map[string]map[string]store.Row
+	done bool
+	// preparedAt Prepare 的时间，恢复时据此判断事务是否已被发起方放弃
+	preparedAt time.Time
+}
+
+func (t *Tx) withTable(name string, write bool, fn func(tb *table) error) error {
+	if t.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	c, ok := t.copies[name]
+	if !ok {
+		err := t.shard.withTable(name, false, func(tb *table) error {
+			c = tb.clone()
+			// 分片和事务都不会原地修改行，保存行的引用即可
+			base := make(map[string]store.Row, len(tb.rows))
+			for k, r := range tb.rows {
+				base[k] = r
+			}
+			t.base[name] = base
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		t.copies[name] = c
+	}
+	return fn(c)
+}
+
+func (t *Tx) exec() executor { return executor{t} }
+
+func (t *Tx) Scan(ctx context.Context, q store.Query, fn func(store.Row) error) error {
+	return t.exec().Scan(ctx, q, fn)
+}
+
+func (t *Tx) Count(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	return t.exec().Count(ctx, table, where)
+}
+
+func (t *Tx) Insert(ctx context.Context, table string, row store.Row) error {
+	return t.exec().Insert(ctx, table, row)
+}
+
+func (t *Tx) Upsert(ctx context.Context, table string, row store.Row, update []string) (store.UpsertResult, error) {
+	return t.exec().Upsert(ctx, table, row, update)
+}
+
+func (t *Tx) Update(ctx context.Context, table string, set store.Row, where []store.Cond) (int64, error) {
+	return t.exec().Update(ctx, table, set, where)
+}
+
+func (t *Tx) Delete(ctx context.Context, table string, where []store.Cond) (int64, error) {
+	return t.exec().Delete(ctx, table, where)
+}
+
+func (t *Tx) Truncate(ctx context.Context, table string) error {
+	return t.exec().Truncate(ctx, table)
+}
+
+func (t *Tx) Shard() store.Shard { return t.shard }
+
+func (t *Tx) Commit() error {
+	if t.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	t.done = true
+	return t.apply()
+}
+
+func (t *Tx) Rollback() error {
+	t.done = true
+	return nil
+}
+
+func (t *Tx) Prepare(ctx context.Context, gid string) error {
+	if t.done {
+		return fmt.Errorf("transaction already finished")
+	}
+	t.done = true
+
+	t.shard.mu.Lock()
+	defer t.shard.mu.Unlock()
+	if _, ok := t.shard.prepared[gid]; ok {
+		return fmt.Errorf("transaction identifier %q is already in use", gid)
+	}
+	t.preparedAt = time.Now()
+	t.shard.prepared[gid] = t
+	return nil
+}
+
+// apply 把事务写入的行逐行写回分片：与复制时相比新增或修改的行写入，删除的行删除；
+// 其他事务在此期间写入的行保留，同一行以后提交的为准。
+// 新插入的主键已被其他事务写入时整个事务不生效，返回 ErrDuplicateKey
+func (t *Tx) apply() error {
+	t.shard.mu.Lock()
+	defer t.shard.mu.Unlock()
+
+	for name, c := range t.copies {
+		base, current := t.base[name], t.shard.tables[name]
+		if len(c.pk) == 0 {
+			continue
+		}
+		for k := range c.rows {
+			if _, ok := base[k]; ok {
+				continue
+			}
+			if _, ok := current.rows[k]; ok {
+				return fmt.Errorf("%w: %s", store.ErrDuplicateKey, name)
+			}
+		}
+	}
+
+	for name, c := range t.copies {
+		base, current := t.base[name], t.shard.tables[name]
+		for k := range base {
+			if _, ok := c.rows[k]; !ok {
+				delete(current.rows, k)
+			}
+		}
+		for k, r := range c.rows {
+			if b, ok := base[k]; ok && sameRow(b, r) {
+				continue
+			}
+			// 没有主键的表按序号索引，其他事务可能用了同一个序号
+			if _, ok := base[k]; !ok && len(c.pk) == 0 {
+				k = current.keyOf(r)
+			}
+			current.rows[k] = r
+		}
+	}
+	return nil
+}
+
+// sameRow 两行的列和取值都相同
+func sameRow(a, b store.Row) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for col, v := range a {
+		w, ok := b[col]
+		if !ok || valueKey(v) != valueKey(w) || (v == nil) != (w == nil) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,56 @@
+// store/shardset.go
+package store
+
+import (
+	"fmt"
+
+	"my-gauss-app/shard"
+)
+
+// ShardSet 一组分片及其一致性哈希环，供各存储实现复用
+type ShardSet struct {
+	shards []Shard
+	byName map[string]Shard
+	ring   *shard.Ring
+}
+
+// NewShardSet 根据分片列表构建哈希环，vnodes <= 0 时使用默认虚拟节点数
+func NewShardSet(shards []Shard, vnodes int) (*ShardSet, error) {
+	if len(shards) == 0 {
+		return nil, fmt.Errorf("at least one shard is required")
+	}
+
+	names := make([]string, 0, len(shards))
+	byName := make(map[string]Shard, len(shards))
+	for _, s := range shards {
+		names = append(names, s.Name())
+		byName[s.Name()] = s
+	}
+
+	ring, err := shard.NewRing(names, vnodes)
+	if err != nil {
+		return nil, err
+	}
+	return &ShardSet{shards: shards, byName: byName, ring: ring}, nil
+}
+
+// Shards 返回所有分片
+func (s *ShardSet) Shards() []Shard {
+	return s.shards
+}
+
+// UserShard 不分片表固定放在第一个分片上
+func (s *ShardSet) UserShard() Shard {
+	return s.shards[0]
+}
+
+// ShardByName 根据分片名查找分片
+func (s *ShardSet) ShardByName(name string) (Shard, bool) {
+	sh, ok := s.byName[name]
+	return sh, ok
+}
+
+// Locate 返回 key 在哈希环上的分片（不考虑目录）
+func (s *ShardSet) Locate(key string) Shard {
+	return s.byName[s.ring.Locate(key)]
+}
@@ -0,0 +1,180 @@
+// store/store.go
+package store
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Row 一行数据：列名 -> 值。NULL 为 nil
+type Row = map[string]interface{}
+
+var (
+	// ErrDuplicateKey 主键或唯一约束冲突
+	ErrDuplicateKey = errors.New("duplicate key")
+	// ErrShardUnavailable 分片无法连接或已被熔断
+	ErrShardUnavailable = errors.New("shard unavailable")
+	// ErrResultUnknown 写入执行期间连接中断，语句可能已经生效也可能没有；后端不会重试，调用方需要重新读取确认
+	ErrResultUnknown = errors.New("write result unknown")
+)
+
+// Cond 等值条件 column = value，多个条件之间为 AND
+type Cond struct {
+	Column string
+	Value  interface{}
+}
+
+// Query 单表查询
+type Query struct {
+	Table    string
+	Columns  []string
+	Where    []Cond
+	Distinct bool
+	// Filter 非 nil 时结果还需满足该表达式，与 Where 之间为 AND
+	Filter *Expr
+	// OrderBy 按这些列排列，默认升序；NULL 在升序时排在最后、降序时排在最前，与 openGauss 一致
+	OrderBy []string
+	// Desc OrderBy 中降序排列的列
+	Desc []string
+	// Bytewise OrderBy 中的文本列，按字节序比较（openGauss 中为 COLLATE "C"），各分片和内存后端的顺序一致；
+	// 其余列（整数、时间等）按列类型比较
+	Bytewise []string
+	// After 非空时只返回按 OrderBy 排在 After 之后的行（降序列取更小的值），用于 keyset 分页；取值须与列类型一致
+	After []interface{}
+	// Limit 大于 0 时限制返回行数
+	Limit int
+}
+
+// Executor 单表读写操作，分片本身和分片上的事务都实现它。
+// 表名均为物理表名（document_0、user 等），由实现负责转义
+type Executor interface {
+	// Scan 执行查询，每读到一行调用一次 fn；fn 返回错误时停止扫描并返回该错误
+	Scan(ctx context.Context, q Query, fn func(Row) error) error
+	Count(ctx context.Context, table string, where []Cond) (int64, error)
+	// Insert 插入一行；主键冲突时返回的错误满足 errors.Is(err, ErrDuplicateKey)
+	Insert(ctx context.Context, table string, row Row) error
+	// Upsert 按主键原子地插入或更新一行：主键不存在时插入 row，已存在时用 row 中 update 列的值更新，
+	// update 为空时保持已有的行不变
+	Upsert(ctx context.Context, table string, row Row, update []string) (UpsertResult, error)
+	Update(ctx context.Context, table string, set Row, where []Cond) (int64, error)
+	Delete(ctx context.Context, table string, where []Cond) (int64, error)
+	Truncate(ctx context.Context, table string) error
+}
+
+// UpsertResult Executor.Upsert 对主键所在行的处理结果
+type UpsertResult int
+
+const (
+	// UpsertInserted 主键不存在，插入了新行
+	UpsertInserted UpsertResult = iota + 1
+	// UpsertUpdated 主键已存在，按 update 中的列更新了行
+	UpsertUpdated
+	// UpsertUnchanged 主键已存在且 update 为空，行保持不变
+	UpsertUnchanged
+)
+
+func (r UpsertResult) String() string {
+	switch r {
+	case UpsertInserted:
+		return "inserted"
+	case UpsertUpdated:
+		return "updated"
+	case UpsertUnchanged:
+		return "unchanged"
+	}
+	return fmt.Sprintf("UpsertResult(%d)", int(r))
+}
+
+// Tx 一个分片上的事务
+type Tx interface {
+	Executor
+	Shard() Shard
+	Commit() error
+	Rollback() error
+	// Prepare 两阶段提交的第一阶段（PREPARE TRANSACTION gid），之后事务只能通过
+	// Shard.CommitPrepared / Shard.RollbackPrepared 结束
+	Prepare(ctx context.Context, gid string) error
+}
+
+// Shard 一个存储实例
+type Shard interface {
+	Executor
+	// ID 分片编号，同时是分片表的后缀
+	ID() int
+	Name() string
+	// Table 返回逻辑表在该分片上的物理表名
+	Table(baseTable string) string
+	Begin(ctx context.Context) (Tx, error)
+	CommitPrepared(ctx context.Context, gid string) error
+	RollbackPrepared(ctx context.Context, gid string) error
+	// PreparedTransactions 返回以 prefix 开头的未决 prepared transaction
+	PreparedTransactions(ctx context.Context, prefix string) ([]PreparedTxn, error)
+}
+
+// PreparedTxn 分片上一个未决的 prepared transaction
+type PreparedTxn struct {
+	GID string
+	// Age 距 Prepare 的时间，由分片自己的时钟计算
+	Age time.Duration
+}
+
+// Store 一组分片组成的存储后端
+type Store interface {
+	Shards() []Shard
+	// UserShard 存放不分片表（user 及各元数据表）的分片
+	UserShard() Shard
+	ShardByName(name string) (Shard, bool)
+	// Locate 返回 key 在一致性哈希环上的分片
+	Locate(key string) Shard
+	Close() error
+}
+
+// ShardHealth 一个分片的健康状态
+type ShardHealth struct {
+	Shard   string `json:"shard"`
+	Healthy bool   `json:"healthy"`
+	// Error 最近一次失败的原因，健康时为空
+	Error string `json:"error,omitempty"`
+	// Since 进入当前状态的时间
+	Since time.Time `json:"since"`
+}
+
+// HealthReporter 可选接口：会检查分片健康状态的后端实现它
+type HealthReporter interface {
+	Health() []ShardHealth
+}
+
+// TableDef 表结构描述，内存实现据此建表并检查主键
+type TableDef struct {
+	Name       string
+	Columns    []string
+	PrimaryKey []string
+	// Sharded 为 true 时每个分片各有一张 Name_<ID> 表，否则只在 UserShard 上有一张 Name 表
+	Sharded bool
+	// Types 列名 -> openGauss 列类型。非空时 openGauss 后端在迁移之后按它建表（表不存在时），
+	// 为空的表由版本迁移负责创建
+	Types map[string]string
+}
+
+// Select 执行查询并返回所有行
+func Select(ctx context.Context, e Executor, q Query) ([]Row, error) {
+	var rows []Row
+	err := e.Scan(ctx, q, func(r Row) error {
+		rows = append(rows, r)
+		return nil
+	})
+	return rows, err
+}
+
+// First 执行查询并返回第一行，没有结果时返回 nil
+func First(ctx context.Context, e Executor, q Query) (Row, error) {
+	var first Row
+	q.Limit = 1
+	err := e.Scan(ctx, q, func(r Row) error {
+		first = r
+		return nil
+	})
+	return first, err
+}
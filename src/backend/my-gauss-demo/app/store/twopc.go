@@ -0,0 +1,183 @@
+// store/twopc.go
+package store
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// 两阶段提交：每个参与分片先在自己的事务里完成写入并 Prepare，
+// 全部 Prepare 成功后先在 twopc_log 中记录提交决定，再逐个 CommitPrepared。
+// 进程在记录决定之后、CommitPrepared 之前崩溃时，由服务进程启动时和之后定期执行的 RecoverPrepared 补完。
+// openGauss 需要 max_prepared_transactions > 0。
+
+// gidPrefix 本服务发起的 prepared transaction 的 gid 前缀，恢复时只处理这些事务
+const gidPrefix = "ourdoc_"
+
+var (
+	// PrepareTimeout 第一阶段（全部 Prepare 和记录决定）的时限，超时的事务由发起方回滚
+	PrepareTimeout = time.Minute
+	// RecoverAfter Prepare 之后超过这个时间仍未决的事务视为发起方已放弃，恢复时才会处理；
+	// 必须大于 PrepareTimeout，此时决定要么已经记录，要么不会再记录
+	RecoverAfter = 5 * time.Minute
+	// ResolveInterval 服务进程定期执行 RecoverPrepared 的间隔：启动时还太新的事务、
+	// 第二阶段在健康分片上失败的事务都在之后的某一轮中处理
+	ResolveInterval = time.Minute
+)
+
+// TwoPCLogTable twopc_log 表结构，存放在 UserShard 上
+var TwoPCLogTable = TableDef{
+	Name:       "twopc_log",
+	Columns:    []string{"txn_id", "decision", "participants", "created_at"},
+	PrimaryKey: []string{"txn_id"},
+}
+
+// CommitTwoPhase 以 txnID 提交所有参与者事务：任一参与者 Prepare 失败则全部回滚。
+// 提交决定记录之后写入已经确定生效，第二阶段在某些分片上失败时只记录日志、返回 nil，
+// 这些分片由 RecoverPrepared 补完（在那之前读不到这次写入），调用方不应当作失败重试或报告。
+// 函数返回后调用方不应再使用 txs
+func CommitTwoPhase(ctx context.Context, s Store, txnID string, txs []Tx) error {
+	prepared := make([]string, len(txs))
+	// 回滚和第二阶段不随调用方取消而中断，否则 prepared transaction 会一直占着锁直到恢复
+	finishCtx := context.WithoutCancel(ctx)
+	// 超过 PrepareTimeout 不再记录决定，保证恢复时看到的老事务的决定已经确定
+	prepareCtx, cancel := context.WithTimeout(ctx, PrepareTimeout)
+	defer cancel()
+	abort := func() {
+		for i, tx := range txs {
+			var err error
+			if prepared[i] != "" {
+				err = tx.Shard().RollbackPrepared(finishCtx, prepared[i])
+			} else {
+				err = tx.Rollback()
+			}
+			if err != nil {
+				log.Printf("2PC: rollback on %s failed: %v", tx.Shard().Name(), err)
+			}
+		}
+	}
+
+	// 第一阶段：Prepare
+	for i, tx := range txs {
+		gid := fmt.Sprintf("%s%s_%s", gidPrefix, txnID, tx.Shard().Name())
+		if err := tx.Prepare(prepareCtx, gid); err != nil {
+			abort()
+			return fmt.Errorf("prepare on %s failed: %v", tx.Shard().Name(), err)
+		}
+		prepared[i] = gid
+	}
+
+	// 记录提交决定；记录失败视为未决定，全部回滚
+	names := make([]string, len(txs))
+	for i, tx := range txs {
+		names[i] = tx.Shard().Name()
+	}
+	err := s.UserShard().Insert(prepareCtx, TwoPCLogTable.Name, Row{
+		"txn_id":       txnID,
+		"decision":     "commit",
+		"participants": strings.Join(names, ","),
+		"created_at":   time.Now(),
+	})
+	if err != nil {
+		abort()
+		return fmt.Errorf("record 2PC decision failed: %v", err)
+	}
+
+	// 第二阶段：CommitPrepared。决定已落盘，失败的分片留给 RecoverPrepared 处理，决定日志保留到那时
+	failed := false
+	for i, tx := range txs {
+		if err := tx.Shard().CommitPrepared(finishCtx, prepared[i]); err != nil {
+			log.Printf("2PC: commit prepared %s on %s failed, will be resolved on recovery: %v", prepared[i], tx.Shard().Name(), err)
+			failed = true
+		}
+	}
+	if failed {
+		return nil
+	}
+
+	if _, err := s.UserShard().Delete(finishCtx, TwoPCLogTable.Name, []Cond{{"txn_id", txnID}}); err != nil {
+		log.Printf("2PC: clean up log for %s failed: %v", txnID, err)
+	}
+	return nil
+}
+
+// RecoverPrepared 处理所有分片上遗留的 prepared transaction：
+// twopc_log 中记录了提交决定的执行 CommitPrepared，没有决定的执行 RollbackPrepared。
+// 只处理 Prepare 之后超过 RecoverAfter 的事务，更新的可能还有发起方在等待记录决定；
+// 服务进程在启动时执行一次，之后每隔 ResolveInterval 执行一次
+func RecoverPrepared(ctx context.Context, s Store) {
+	resolvePrepared(ctx, s, s.Shards(), true)
+}
+
+// CommitDecided 只在分片 sh 上提交超过 RecoverAfter、且 twopc_log 中记录了提交决定的事务，
+// 不回滚也不清理决定日志，用于运行中分片恢复可用时补完第二阶段
+func CommitDecided(ctx context.Context, s Store, sh Shard) {
+	resolvePrepared(ctx, s, []Shard{sh}, false)
+}
+
+// resolvePrepared 处理 shards 上超过 RecoverAfter 的 prepared transaction；
+// rollback 为 false 时跳过没有提交决定的事务。rollback 为 true 时 shards 须为全部分片，
+// 处理完后删除所有参与者都已提交的决定日志
+func resolvePrepared(ctx context.Context, s Store, shards []Shard, rollback bool) {
+	logShard := s.UserShard()
+
+	committed := make(map[string]bool)
+	// unresolved 还有参与者未处理（失败或太新）的事务，保留其决定日志
+	unresolved := make(map[string]bool)
+	// 有分片无法访问时，它上面可能还有已决定提交的参与者，保留全部决定日志等下次恢复
+	incomplete := false
+	for _, sh := range shards {
+		txns, err := sh.PreparedTransactions(ctx, gidPrefix)
+		if err != nil {
+			log.Printf("2PC recovery: list prepared transactions on %s failed: %v", sh.Name(), err)
+			incomplete = true
+			continue
+		}
+
+		for _, p := range txns {
+			txnID := strings.TrimSuffix(strings.TrimPrefix(p.GID, gidPrefix), "_"+sh.Name())
+			if p.Age < RecoverAfter {
+				unresolved[txnID] = true
+				continue
+			}
+
+			n, err := logShard.Count(ctx, TwoPCLogTable.Name, []Cond{{"txn_id", txnID}, {"decision", "commit"}})
+			if err != nil {
+				log.Printf("2PC recovery: read decision for %s failed: %v", txnID, err)
+				unresolved[txnID] = true
+				continue
+			}
+
+			action := "rollback"
+			resolve := sh.RollbackPrepared
+			if n > 0 {
+				action = "commit"
+				resolve = sh.CommitPrepared
+				committed[txnID] = true
+			} else if !rollback {
+				continue
+			}
+			if err := resolve(ctx, p.GID); err != nil {
+				log.Printf("2PC recovery: %s %s on %s failed: %v", action, p.GID, sh.Name(), err)
+				unresolved[txnID] = true
+				continue
+			}
+			log.Printf("2PC recovery: %s %s on %s", action, p.GID, sh.Name())
+		}
+	}
+
+	if !rollback || incomplete {
+		return
+	}
+	for txnID := range committed {
+		if unresolved[txnID] {
+			continue
+		}
+		if _, err := logShard.Delete(ctx, TwoPCLogTable.Name, []Cond{{"txn_id", txnID}}); err != nil {
+			log.Printf("2PC recovery: clean up log for %s failed: %v", txnID, err)
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"my-gauss-app/store"
+	"my-gauss-app/store/memory"
+)
+
+var noteTable = store.TableDef{
+	Name:       "note",
+	Columns:    []string{"id", "body"},
+	PrimaryKey: []string{"id"},
+	Sharded:    true,
+}
+
+func newStore(t *testing.T) *memory.Store {
+	t.Helper()
+	s, err := memory.New([]string{"og1", "og2"}, 0, []store.TableDef{noteTable, store.TwoPCLogTable})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// writeAll 在每个分片上开启事务并写入一行，返回这些事务
+func writeAll(t *testing.T, s store.Store, id string) []store.Tx {
+	t.Helper()
+	ctx := context.Background()
+	var txs []store.Tx
+	for _, sh := range s.Shards() {
+		tx, err := sh.Begin(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := tx.Insert(ctx, sh.Table(noteTable.Name), store.Row{"id": id, "body": "x"}); err != nil {
+			t.Fatal(err)
+		}
+		txs = append(txs, tx)
+	}
+	return txs
+}
+
+// prepareAll 模拟发起方在 Prepare 之后崩溃：各分片的事务停留在 prepared 状态，
+// decided 为 true 时已记录提交决定
+func prepareAll(t *testing.T, s store.Store, txnID string, decided bool) {
+	t.Helper()
+	ctx := context.Background()
+	for _, tx := range writeAll(t, s, txnID) {
+		if err := tx.Prepare(ctx, "ourdoc_"+txnID+"_"+tx.Shard().Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if decided {
+		err := s.UserShard().Insert(ctx, store.TwoPCLogTable.Name, store.Row{
+			"txn_id": txnID, "decision": "commit", "participants": "og1,og2", "created_at": time.Now(),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// noteCount 返回 id 在所有分片上的行数
+func noteCount(t *testing.T, s store.Store, id string) int64 {
+	t.Helper()
+	var n int64
+	for _, sh := range s.Shards() {
+		c, err := sh.Count(context.Background(), sh.Table(noteTable.Name), []store.Cond{{"id", id}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += c
+	}
+	return n
+}
+
+func preparedCount(t *testing.T, s store.Store) int {
+	t.Helper()
+	n := 0
+	for _, sh := range s.Shards() {
+		txns, err := sh.PreparedTransactions(context.Background(), "ourdoc_")
+		if err != nil {
+			t.Fatal(err)
+		}
+		n += len(txns)
+	}
+	return n
+}
+
+func logCount(t *testing.T, s store.Store) int64 {
+	t.Helper()
+	n, err := s.UserShard().Count(context.Background(), store.TwoPCLogTable.Name, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func setRecoverAfter(t *testing.T, d time.Duration) {
+	old := store.RecoverAfter
+	store.RecoverAfter = d
+	t.Cleanup(func() { store.RecoverAfter = old })
+}
+
+func TestCommitTwoPhase(t *testing.T) {
+	s := newStore(t)
+	if err := store.CommitTwoPhase(context.Background(), s, "t1", writeAll(t, s, "t1")); err != nil {
+		t.Fatal(err)
+	}
+	if n := noteCount(t, s, "t1"); n != 2 {
+		t.Errorf("rows after commit = %d, want 2", n)
+	}
+	if n := preparedCount(t, s); n != 0 {
+		t.Errorf("%d prepared transactions left after commit", n)
+	}
+	if n := logCount(t, s); n != 0 {
+		t.Errorf("%d decision rows left after commit", n)
+	}
+}
+
+// failingTx Prepare 总是失败的事务
+type failingTx struct {
+	store.Tx
+}
+
+func (failingTx) Prepare(ctx context.Context, gid string) error {
+	return errors.New("prepare refused")
+}
+
+func TestCommitTwoPhaseAbortsWhenPrepareFails(t *testing.T) {
+	s := newStore(t)
+	txs := writeAll(t, s, "t1")
+	txs[1] = failingTx{txs[1]}
+
+	if err := store.CommitTwoPhase(context.Background(), s, "t1", txs); err == nil {
+		t.Fatal("CommitTwoPhase succeeded with a failing participant")
+	}
+	if n := noteCount(t, s, "t1"); n != 0 {
+		t.Errorf("rows after abort = %d, want 0", n)
+	}
+	if n := preparedCount(t, s); n != 0 {
+		t.Errorf("%d prepared transactions left after abort", n)
+	}
+	if n := logCount(t, s); n != 0 {
+		t.Errorf("%d decision rows recorded for an aborted transaction", n)
+	}
+}
+
+// commitFailingShard CommitPrepared 总是失败的分片
+type commitFailingShard struct {
+	store.Shard
+}
+
+func (commitFailingShard) CommitPrepared(ctx context.Context, gid string) error {
+	return errors.New("connection lost")
+}
+
+// commitFailingTx 第二阶段在 commitFailingShard 上执行的事务
+type commitFailingTx struct {
+	store.Tx
+}
+
+func (tx commitFailingTx) Shard() store.Shard {
+	return commitFailingShard{tx.Tx.Shard()}
+}
+
+func TestCommitTwoPhaseSucceedsOnceDecided(t *testing.T) {
+	setRecoverAfter(t, 0)
+	s := newStore(t)
+	txs := writeAll(t, s, "t1")
+	txs[1] = commitFailingTx{txs[1]}
+
+	// 决定已经记录，第二阶段的失败不报告给调用方
+	if err := store.CommitTwoPhase(context.Background(), s, "t1", txs); err != nil {
+		t.Fatalf("CommitTwoPhase after the decision = %v, want nil", err)
+	}
+	if n := preparedCount(t, s); n != 1 {
+		t.Errorf("prepared transactions = %d, want the failed participant left for recovery", n)
+	}
+	if n := logCount(t, s); n != 1 {
+		t.Errorf("decision rows = %d, want 1 kept for recovery", n)
+	}
+
+	store.RecoverPrepared(context.Background(), s)
+	if n := noteCount(t, s, "t1"); n != 2 {
+		t.Errorf("rows after recovery = %d, want 2", n)
+	}
+	if n := logCount(t, s); n != 0 {
+		t.Errorf("%d decision rows left after recovery", n)
+	}
+}
+
+func TestRecoverPrepared(t *testing.T) {
+	setRecoverAfter(t, 0)
+	s := newStore(t)
+	prepareAll(t, s, "decided", true)
+	prepareAll(t, s, "undecided", false)
+
+	store.RecoverPrepared(context.Background(), s)
+
+	if n := noteCount(t, s, "decided"); n != 2 {
+		t.Errorf("decided rows after recovery = %d, want 2", n)
+	}
+	if n := noteCount(t, s, "undecided"); n != 0 {
+		t.Errorf("undecided rows after recovery = %d, want 0", n)
+	}
+	if n := preparedCount(t, s); n != 0 {
+		t.Errorf("%d prepared transactions left after recovery", n)
+	}
+	if n := logCount(t, s); n != 0 {
+		t.Errorf("%d decision rows left after recovery", n)
+	}
+}
+
+func TestRecoverPreparedResolvesRecentTransactionsLater(t *testing.T) {
+	setRecoverAfter(t, 50*time.Millisecond)
+	s := newStore(t)
+	prepareAll(t, s, "decided", true)
+	prepareAll(t, s, "undecided", false)
+
+	// 启动时事务还太新，发起方可能还在等待记录决定，不能处理
+	store.RecoverPrepared(context.Background(), s)
+	if n := preparedCount(t, s); n != 4 {
+		t.Fatalf("prepared transactions after the first pass = %d, want 4", n)
+	}
+
+	// 超过 RecoverAfter 之后的一轮处理补完它们
+	time.Sleep(60 * time.Millisecond)
+	store.RecoverPrepared(context.Background(), s)
+	if n := preparedCount(t, s); n != 0 {
+		t.Errorf("%d prepared transactions left after the second pass", n)
+	}
+	if n := noteCount(t, s, "decided"); n != 2 {
+		t.Errorf("decided rows = %d, want 2", n)
+	}
+	if n := noteCount(t, s, "undecided"); n != 0 {
+		t.Errorf("undecided rows = %d, want 0", n)
+	}
+	if n := logCount(t, s); n != 0 {
+		t.Errorf("%d decision rows left", n)
+	}
+}
+
+func TestCommitDecidedLeavesUndecided(t *testing.T) {
+	setRecoverAfter(t, 0)
+	s := newStore(t)
+	prepareAll(t, s, "decided", true)
+	prepareAll(t, s, "undecided", false)
+
+	sh := s.Shards()[1]
+	store.CommitDecided(context.Background(), s, sh)
+
+	n, err := sh.Count(context.Background(), sh.Table(noteTable.Name), []store.Cond{{"id", "decided"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("decided rows on %s = %d, want 1", sh.Name(), n)
+	}
+	txns, err := sh.PreparedTransactions(context.Background(), "ourdoc_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(txns) != 1 || txns[0].GID != "ourdoc_undecided_"+sh.Name() {
+		t.Errorf("prepared transactions on %s = %v, want only the undecided one", sh.Name(), txns)
+	}
+	// 其他分片上的参与者还未提交，决定日志必须保留
+	if n := logCount(t, s); n != 1 {
+		t.Errorf("decision rows = %d, want 1", n)
+	}
+}
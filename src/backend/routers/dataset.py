@@ -1,4 +1,5 @@
 import httpx
+import json
 import zlib
 from typing import Union, List, Dict, Tuple, Optional
 from fastapi import APIRouter, HTTPException
@@ -20,18 +21,24 @@ class GoDatasetClient:
     def read_dataset(self, dataset_name: str, main_key: Union[str, Tuple], goal_key: str = "*"):
         params = {"dataset_name": dataset_name, "main_key": str(main_key), "goal_key": goal_key}
         resp = self.client.get(f"{self.base_url}/read", params=params)
+        # 没有这一行时返回 404（error.code 为 not_found）
+        if resp.status_code == 404:
+            return None
         resp.raise_for_status()
         return resp.json()["result"]
         
-    # 条件查询数据库
+    # 条件查询数据库，只返回第一条匹配的行或字段（single=true），没有匹配时返回 None
     def read_dataset_condition(self, dataset_name: str, key_name: str, key_value: str, goal_key: str = "*"):
         params = {
             "dataset_name": dataset_name,
             "key_name": key_name,
             "key_value": key_value,
-            "goal_key": goal_key
+            "goal_key": goal_key,
+            "single": "true"
         }
         resp = self.client.get(f"{self.base_url}/read_condition", params=params)
+        if resp.status_code == 404:
+            return None
         resp.raise_for_status()
         return resp.json()["result"]
     
@@ -42,6 +49,16 @@ class GoDatasetClient:
         resp.raise_for_status()
         return resp.json()
     
+    # 按主键插入或更新：主键已存在时只覆盖 update_columns 中的列（None 表示 data 中除主键外的所有列）
+    # 返回 "inserted" 或 "updated"
+    def upsert_dataset(self, dataset_name: str, data: Dict, update_columns: Optional[List[str]] = None):
+        payload = {"dataset_name": dataset_name, "data": data}
+        if update_columns is not None:
+            payload["update_columns"] = update_columns
+        resp = self.client.post(f"{self.base_url}/upsert", json=payload)
+        resp.raise_for_status()
+        return resp.json()["result"]
+    
     # 修改数据库中某个键的值
     def modify_dataset_condition(self, dataset_name: str, key_name, key_value, goal_key: str, goal_value):
         payload = {
@@ -52,6 +69,8 @@ class GoDatasetClient:
             "goal_value": goal_value
         }
         resp = self.client.post(f"{self.base_url}/modify", json=payload)
+        if resp.status_code == 404:
+            return False
         resp.raise_for_status()
         return resp.json()["modified"]
     
@@ -75,29 +94,47 @@ class GoDatasetClient:
         else:
             raise ValueError("keys 必须是 str 或 tuple")
         
-    # 查询多个值
-    def read_multidataset_condition(self, dataset_name: str, keys, condition_key, condition_value):
-        if dataset_name == "user":
-            key = "id"
-        elif dataset_name == "document":
-            key = "room_id"
-        elif dataset_name == "permission":
-            key = ("room_id", "user_id")
-        elif dataset_name == "content":
-            key = "room_id"
-        all_rows = self.read_dataset(dataset_name,"*", "*")  # 读取整表
-        if not all_rows:
-            return []
+    # 查询多个值：返回所有满足 condition_key == condition_value 的行
+    def read_multidataset_condition(self, dataset_name: str, keys, condition_key, condition_value,
+                                    order_by: Optional[str] = None, limit: Optional[int] = None):
         if isinstance(keys, str):
-            return [row.get(keys) for row in all_rows if row.get(condition_key) == condition_value]
+            goal_keys = keys
         elif isinstance(keys, tuple):
-            return [
-                {k: row.get(k) for k in keys} 
-                for row in all_rows 
-                if row.get(condition_key) == condition_value
-            ]
+            goal_keys = ",".join(keys)
         else:
             raise ValueError("keys 必须是 str 或 tuple")
+        params = {
+            "dataset_name": dataset_name,
+            "key_name": condition_key,
+            "key_value": condition_value,
+            "goal_keys": goal_keys
+        }
+        if order_by:
+            params["order_by"] = order_by
+        if limit:
+            params["limit"] = limit
+        resp = self.client.get(f"{self.base_url}/read_condition", params=params)
+        resp.raise_for_status()
+        rows = resp.json()["result"]
+        if isinstance(keys, str):
+            return [row.get(keys) for row in rows]
+        return [{k: row.get(k) for k in keys} for row in rows]
+    
+    # 按过滤表达式查询，例如 {"and": [{"column": "user_id", "op": "=", "value": "u1"}, ...]}
+    def read_dataset_filter(self, dataset_name: str, filter: Dict, goal_keys: str = "*",
+                            order_by: Optional[str] = None, limit: Optional[int] = None):
+        params = {
+            "dataset_name": dataset_name,
+            "filter": json.dumps(filter),
+            "goal_keys": goal_keys
+        }
+        if order_by:
+            params["order_by"] = order_by
+        if limit:
+            params["limit"] = limit
+        resp = self.client.get(f"{self.base_url}/read_condition", params=params)
+        resp.raise_for_status()
+        return resp.json()["result"]
     
     # 删除数据库中的行（单字段或复合主键）
     def remove_dataset_mainkey(self, dataset_name: str, main_key: Union[str, Tuple], main_value: Union[str, Tuple]):
@@ -107,6 +144,8 @@ class GoDatasetClient:
             "main_value": main_value
         }
         resp = self.client.post(f"{self.base_url}/remove", json=payload)
+        if resp.status_code == 404:
+            return False
         resp.raise_for_status()
         return resp.json().get("success", False)
         
@@ -385,9 +424,11 @@ def update_visibility_dataset(room_id: str, overall_permission: int) -> bool:
 # 给房间添加用户权限
 def add_user_permission_dataset(room_id: str, user_id: str, permission: int) -> bool:
     try:
-        dataset_client.insert_data_into_dataset(
+        # 用户已在房间中时只更新权限
+        dataset_client.upsert_dataset(
             "permission",
-            {"room_id": room_id, "user_id": user_id, "permission": permission}
+            {"room_id": room_id, "user_id": user_id, "permission": permission},
+            ["permission"]
         )
         return True
     except Exception as e: